@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	securityv1beta1 "istio.io/api/security/v1beta1"
+)
+
+const (
+	ambientSkip  = "skip"
+	ambientAuthz = "authz"
+
+	istioSecurityBeta1API    = "security.istio.io/v1beta1"
+	authorizationPolicyKind  = "AuthorizationPolicy"
+	ambientDataplaneSelector = "istio.io/dataplane-mode=ambient"
+	// labels of the ambient namespaces whose services are behind a waypoint, and of the namespace of the waypoint
+	// when it's not the same
+	useWaypointLabel          = "istio.io/use-waypoint"
+	useWaypointNamespaceLabel = "istio.io/use-waypoint-namespace"
+)
+
+// Returns the namespaces in ambient mode in the cluster, and the namespace of the waypoint of the ones behind one
+func detectAmbientNamespaces(kubectl *Kubectl) ([]string, map[string]string, error) {
+	out, err := kubectl.run(nil, "get", "namespaces", "-l", ambientDataplaneSelector, "-o", "json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to detect ambient namespaces: %w", err)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ambient namespaces: %w", err)
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	waypoints := make(map[string]string)
+	for _, ns := range list.Items {
+		namespaces = append(namespaces, ns.Metadata.Name)
+		// "none" opts the namespace out of a waypoint set higher up
+		if waypoint := ns.Metadata.Labels[useWaypointLabel]; waypoint == "" || waypoint == "none" {
+			continue
+		}
+		waypoints[ns.Metadata.Name] = ns.Metadata.Name
+		if wns := ns.Metadata.Labels[useWaypointNamespaceLabel]; wns != "" {
+			waypoints[ns.Metadata.Name] = wns
+		}
+	}
+	debug("ambient namespaces in the cluster: %v, with waypoints: %v", namespaces, waypoints)
+	return namespaces, waypoints, nil
+}
+
+// Calls to services in ambient mode behind a waypoint go through the waypoint, so sidecar-ed sources must reach its
+// service too. Adds the namespace of the waypoint of each destination to the calls, when it is another one.
+func addWaypointNamespaces(waypoints map[string]string, graph *Graph) {
+	if len(waypoints) == 0 {
+		return
+	}
+	for _, call := range graph.Calls {
+		targets := call.TargetNamespaces
+		for _, ns := range call.TargetNamespaces {
+			if wns, ok := waypoints[ns]; ok && wns != ns {
+				targets = mergeSorted(targets, []string{wns})
+				explainf(explainGraph, "call %s: destination namespace %q is behind a waypoint, allowing its namespace %q", call.ID, ns, wns)
+			}
+		}
+		call.TargetNamespaces = targets
+	}
+}
+
+// Sidecar resources don't apply to namespaces in ambient mode, so drops the Sidecars generated for them. In authz
+// mode, their reachability is enforced by ztunnel instead: each ambient namespace gets an AuthorizationPolicy that
+// only allows the namespaces observed calling it.
+func handleAmbient(runtime *Runtime, graph *Graph, results []*typesv2.Object) ([]*typesv2.Object, error) {
+	if len(runtime.ambientNamespaces) == 0 {
+		return results, nil
+	}
+
+	out := make([]*typesv2.Object, 0, len(results))
+	for _, obj := range results {
+		ns := obj.GetMetadata().GetNamespace()
+		if obj.GetKind() == api.IstioSidecarKind && slices.Contains(runtime.ambientNamespaces, ns) {
+			diagnose(diagAmbientSidecar, ns, objectName(obj))
+			emitEvent(&Event{Type: eventResourceDropped, Kind: obj.GetKind(), Name: objectName(obj)})
+			continue
+		}
+		out = append(out, obj)
+	}
+
+	// destination ambient namespace => observed source namespaces
+	callers := make(map[string][]string)
+	warned := make(map[string]bool)
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil {
+			continue
+		}
+		group := call.SourceTrafficGroup.FQN
+		if call.SourceTrafficGroup.ConfigMode != "DIRECT" && !warned[group] && containsAny(runtime.ambientNamespaces, call.SourceNamespaces) {
+			warned[group] = true
+			diagnose(diagAmbientGroup, group)
+		}
+		for _, dest := range call.TargetNamespaces {
+			if slices.Contains(runtime.ambientNamespaces, dest) {
+				callers[dest] = mergeSorted(callers[dest], call.SourceNamespaces)
+			}
+		}
+	}
+	if runtime.ambientMode != ambientAuthz {
+		return out, nil
+	}
+
+	destinations := maps.Keys(callers)
+	slices.Sort(destinations)
+	for _, dest := range destinations {
+		sources := mergeSorted(callers[dest], []string{dest})
+		for _, host := range baselineHosts {
+			sources = mergeSorted(sources, []string{hostNamespace(host)})
+		}
+		policy, err := newObject(istioSecurityBeta1API, authorizationPolicyKind,
+			&typesv2.ObjectMeta{Name: "reachability-ambient", Namespace: dest},
+			&securityv1beta1.AuthorizationPolicy{
+				Action: securityv1beta1.AuthorizationPolicy_ALLOW,
+				Rules: []*securityv1beta1.Rule{{
+					From: []*securityv1beta1.Rule_From{{
+						Source: &securityv1beta1.Source{Namespaces: sources},
+					}},
+				}},
+			})
+		if err != nil {
+			return nil, err
+		}
+		explainf(explainPolicy, "AuthorizationPolicy %s/%s: allowing ambient namespace %q to be called from %v", dest, policy.Metadata.Name, dest, sources)
+		emitGenerated(policy)
+		out = append(out, policy)
+	}
+	return out, nil
+}
@@ -1,17 +1,93 @@
 package main
 
+import "fmt"
+
 type TopologyResponse struct {
 	Nodes []struct {
-		ID             string `json:"id"`
-		AggregationKey string `json:"name"`
+		ID             string   `json:"id"`
+		AggregationKey string   `json:"name"`
+		Type           string   `json:"type"`
+		IsReal         bool     `json:"isReal"`
+		Layers         []string `json:"layers"`
 	} `json:"nodes"`
 	Calls []struct {
-		ID     string `json:"id"`
-		Source string `json:"source"`
-		Target string `json:"target"`
+		ID               string   `json:"id"`
+		Source           string   `json:"source"`
+		SourceComponents []string `json:"sourceComponents"`
+		Target           string   `json:"target"`
+		TargetComponents []string `json:"targetComponents"`
+		// Where the call was detected: CLIENT, SERVER or PROXY
+		DetectPoints []string `json:"detectPoints"`
 	} `json:"calls"`
 }
 
+// Adds the nodes and calls of the other topology this one doesn't have. Nodes are matched by their aggregation key
+// rather than their ID, as the IDs of the same service can differ between responses, e.g. of different windows,
+// steps or shards of a query. The calls of the other topology are remapped to the IDs of the nodes in this one, and
+// matched by their source and target. Returns the number of calls added.
+func (t *TopologyResponse) merge(other *TopologyResponse) int {
+	// aggregation key => ID of the node in this topology
+	ids := make(map[string]string, len(t.Nodes))
+	taken := make(map[string]bool, len(t.Nodes))
+	for _, n := range t.Nodes {
+		ids[nodeKey(n.ID, n.AggregationKey, n.IsReal)] = n.ID
+		taken[n.ID] = true
+	}
+	// ID of the node in the other topology => ID in this one
+	remap := make(map[string]string, len(other.Nodes))
+	for _, n := range other.Nodes {
+		key := nodeKey(n.ID, n.AggregationKey, n.IsReal)
+		if id, ok := ids[key]; ok {
+			remap[n.ID] = id
+			continue
+		}
+		// the ID of a new node may be the one of another node here
+		id := n.ID
+		for i := 1; taken[id]; i++ {
+			id = fmt.Sprintf("%s~%d", n.ID, i)
+		}
+		if id != n.ID {
+			debug("topology node %q (%q) has the ID of another node, remapped to %q", n.ID, n.AggregationKey, id)
+		}
+		ids[key], taken[id], remap[n.ID] = id, true, id
+		n.ID = id
+		t.Nodes = append(t.Nodes, n)
+	}
+
+	calls := make(map[string]bool, len(t.Calls))
+	for _, c := range t.Calls {
+		calls[c.Source+" "+c.Target] = true
+	}
+	added := 0
+	for _, c := range other.Calls {
+		source, target := remap[c.Source], remap[c.Target]
+		if source == "" || target == "" {
+			debug("topology call %q has an unknown node, skipping it", c.ID)
+			continue
+		}
+		if source != c.Source || target != c.Target {
+			// skywalking names calls after their nodes
+			c.ID = source + "-" + target
+			c.Source, c.Target = source, target
+		}
+		if !calls[c.Source+" "+c.Target] {
+			calls[c.Source+" "+c.Target] = true
+			t.Calls = append(t.Calls, c)
+			added++
+		}
+	}
+	return added
+}
+
+// Returns the key topology nodes are matched by across responses: their aggregation key and whether they're real,
+// as skywalking gives virtual nodes the name of the service they stand for, or their ID without an aggregation key
+func nodeKey(id, aggregationKey string, real bool) string {
+	if aggregationKey == "" {
+		return "id:" + id
+	}
+	return fmt.Sprintf("%s real:%t", aggregationKey, real)
+}
+
 type Service struct {
 	FQN         string `json:"fqn"`
 	DisplayName string `json:"displayName"`
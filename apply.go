@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// Applies the generated objects to the cluster as Kubernetes manifests. Every object is validated first with a
+// server-side dry-run, and if any of them is rejected nothing is applied, so the batch is never half applied.
+// Writes are conditional on the objects not changing since they were validated: existing objects are applied with
+// the resourceVersion they had, and new ones created, so a concurrent change makes the apply fail rather than being
+// overwritten. The manifests hold the whole desired state, so applying them again never appends hosts twice.
+// With --dry-run, nothing is applied and the changes are printed as a diff instead: against the live resources
+// with "server", or as new resources without talking to the cluster with "client". With --apply-max-risk, the changes
+// riskier than it are queued for approval instead of applied. The hosts of the live objects whose origin is baseline or
+// manual are kept rather than pruned.
+func apply(runtime *Runtime, results []*typesv2.Object) error {
+	manifests := make([][]byte, 0, len(results))
+	for _, obj := range results {
+		manifest, err := kubernetesYAML(obj, runtime.gitopsNamespace)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, manifest)
+	}
+	if runtime.dryRun == dryRunClient {
+		for i, obj := range results {
+			printDiff(obj.GetKind()+"/"+objectName(obj), nil, manifests[i])
+		}
+		return nil
+	}
+
+	var rejected []string
+	live := make([]*hostsObject, len(results))
+	versions := make([]string, len(results))
+	for i, obj := range results {
+		var err error
+		if live[i], err = getLiveObject(runtime.kubectl, manifests[i]); err != nil {
+			return fmt.Errorf("failed to get %s %s: %w", obj.GetKind(), objectName(obj), err)
+		}
+		if live[i] != nil {
+			versions[i] = live[i].Metadata.ResourceVersion
+			kept, err := keepProtectedHosts(obj, live[i])
+			if err != nil {
+				return err
+			}
+			if kept {
+				if manifests[i], err = kubernetesYAML(obj, runtime.gitopsNamespace); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := runtime.kubectl.run(manifests[i], "apply", "--dry-run=server", "-f", "-"); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s %s: %v", obj.GetKind(), objectName(obj), err))
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("server-side dry-run rejected %d of %d objects, nothing was applied:\n  %s",
+			len(rejected), len(results), strings.Join(rejected, "\n  "))
+	}
+	debug("server-side dry-run accepted all %d objects", len(results))
+	if runtime.dryRun == dryRunServer {
+		for i, obj := range results {
+			diff, err := runtime.kubectl.diff(manifests[i])
+			if err != nil {
+				return fmt.Errorf("failed to diff %s %s: %w", obj.GetKind(), objectName(obj), err)
+			}
+			fmt.Fprint(logOut, string(diff))
+		}
+		return nil
+	}
+
+	resources := generatedResources(runtime, results)
+	for i, obj := range results {
+		args := []string{"create", "--save-config", "-f", "-"}
+		manifest := manifests[i]
+		if versions[i] != "" {
+			args = []string{"apply", "-f", "-"}
+			var err error
+			if manifest, err = kubernetesYAMLAt(obj, runtime.gitopsNamespace, versions[i]); err != nil {
+				return err
+			}
+		}
+		if runtime.applyMaxRisk != "" {
+			risk, reason, err := changeRisk(manifests[i], live[i])
+			if err != nil {
+				return fmt.Errorf("failed to assess the change to %s %s: %w", obj.GetKind(), objectName(obj), err)
+			}
+			if !riskAllowed(risk, runtime.applyMaxRisk) {
+				if err := queueChange(resources[i], risk, reason, manifest, versions[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			debug("%s: applying, the risk of the change (%s: %s) is within --apply-max-risk", resources[i], risk, reason)
+		}
+		out, err := runtime.kubectl.run(manifest, args...)
+		if err != nil {
+			if isConflict(err) {
+				return fmt.Errorf("%s %s changed since it was validated, after applying %d of %d objects; generate again to include the change: %w",
+					obj.GetKind(), objectName(obj), i, len(results), err)
+			}
+			return fmt.Errorf("failed to apply %s %s, after applying %d of %d objects: %w", obj.GetKind(), objectName(obj), i, len(results), err)
+		}
+		warn("%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Returns whether kubectl failed because the object changed or was created concurrently
+func isConflict(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "the object has been modified") || strings.Contains(msg, "AlreadyExists") ||
+		strings.Contains(msg, "already exists")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// Returns a kubectl running the shell script, which records the arguments of every call and what it was fed
+func fakeKubectl(t *testing.T, script string) *Kubectl {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kubectl")
+	script = "#!/bin/sh\necho \"$*\" >> " + dir + "/calls\ncat > " + dir + "/stdin-$(wc -l < " + dir + "/calls | tr -d ' ')\n" + script
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return &Kubectl{path: path}
+}
+
+// Returns the arguments of the calls the fake kubectl got
+func kubectlCalls(t *testing.T, kubectl *Kubectl) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(kubectl.path), "calls"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// Returns what the fake kubectl was fed in the nth call, from 1
+func kubectlStdin(t *testing.T, kubectl *Kubectl, n int) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(kubectl.path), "stdin-"+strconv.Itoa(n)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Captures what is logged while running f
+func captureLog(t *testing.T, f func()) string {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := logOut
+	logOut = &buf
+	defer func() { logOut = prev }()
+	f()
+	return buf.String()
+}
+
+func TestApply(t *testing.T) {
+	const live = `{"metadata": {"resourceVersion": "42"}, "spec": {"egress": [{"hosts": ["istio-system/*"]}]}}`
+	tests := []struct {
+		name        string
+		script      string
+		wantCalls   []string
+		wantVersion bool
+		wantErr     string
+	}{
+		{
+			name:      "new object",
+			wantCalls: []string{"get --ignore-not-found -o json -f -", "apply --dry-run=server -f -", "create --save-config -f -"},
+		},
+		{
+			name:        "existing object",
+			script:      "case \"$1\" in get) echo '" + live + "';; esac\n",
+			wantCalls:   []string{"get --ignore-not-found -o json -f -", "apply --dry-run=server -f -", "apply -f -"},
+			wantVersion: true,
+		},
+		{
+			name:      "rejected by the dry-run",
+			script:    "case \"$*\" in *--dry-run=server*) echo denied >&2; exit 1;; esac\n",
+			wantCalls: []string{"get --ignore-not-found -o json -f -", "apply --dry-run=server -f -"},
+			wantErr:   "nothing was applied",
+		},
+		{
+			name:        "changed since validated",
+			script:      "case \"$1\" in get) echo '" + live + "';; esac\ncase \"$*\" in \"apply -f -\") echo 'the object has been modified' >&2; exit 1;; esac\n",
+			wantCalls:   []string{"get --ignore-not-found -o json -f -", "apply --dry-run=server -f -", "apply -f -"},
+			wantVersion: true,
+			wantErr:     "changed since it was validated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := fakeKubectl(t, tt.script)
+			runtime := &Runtime{kubectl: kubectl, dryRun: dryRunNone, gitopsNamespace: "gitops"}
+			var err error
+			captureLog(t, func() { err = apply(runtime, []*typesv2.Object{testSidecar(t, "a", "istio-system/*", "b/*")}) })
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("apply() error = %v, want %q", err, tt.wantErr)
+			}
+			calls := kubectlCalls(t, kubectl)
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Fatalf("kubectl calls = %q, want %q", calls, tt.wantCalls)
+			}
+			applied := kubectlStdin(t, kubectl, len(calls))
+			if got := strings.Contains(applied, "resourceVersion: \"42\""); got != tt.wantVersion {
+				t.Errorf("applied manifest with the validated resourceVersion = %v, want %v:\n%s", got, tt.wantVersion, applied)
+			}
+		})
+	}
+}
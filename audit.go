@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// The subset of a Sidecar or TrafficSetting document (as printed by this tool or tctl) that we need
+// to know which hosts it allows.
+type PolicyResource struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name         string            `json:"name"`
+		Namespace    string            `json:"namespace"`
+		Organization string            `json:"organization"`
+		Tenant       string            `json:"tenant"`
+		Workspace    string            `json:"workspace"`
+		Group        string            `json:"group"`
+		Annotations  map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		// Sidecar
+		Egress []struct {
+			Hosts []string `json:"hosts"`
+		} `json:"egress"`
+		// TrafficSetting
+		Fqn          string `json:"fqn"`
+		Reachability struct {
+			Mode  string   `json:"mode"`
+			Hosts []string `json:"hosts"`
+		} `json:"reachability"`
+	} `json:"spec"`
+}
+
+// Returns a short human readable name of the resource
+func (r *PolicyResource) String() string {
+	if r.Kind == api.TrafficSettingKind {
+		return fmt.Sprintf("%s %s", r.Kind, r.GroupFQN())
+	}
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Metadata.Namespace, r.Metadata.Name)
+}
+
+// Returns the FQN of the traffic group a TrafficSetting belongs to
+func (r *PolicyResource) GroupFQN() string {
+	m := r.Metadata
+	return fmt.Sprintf("organizations/%s/tenants/%s/workspaces/%s/trafficgroups/%s", m.Organization, m.Tenant, m.Workspace, m.Group)
+}
+
+// Returns every host the resource allows reaching
+func (r *PolicyResource) Hosts() []string {
+	if r.Kind == api.TrafficSettingKind {
+		return r.Spec.Reachability.Hosts
+	}
+	var hosts []string
+	for _, e := range r.Spec.Egress {
+		hosts = append(hosts, e.Hosts...)
+	}
+	return hosts
+}
+
+var yamlSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// Reads the Sidecar and TrafficSetting documents in a multi-document YAML file; other kinds are ignored
+func loadPolicyResources(path string) ([]*PolicyResource, error) {
+	data, err := readInput("--file", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", inputName(path), err)
+	}
+
+	var out []*PolicyResource
+	for i, doc := range yamlSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		r := &PolicyResource{}
+		if err := yaml.Unmarshal([]byte(doc), r); err != nil {
+			return nil, fmt.Errorf("failed to parse document %d in %q: %w", i, inputName(path), err)
+		}
+		if r.Kind != api.IstioSidecarKind && r.Kind != api.TrafficSettingKind {
+			debug("ignoring document %d of kind %q in %q", i, r.Kind, inputName(path))
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func newAuditCmd(runtime *Runtime) *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "List hosts allowed by existing Sidecars and TrafficSettings that are not justified by the observed topology",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return fmt.Errorf("policy file (-f or --file) can't be empty")
+			}
+			resources, err := loadPolicyResources(file)
+			if err != nil {
+				return err
+			}
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			audit(cmd.OutOrStdout(), runtime, graph, resources)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "YAML file with the existing Sidecar and TrafficSetting resources to audit. REQUIRED")
+	return cmd
+}
+
+// Returns the namespace part of a Sidecar or reachability host, e.g. "bookinfo" for "bookinfo/*"
+func hostNamespace(host string) string {
+	ns, _, _ := strings.Cut(host, "/")
+	return ns
+}
+
+// Returns the namespaces the calls observed for the resource are targeting
+func observedDestinations(graph *Graph, r *PolicyResource) []string {
+	var dests []string
+	for _, call := range graph.Calls {
+		switch r.Kind {
+		case api.TrafficSettingKind:
+			if call.SourceTrafficGroup == nil || call.SourceTrafficGroup.FQN != r.GroupFQN() {
+				continue
+			}
+		default:
+			if !slices.Contains(call.SourceNamespaces, r.Metadata.Namespace) {
+				continue
+			}
+		}
+		dests = append(dests, call.TargetNamespaces...)
+	}
+	return dests
+}
+
+func audit(out io.Writer, runtime *Runtime, graph *Graph, resources []*PolicyResource) {
+	fmt.Fprintf(out, "hosts with no supporting call between %s and %s:\n",
+		runtime.start.Format(DATE_FORMAT), runtime.end.Format(DATE_FORMAT))
+
+	unjustified := 0
+	for _, r := range resources {
+		dests := observedDestinations(graph, r)
+
+		var hosts []string
+		for _, host := range r.Hosts() {
+			if slices.Contains(baselineHosts, host) || slices.Contains(dests, hostNamespace(host)) {
+				continue
+			}
+			hosts = append(hosts, host)
+		}
+		if len(hosts) == 0 {
+			debug("all hosts of %s are justified", r)
+			continue
+		}
+
+		fmt.Fprintf(out, "  %s:\n", r)
+		for _, host := range hosts {
+			if hostNamespace(host) == "*" {
+				fmt.Fprintf(out, "    %s (wildcard; allows every namespace)\n", host)
+			} else {
+				fmt.Fprintf(out, "    %s\n", host)
+			}
+		}
+		unjustified += len(hosts)
+	}
+
+	if unjustified == 0 {
+		fmt.Fprintf(out, "  none; every allowed host is used\n")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "golang.org/x/exp/slices"
+
+// Returns whether the reverse of the call should be added to the graph as well, per --assume-bidirectional
+func shouldMirror(runtime *Runtime, call *Call) bool {
+	if !runtime.assumeBidirectional {
+		return false
+	}
+	if len(runtime.bidirectionalNamespaces) > 0 &&
+		!containsAny(runtime.bidirectionalNamespaces, call.SourceNamespaces) &&
+		!containsAny(runtime.bidirectionalNamespaces, call.TargetNamespaces) {
+		return false
+	}
+	if len(runtime.bidirectionalComponents) > 0 && !containsAny(runtime.bidirectionalComponents, call.Components) {
+		return false
+	}
+	return true
+}
+
+// Returns whether any of the values is in the list
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if slices.Contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Phases of a generation run, timed for the --summary-file and limited by the --budget
+const (
+	// getting the services and the topology
+	phaseTopology = "topology"
+	// building the graph of calls, and enriching them with their metrics
+	phaseGraph = "graph"
+	// generating the resources and checking them
+	phaseGeneration = "generation"
+	// writing, pushing and applying the resources, and recording the state
+	phaseOutput = "output"
+)
+
+// Budget of the whole run, along the ones of its phases
+const budgetTotal = "total"
+
+var budgetNames = []string{budgetTotal, phaseTopology, phaseGraph, phaseGeneration, phaseOutput}
+
+// What a run does when it exceeds a --budget
+const (
+	onBudgetAbort   = "abort"
+	onBudgetDegrade = "degrade"
+)
+
+// Parses the --budget, a map of phase, or budgetTotal, to the longest it may take
+func parseBudgets(values map[string]string) (map[string]time.Duration, error) {
+	budgets := make(map[string]time.Duration, len(values))
+	for name, value := range values {
+		if !slices.Contains(budgetNames, name) {
+			return nil, fmt.Errorf("invalid --budget %q, must be one of %q", name, budgetNames)
+		}
+		d, err := parseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid --budget %s=%q, must be a positive duration", name, value)
+		}
+		budgets[name] = d
+	}
+	return budgets, nil
+}
+
+// How long a phase of the run took, for the --summary-file
+type PhaseTiming struct {
+	Phase    string `json:"phase"`
+	Duration string `json:"duration"`
+}
+
+// The requests a run sent to an API endpoint, for --debug and the --summary-file
+type APICalls struct {
+	Endpoint string `json:"endpoint"`
+	// requests sent, and the ones answered from the cache instead
+	Count  int `json:"count"`
+	Cached int `json:"cached,omitempty"`
+	// time spent waiting for the responses to the requests sent, failovers and retries included
+	Duration string `json:"duration"`
+
+	took time.Duration
+}
+
+// Times the phases of a generation run, and checks them against the --budget as they go
+type runTiming struct {
+	start      time.Time
+	budgets    map[string]time.Duration
+	onExceeded string
+
+	// the phase in progress, and when it started
+	phase        string
+	phaseStarted time.Time
+	phases       []*PhaseTiming
+	// whether a budget was exceeded with --on-budget-exceeded=degrade, skipping what's optional from then on
+	degraded bool
+
+	// requests sent to each endpoint
+	mu       sync.Mutex
+	apiCalls map[string]*APICalls
+}
+
+// The timing of the generation run in progress, nil outside of one. Its methods do nothing when it's nil, so the
+// commands sharing the phases with generate aren't timed.
+var timing *runTiming
+
+func newRunTiming(budgets map[string]time.Duration, onExceeded string) *runTiming {
+	return &runTiming{start: time.Now(), budgets: budgets, onExceeded: onExceeded}
+}
+
+// Starts timing the phase
+func (t *runTiming) begin(phase string) {
+	if t == nil {
+		return
+	}
+	t.phase, t.phaseStarted = phase, time.Now()
+	emitEvent(&Event{Type: eventPhaseStarted, Phase: phase})
+}
+
+// Stops timing the phase in progress, and checks it and the whole run against their budgets
+func (t *runTiming) end() error {
+	if t == nil || t.phase == "" {
+		return nil
+	}
+	d := time.Since(t.phaseStarted)
+	t.phases = append(t.phases, &PhaseTiming{Phase: t.phase, Duration: d.Round(time.Millisecond).String()})
+	debug("phase %s took %s", t.phase, d)
+	emitEvent(&Event{Type: eventPhaseCompleted, Phase: t.phase, Duration: d.Round(time.Millisecond).String()})
+	phase := t.phase
+	t.phase = ""
+	return t.check(phase, d)
+}
+
+// Checks the time spent in the phase in progress so far and in the whole run against their budgets, so the long
+// loops of a phase can stop rather than run past them
+func (t *runTiming) checkProgress() error {
+	if t == nil || t.phase == "" {
+		return nil
+	}
+	return t.check(t.phase, time.Since(t.phaseStarted))
+}
+
+// Returns an error if the phase or the whole run exceeded its budget, unless --on-budget-exceeded=degrade, which
+// reports it once instead and skips what's optional from then on
+func (t *runTiming) check(phase string, d time.Duration) error {
+	name, took := phase, d
+	// within its own budget, or without one, the phase may still take the whole run past its budget
+	if budget, ok := t.budgets[phase]; !ok || d <= budget {
+		name, took = budgetTotal, time.Since(t.start)
+	}
+	budget, ok := t.budgets[name]
+	if !ok || took <= budget {
+		return nil
+	}
+	if t.onExceeded != onBudgetDegrade {
+		return fmt.Errorf("the run exceeded its %s budget of %s after %s, in the %s phase", name, budget, took.Round(time.Millisecond), phase)
+	}
+	if !t.degraded {
+		t.degraded = true
+		diagnose(diagBudgetExceeded, name, budget, took.Round(time.Millisecond), phase)
+	}
+	return nil
+}
+
+// Returns whether the optional step must be skipped, a budget having been exceeded with --on-budget-exceeded=degrade
+func (t *runTiming) skip(step string) bool {
+	if t == nil || !t.degraded {
+		return false
+	}
+	warn("skipping %s, the run exceeded its budget", step)
+	return true
+}
+
+// Returns the timing of the phases run so far, for the --summary-file
+func (t *runTiming) timings() []*PhaseTiming {
+	if t == nil {
+		return nil
+	}
+	return t.phases
+}
+
+// Records a request to the endpoint, which took d to answer, or that the cache answered
+func (t *runTiming) apiCall(endpoint string, d time.Duration, cached bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.apiCalls == nil {
+		t.apiCalls = make(map[string]*APICalls)
+	}
+	calls, ok := t.apiCalls[endpoint]
+	if !ok {
+		calls = &APICalls{Endpoint: endpoint}
+		t.apiCalls[endpoint] = calls
+	}
+	if cached {
+		calls.Cached++
+		return
+	}
+	calls.Count++
+	calls.took += d
+	calls.Duration = calls.took.Round(time.Millisecond).String()
+}
+
+// Returns the requests sent to each endpoint so far, sorted by endpoint, for the --summary-file
+func (t *runTiming) apiCallCounts() []*APICalls {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	calls := maps.Values(t.apiCalls)
+	slices.SortFunc(calls, func(a, b *APICalls) int { return strings.Compare(a.Endpoint, b.Endpoint) })
+	return calls
+}
+
+// Logs the requests sent to each endpoint with --debug, at the end of the run
+func (t *runTiming) debugAPICalls() {
+	for _, calls := range t.apiCallCounts() {
+		debug("endpoint %s: %d requests in %s, %d answered from the cache", calls.Endpoint, calls.Count, calls.took, calls.Cached)
+	}
+}
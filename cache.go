@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Categories of cached TSB responses, which --refresh can bypass separately
+const (
+	cacheTopology = "topology"
+	cacheOrgs     = "orgs"
+	cacheServices = "services"
+	cacheGroups   = "groups"
+	cacheSettings = "settings"
+)
+
+var cacheCategories = []string{cacheTopology, cacheOrgs, cacheServices, cacheGroups, cacheSettings}
+
+// Persists the successful responses from TSB on disk, keyed by request, so that repeated runs don't fetch the data
+// again while it is fresh.
+type responseCache struct {
+	dir string
+	ttl time.Duration
+	// categories whose cached responses are ignored, and replaced by fresh ones
+	refresh []string
+}
+
+// Returns the response cache configured by the flags, or nil unless --cache enables it
+func newResponseCache(cfg *Config) (*responseCache, error) {
+	if !cfg.cache || cfg.noCache {
+		return nil, nil
+	}
+	for _, category := range cfg.refresh {
+		if !slices.Contains(cacheCategories, category) {
+			return nil, fmt.Errorf("invalid --refresh %q, must be one of %v", category, cacheCategories)
+		}
+	}
+
+	dir := cfg.cacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the user cache directory, set --cache-dir: %w", err)
+		}
+		dir = filepath.Join(userDir, "generate-sidecar-tool")
+	}
+	return &responseCache{dir: dir, ttl: cfg.cacheTTL, refresh: cfg.refresh}, nil
+}
+
+// Returns the file the response to the request is cached in. Requests are told apart by method, URL, body and
+// the hash of the credentials they are sent with, as different credentials may see different data.
+func (rc *responseCache) path(category string, req *http.Request, credential string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", req.Method, req.URL.String(), credential)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", fmt.Errorf("failed to read request body: %w", err)
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+	return filepath.Join(rc.dir, category, hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+// Returns the cached response in the file, if any and still fresh
+func (rc *responseCache) get(category, path string) ([]byte, bool) {
+	if slices.Contains(rc.refresh, category) {
+		return nil, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > rc.ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		debug("failed to read cached response %q: %v", path, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// Caches the response in the file. Failing to is not fatal, the next run just fetches it again.
+func (rc *responseCache) put(path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		debug("failed to create cache directory: %v", err)
+		return
+	}
+	// write to a temporary file first, so that concurrent runs never read a partial response
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		debug("failed to cache response in %q: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		debug("failed to cache response in %q: %v", path, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/anypb"
+	"istio.io/api/networking/v1beta1"
+)
+
+// Quirks of the namespaces the egress listener of their Sidecars must account for
+const (
+	// hostNetwork workloads share the network of the node, so their outbound traffic can't be captured with iptables:
+	// the application sends it to the listener on localhost explicitly
+	quirkHostNetwork = "hostnetwork"
+	// workloads relying on the DNS proxy of the sidecar, which only captures their DNS queries with the
+	// ISTIO_META_DNS_CAPTURE proxy metadata
+	quirkDNSProxy = "dns-proxy"
+)
+
+const (
+	proxyConfigKind = "ProxyConfig"
+	// name of the ProxyConfig setting the proxy metadata of the dns-proxy namespaces
+	dnsCaptureProxyConfig = "reachability-dns-capture"
+	dnsCaptureMetadata    = "ISTIO_META_DNS_CAPTURE"
+)
+
+// Port of the localhost egress listener of hostnetwork namespaces, unless given with hostnetwork:<port>
+const defaultHostNetworkPort = 15080
+
+// A --sidecar-quirks entry
+type SidecarQuirk struct {
+	Name string
+	// port of the localhost listener, for hostnetwork
+	Port uint32
+}
+
+// Parses a --sidecar-quirks value: "dns-proxy", "hostnetwork" or "hostnetwork:<port>"
+func parseSidecarQuirk(value string) (*SidecarQuirk, error) {
+	name, port, hasPort := strings.Cut(value, ":")
+	switch name {
+	case quirkDNSProxy:
+		if hasPort {
+			return nil, fmt.Errorf("invalid --sidecar-quirks %q: %s takes no port", value, quirkDNSProxy)
+		}
+		return &SidecarQuirk{Name: name}, nil
+	case quirkHostNetwork:
+		quirk := &SidecarQuirk{Name: name, Port: defaultHostNetworkPort}
+		if hasPort {
+			n, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid --sidecar-quirks %q: invalid port %q", value, port)
+			}
+			quirk.Port = uint32(n)
+		}
+		return quirk, nil
+	}
+	return nil, fmt.Errorf("invalid --sidecar-quirks %q, must be one of %q or %q[:<port>]", value, quirkDNSProxy, quirkHostNetwork)
+}
+
+// Adds a localhost egress listener for the hosts of the Sidecars of the hostnetwork namespaces, and returns the
+// results with a ProxyConfig enabling the DNS capture of each dns-proxy namespace. The TrafficSettings of BRIDGED
+// groups can't add the listener, so the groups selecting hostnetwork namespaces are reported instead.
+func applySidecarQuirks(quirks map[string]*SidecarQuirk, graph *Graph, results []*typesv2.Object) ([]*typesv2.Object, error) {
+	if len(quirks) == 0 {
+		return results, nil
+	}
+
+	for _, obj := range results {
+		quirk, ok := quirks[obj.GetMetadata().GetNamespace()]
+		if obj.GetKind() != api.IstioSidecarKind || !ok || quirk.Name != quirkHostNetwork {
+			continue
+		}
+		sidecar := &v1beta1.Sidecar{}
+		if err := obj.GetSpec().UnmarshalTo(sidecar); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal Sidecar %s: %w", objectName(obj), err)
+		}
+		// the listeners with a port go before the catch-all ones, which keep capturing the traffic of the pods
+		// that aren't on the host network
+		var hosts []string
+		for _, egress := range sidecar.Egress {
+			hosts = mergeSorted(hosts, egress.Hosts)
+		}
+		sidecar.Egress = append([]*v1beta1.IstioEgressListener{{
+			Port:        &v1beta1.Port{Number: quirk.Port, Protocol: "HTTP", Name: "http-egress"},
+			Bind:        "127.0.0.1",
+			CaptureMode: v1beta1.CaptureMode_NONE,
+			Hosts:       hosts,
+		}}, sidecar.Egress...)
+		any, err := anypb.New(sidecar)
+		if err != nil {
+			return nil, fmt.Errorf("creating anypb: %w", err)
+		}
+		obj.Spec = any
+		debug("applied the %s quirk to Sidecar %s", quirk.Name, objectName(obj))
+	}
+
+	namespaces := maps.Keys(quirks)
+	slices.Sort(namespaces)
+	for _, ns := range namespaces {
+		if quirks[ns].Name != quirkDNSProxy {
+			continue
+		}
+		// applied when the proxies are injected, so the pods of the namespace need a restart to pick it up
+		config, err := newObject(api.IstioNetworkingBeta1API, proxyConfigKind,
+			&typesv2.ObjectMeta{Name: dnsCaptureProxyConfig, Namespace: ns},
+			&v1beta1.ProxyConfig{EnvironmentVariables: map[string]string{dnsCaptureMetadata: "true"}})
+		if err != nil {
+			return nil, err
+		}
+		explainf(explainPolicy, "ProxyConfig %s/%s: enabling the DNS capture of the dns-proxy namespace", ns, dnsCaptureProxyConfig)
+		emitGenerated(config)
+		results = append(results, config)
+	}
+
+	// group FQN => hostnetwork namespaces it selects
+	bridged := make(map[string][]string)
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil || call.SourceTrafficGroup.ConfigMode == "DIRECT" {
+			continue
+		}
+		for _, ns := range call.SourceNamespaces {
+			if quirk, ok := quirks[ns]; ok && quirk.Name == quirkHostNetwork {
+				bridged[call.SourceTrafficGroup.FQN] = mergeSorted(bridged[call.SourceTrafficGroup.FQN], []string{ns})
+			}
+		}
+	}
+	groups := maps.Keys(bridged)
+	slices.Sort(groups)
+	for _, group := range groups {
+		diagnose(diagQuirkGroup, group, strings.Join(bridged[group], ", "))
+	}
+	return results, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Kinds of the TSB resources whose changes can alter which namespaces a group selects
+var membershipKinds = []string{"Workspace", "TrafficGroup"}
+
+// A change to a TSB resource, from the audit log
+type Change struct {
+	FQN        string    `json:"fqn"`
+	Kind       string    `json:"kind"`
+	Operation  string    `json:"operation"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// Calls TSB's audit log endpoint for each of the organizations, returning the changes made after since, oldest first.
+// The audit log changes all the time, so it's never cached.
+func (c *TSBHttpClient) ListChanges(since time.Time) ([]Change, error) {
+	var changes []Change
+	for _, org := range c.orgs {
+		endpoint, err := c.endpoint(endpointAuditLogs, "organizations/"+org)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{"recursive": {"true"}, "sinceTimestamp": {since.UTC().Format(time.RFC3339Nano)}}
+		req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		status, body, err := c.doTSB(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the audit log of organization %q: %w", org, err)
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to get the audit log of organization %q: unexpected status %d", org, status)
+		}
+
+		type respData struct {
+			AuditLogs []Change `json:"auditLogs"`
+		}
+		out := &respData{}
+		if err = json.Unmarshal(body, out); err != nil {
+			return nil, fmt.Errorf("failed to parse the audit log of organization %q: %w", org, err)
+		}
+		changes = append(changes, out.AuditLogs...)
+	}
+	slices.SortStableFunc(changes, func(a, b Change) int { return a.CreateTime.Compare(b.CreateTime) })
+	return changes, nil
+}
+
+// Returns the FQNs of the workspaces and groups whose membership the changes may have altered, sorted
+func changedMembership(changes []Change) []string {
+	var fqns []string
+	for _, c := range changes {
+		if slices.Contains(membershipKinds, c.Kind) {
+			debug("%s %s %s at %s", c.Operation, c.Kind, c.FQN, c.CreateTime.Format(time.RFC3339))
+			fqns = mergeSorted(fqns, []string{c.FQN})
+		}
+	}
+	return fqns
+}
+
+// Keeps only the calls from the traffic groups in the changed workspaces and groups, for a selective regeneration
+func selectChangedGroups(changed []string, graph *Graph) {
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup != nil && inChanged(changed, call.SourceTrafficGroup.FQN) {
+			calls = append(calls, call)
+			continue
+		}
+		explainf(explainGraph, "call %s: skipped, its traffic group didn't change", call.ID)
+	}
+	graph.Calls = calls
+}
+
+// Returns the services in the traffic groups of the changed workspaces and groups, the only ones whose topology a
+// selective regeneration needs
+func changedServices(runtime *Runtime, services []Service) ([]Service, error) {
+	var selected []Service
+	for i := range services {
+		tg, err := runtime.client.LookupTrafficGroup(&services[i])
+		if err != nil {
+			return nil, err
+		}
+		if tg != nil && inChanged(runtime.changed, tg.FQN) {
+			selected = append(selected, services[i])
+		}
+	}
+	return selected, nil
+}
+
+// Returns whether the group is one of the changed FQNs, or in one of them
+func inChanged(changed []string, groupFQN string) bool {
+	for _, fqn := range changed {
+		if groupFQN == fqn || strings.HasPrefix(groupFQN, fqn+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// A source namespace allowed to reach a destination namespace, as the --change-summary tells them apart
+type allowedDestination struct {
+	source      string
+	destination string
+}
+
+func (d allowedDestination) String() string {
+	return fmt.Sprintf("namespace %s can reach namespace %s", d.source, d.destination)
+}
+
+// Returns the destinations the edges allow, by the tenant of their traffic group. Edges of no traffic group allow
+// nothing, as no policy is generated for them. Names are redacted with the redactor, if not nil.
+func allowedDestinations(edges []*Edge, redact *redactor) map[string]map[allowedDestination]bool {
+	allowed := make(map[string]map[allowedDestination]bool)
+	for _, e := range edges {
+		if e.TrafficGroup == "" {
+			continue
+		}
+		meta, err := parseFQN(e.TrafficGroup)
+		if err != nil {
+			debug("edge %s: %v", e, err)
+			continue
+		}
+		tenant := redact.tenant(meta.Tenant)
+		if allowed[tenant] == nil {
+			allowed[tenant] = make(map[allowedDestination]bool)
+		}
+		for _, src := range e.SourceNamespaces {
+			for _, dest := range e.TargetNamespaces {
+				allowed[tenant][allowedDestination{source: redact.namespace(src), destination: redact.namespace(dest)}] = true
+			}
+		}
+	}
+	return allowed
+}
+
+// Writes a summary of the destinations allowed and no longer allowed since the previous run, by tenant, in plain
+// words so it can go to a change advisory board as is
+func writeChangeSummary(out io.Writer, previous *State, graph *Graph, redact *redactor) {
+	before := allowedDestinations(previous.Edges, redact)
+	after := allowedDestinations(maps.Values(graphEdges(graph)), redact)
+
+	tenants := append(maps.Keys(before), maps.Keys(after)...)
+	slices.Sort(tenants)
+	tenants = slices.Compact(tenants)
+
+	fmt.Fprintf(out, "Changes to the allowed destinations since the run at %s\n", previous.Time.UTC().Format(time.RFC3339))
+	changes := 0
+	for _, tenant := range tenants {
+		var added, removed []string
+		for d := range after[tenant] {
+			if !before[tenant][d] {
+				added = append(added, d.String())
+			}
+		}
+		for d := range before[tenant] {
+			if !after[tenant][d] {
+				removed = append(removed, fmt.Sprintf("namespace %s can no longer reach namespace %s", d.source, d.destination))
+			}
+		}
+		if len(added)+len(removed) == 0 {
+			continue
+		}
+		changes += len(added) + len(removed)
+		slices.Sort(added)
+		slices.Sort(removed)
+		fmt.Fprintf(out, "\nTenant %s: %d new allowed destination(s), %d removed\n", tenant, len(added), len(removed))
+		for _, line := range append(added, removed...) {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+	}
+	if changes == 0 {
+		fmt.Fprintln(out, "\nNo changes")
+	}
+}
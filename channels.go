@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Channels of explanations that can be enabled with --explain
+const (
+	// how the topology and services are turned into the graph of calls
+	explainGraph = "graph"
+	// why each host is allowed by each generated resource
+	explainPolicy = "policy"
+	// the calls made to TSB
+	explainAPI = "api"
+)
+
+var explainChannels = []string{explainGraph, explainPolicy, explainAPI}
+
+// Prints an explanation in the given channel, if enabled. Unlike debug logs, explanations are meant to be read
+// by users to understand the output of the tool.
+var explainf = func(channel, format string, a ...any) {}
+
+// Enables the explanation channels in the comma separated list (or "all" of them), writing them to the
+// file if given, or with the rest of the diagnostics otherwise.
+func setupExplain(channels []string, file string) error {
+	if len(channels) == 1 && channels[0] == "all" {
+		channels = explainChannels
+	}
+	for _, c := range channels {
+		if !slices.Contains(explainChannels, c) {
+			return fmt.Errorf("invalid --explain channel %q, must be one of %s, or all", c, strings.Join(explainChannels, ", "))
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	out := logOut
+	if file != "" {
+		// the file is not buffered, so there is nothing to flush and it's closed on exit
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("failed to create --explain-file %q: %w", file, err)
+		}
+		out = &redactingWriter{w: f}
+	}
+
+	explainf = func(channel, format string, a ...any) {
+		if slices.Contains(channels, channel) {
+			fmt.Fprintf(out, "["+channel+"] "+format+"\n", a...)
+		}
+	}
+	return nil
+}
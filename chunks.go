@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	chunkIndexFile = "index.yaml"
+	// name of the chunk files, by their index
+	chunkFileFormat = "resources-%03d.yaml"
+)
+
+// The index of the chunks written to the --output-dir
+type chunkIndex struct {
+	Chunks []chunkEntry `json:"chunks"`
+}
+
+type chunkEntry struct {
+	File      string `json:"file"`
+	Documents int    `json:"documents"`
+	Bytes     int    `json:"bytes"`
+}
+
+// Writes the resources as YAML to files in the directory, with at most maxDocs documents and maxSize bytes each (no
+// limit if zero), along an index listing them. A document larger than maxSize gets a file of its own. The chunks of
+// a previous run are removed first, so none is left over when there are fewer now.
+func writeChunks(dir string, maxDocs int, maxSize int, results []*typesv2.Object, sidecarOutput string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create --output-dir %q: %w", dir, err)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, strings.Replace(chunkFileFormat, "%03d", "*", 1)))
+	if err != nil {
+		return fmt.Errorf("failed to list the chunks in %q: %w", dir, err)
+	}
+	for _, f := range stale {
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("failed to remove stale chunk %q: %w", f, err)
+		}
+	}
+
+	// each document is written to the chunk it goes in as soon as it's rendered, rather than rendering every chunk
+	// before writing any
+	index := &chunkIndex{Chunks: []chunkEntry{}}
+	var chunk *os.File
+	closeChunk := func() error {
+		if chunk == nil {
+			return nil
+		}
+		err := chunk.Close()
+		chunk = nil
+		if err != nil {
+			return fmt.Errorf("failed to write chunk %q: %w", index.Chunks[len(index.Chunks)-1].File, err)
+		}
+		return nil
+	}
+	defer closeChunk()
+
+	var doc bytes.Buffer
+	for _, r := range results {
+		doc.Reset()
+		data, err := resourceYAML(&doc, r, sidecarOutput)
+		if err != nil {
+			return err
+		}
+		if maxSize > 0 && len(data) > maxSize {
+			warn("%s %s is %d bytes, more than --max-file-size, writing it to a file of its own", r.GetKind(), r.GetMetadata().GetName(), len(data))
+		}
+
+		last := len(index.Chunks) - 1
+		if last < 0 || (maxDocs > 0 && index.Chunks[last].Documents >= maxDocs) || (maxSize > 0 && index.Chunks[last].Bytes+len(data) > maxSize) {
+			if err := closeChunk(); err != nil {
+				return err
+			}
+			name := fmt.Sprintf(chunkFileFormat, len(index.Chunks))
+			if chunk, err = os.Create(filepath.Join(dir, name)); err != nil {
+				return fmt.Errorf("failed to create chunk %q: %w", name, err)
+			}
+			index.Chunks = append(index.Chunks, chunkEntry{File: name})
+			last++
+		}
+		if _, err := chunk.Write(data); err != nil {
+			return fmt.Errorf("failed to write chunk %q: %w", index.Chunks[last].File, err)
+		}
+		index.Chunks[last].Documents++
+		index.Chunks[last].Bytes += len(data)
+	}
+	if err := closeChunk(); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the chunk index: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, chunkIndexFile), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write the chunk index: %w", err)
+	}
+	debug("wrote %d resources in %d chunks to %q", len(results), len(index.Chunks), dir)
+	return nil
+}
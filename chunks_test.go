@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"google.golang.org/protobuf/types/known/anypb"
+	"istio.io/api/networking/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+// Returns a Sidecar in the namespace allowing the hosts
+func testSidecar(t *testing.T, ns string, hosts ...string) *typesv2.Object {
+	t.Helper()
+	spec, err := anypb.New(&v1beta1.Sidecar{Egress: []*v1beta1.IstioEgressListener{{Hosts: hosts}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &typesv2.Object{
+		ApiVersion: api.IstioNetworkingBeta1API,
+		Kind:       api.IstioSidecarKind,
+		Metadata:   &typesv2.ObjectMeta{Namespace: ns, Name: "reachability-sidecar"},
+		Spec:       spec,
+	}
+}
+
+func TestWriteChunks(t *testing.T) {
+	var results []*typesv2.Object
+	for i := 0; i < 5; i++ {
+		results = append(results, testSidecar(t, fmt.Sprintf("ns-%d", i), "istio-system/*"))
+	}
+	// large enough for a chunk of its own with a small --max-file-size
+	results = append(results, testSidecar(t, "big", strings.Repeat("x", 500)+"/*"))
+
+	tests := []struct {
+		name    string
+		maxDocs int
+		maxSize int
+		// documents of each chunk
+		want []int
+	}{
+		{name: "no limits", want: []int{6}},
+		{name: "max documents", maxDocs: 2, want: []int{2, 2, 2}},
+		{name: "max size", maxSize: 400, want: []int{2, 2, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// left over from a previous run with more chunks
+			stale := filepath.Join(dir, fmt.Sprintf(chunkFileFormat, 9))
+			if err := os.WriteFile(stale, []byte("---\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := writeChunks(dir, tt.maxDocs, tt.maxSize, results, sidecarOutputK8s); err != nil {
+				t.Fatalf("writeChunks() error = %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, chunkIndexFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			index := &chunkIndex{}
+			if err := yaml.Unmarshal(data, index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Chunks) != len(tt.want) {
+				t.Fatalf("writeChunks() wrote %d chunks, want %d: %+v", len(index.Chunks), len(tt.want), index.Chunks)
+			}
+			for i, chunk := range index.Chunks {
+				if chunk.File != fmt.Sprintf(chunkFileFormat, i) || chunk.Documents != tt.want[i] {
+					t.Errorf("chunk %d = %+v, want %d documents in %s", i, chunk, tt.want[i], fmt.Sprintf(chunkFileFormat, i))
+				}
+				content, err := os.ReadFile(filepath.Join(dir, chunk.File))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(content) != chunk.Bytes || strings.Count(string(content), "---\n") != chunk.Documents {
+					t.Errorf("chunk %s has %d bytes and %d documents, the index says %d and %d", chunk.File,
+						len(content), strings.Count(string(content), "---\n"), chunk.Bytes, chunk.Documents)
+				}
+			}
+			if _, err := os.Stat(stale); !os.IsNotExist(err) {
+				t.Errorf("the stale chunk %s wasn't removed", stale)
+			}
+		})
+	}
+}
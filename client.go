@@ -1,63 +1,252 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
 	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 type TSBHttpClient struct {
-	server   string
-	org      string
+	// address the request URLs are built with; requests go to the first healthy one of servers instead
+	server  string
+	servers []string
+	// index in servers of the one requests are sent to first, i.e. the last one that worked
+	active   atomic.Int32
+	orgs     []string // organizations to list services in
 	username string
 	password string
+	token    string // sent instead of the username and password if set
 	client   *http.Client
+	// --header, sent with every request
+	headers http.Header
+	// file to read the token from for each request, like a projected service account token
+	tokenFile string
+	// tenant, and workspace in it, to only list the services of; the whole organizations if empty
+	tenant    string
+	workspace string
+	// resolves the REST paths for the API version the server supports
+	endpoints endpointResolver
+	// name of the endpoint of each path built by it, for the API call counts
+	endpointNames sync.Map
+	// nil without --cache
+	cache *responseCache
+	// pauses the requests while TSB rate limits them
+	throttle throttle
+	// step of the GraphQL durations, see graphQLStepFormats
+	step string
+	// timeout of each GraphQL query to OAP, none if zero
+	queryTimeout time.Duration
+	// whether to query the topology at the finer step too
+	multiStep bool
+	// whether decoding TrafficSettings fails on the fields the tool doesn't know
+	strictDecoding bool
+	// with --login-path, the sessions the requests are authenticated with instead of the credentials
+	sessions sessions
+	// context the requests are sent with while one is bound, see BindContext
+	ctxMu sync.Mutex
+	ctx   context.Context
+}
+
+// Steps of the GraphQL durations, and the format of the start and end times for each. Finer steps keep the window
+// precise to the hour or minute, at the cost of more load on the telemetry store.
+var graphQLStepFormats = map[string]string{
+	"DAY":    DATE_FORMAT,
+	"HOUR":   "2006-01-02 15",
+	"MINUTE": "2006-01-02 1504",
+}
+
+// The step --multi-step queries the topology at too, for each --step
+var finerSteps = map[string]string{
+	"DAY":  "HOUR",
+	"HOUR": "MINUTE",
+}
+
+// Parses a --start or --end time, in any of the formats of the GraphQL steps
+func parseWindowTime(value string) (t time.Time, err error) {
+	for _, format := range []string{DATE_FORMAT, graphQLStepFormats["HOUR"], graphQLStepFormats["MINUTE"]} {
+		if t, err = time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
 }
 
 // compile-time assert we satisfy the interface we intend to
 var _ APIClient = &TSBHttpClient{}
 
-func NewTSBHttpClient(cfg *Config) *TSBHttpClient {
+func NewTSBHttpClient(cfg *Config) (*TSBHttpClient, error) {
 	client := http.DefaultClient
-	if cfg.insecure {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if cfg.insecure || len(cfg.caBundle) > 0 {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.insecure}
+		if len(cfg.caBundle) > 0 {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(cfg.caBundle) {
+				return nil, fmt.Errorf("failed to load the CA certificates to trust: no PEM certificate found")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+	if cfg.loginPath != "" {
+		var err error
+		if client, err = withCookieJar(client); err != nil {
+			return nil, err
 		}
-		client = &http.Client{Transport: tr}
+	}
+	cache, err := newResponseCache(cfg)
+	if err != nil {
+		return nil, err
 	}
 	return &TSBHttpClient{
-		server:   cfg.server,
-		org:      cfg.org,
-		username: cfg.username,
-		password: cfg.password,
-		client:   client}
+		server:       cfg.servers[0],
+		servers:      cfg.servers,
+		orgs:         []string{cfg.org},
+		tenant:       cfg.tenant,
+		workspace:    cfg.workspace,
+		username:     cfg.username,
+		password:     cfg.password,
+		token:        cfg.token,
+		tokenFile:    cfg.tokenFile,
+		client:       client,
+		headers:      cfg.headers,
+		endpoints:    endpointResolver{version: cfg.apiVersion},
+		cache:        cache,
+		step:         cfg.step,
+		queryTimeout: cfg.oapTimeout,
+		multiStep:    cfg.multiStep,
+		sessions:     sessions{loginPath: cfg.loginPath},
+
+		strictDecoding: cfg.strictDecoding}, nil
+}
+
+// Parses the --header flags, as key=value
+func parseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid --header %q, must be key=value", v)
+		}
+		headers.Add(strings.TrimSpace(key), value)
+	}
+	return headers, nil
+}
+
+// Returns the start and end of the window formatted for the GraphQL duration of the configured step
+func (c *TSBHttpClient) duration(start, end time.Time) (string, string) {
+	format := graphQLStepFormats[c.step]
+	return start.Format(format), end.Format(format)
+}
+
+// Sends the requests with the context until the returned function is called, so canceling it stops the requests in
+// flight and fails the next ones
+func (c *TSBHttpClient) BindContext(ctx context.Context) func() {
+	c.ctxMu.Lock()
+	defer c.ctxMu.Unlock()
+	c.ctx = ctx
+	return func() {
+		c.ctxMu.Lock()
+		defer c.ctxMu.Unlock()
+		c.ctx = nil
+	}
+}
+
+// Returns the context bound to the client, or the background one
+func (c *TSBHttpClient) requestContext() context.Context {
+	c.ctxMu.Lock()
+	defer c.ctxMu.Unlock()
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Sends the GraphQL query to OAP, bounded by the query timeout if there is one
+func (c *TSBHttpClient) queryOAP(query string) ([]byte, error) {
+	// GraphQL queries only read, so they can be sent again like GETs
+	ctx := withIdempotent(c.requestContext())
+	if c.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.queryTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s/graphql", c.server), strings.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	return c.callTSB(cacheTopology, req)
 }
 
 // Returns the service topology from skywalking, which needs to be normalized to services in
-// TSB via the 'aggregated metrics' names in each TSB Service.
+// TSB via the 'aggregated metrics' names in each TSB Service. With --multi-step, the topology is queried at the next
+// finer step too and both are merged, as coarse aggregations can miss short-lived calls.
 func (c *TSBHttpClient) GetTopology(start, end time.Time) (*TopologyResponse, error) {
-	s := start.Format(DATE_FORMAT)
-	e := end.Format(DATE_FORMAT)
-	query := fmt.Sprintf(`{
-    "query":"query ListNodesAndEdges($duration: Duration!) {topo: getGlobalTopology(duration: $duration) { nodes {id ,name, type, isReal } calls { id, source, sourceComponents, target, targetComponents, detectPoints } } }",
-    "variables":{"duration":{"start":"%s","end":"%s","step":"DAY"}}
-}`, s, e)
+	return c.topology(nil, start, end)
+}
 
-	debug("issuing query:\n%s", query)
+// Returns the topology of the calls from and to the given skywalking services, which is much cheaper to query than
+// the global one on large meshes. With --multi-step, it's merged with the one at the next finer step too.
+func (c *TSBHttpClient) GetServicesTopology(serviceIDs []string, start, end time.Time) (*TopologyResponse, error) {
+	return c.topology(serviceIDs, start, end)
+}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s/graphql", c.server), strings.NewReader(query))
+// Returns the topology of the services, or the global one if serviceIDs is nil, merged with the one at the next finer
+// step with --multi-step
+func (c *TSBHttpClient) topology(serviceIDs []string, start, end time.Time) (*TopologyResponse, error) {
+	top, err := c.getTopologyAt(serviceIDs, start, end, c.step)
+	if err != nil || !c.multiStep {
+		return top, err
+	}
+	finer, ok := finerSteps[c.step]
+	if !ok {
+		return top, nil
+	}
+	extra, err := c.getTopologyAt(serviceIDs, start, end, finer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	added := top.merge(extra)
+	debug("the topology at step %s has %d calls the one at step %s doesn't", finer, added, c.step)
+	return top, nil
+}
+
+// Returns the service topology observed in the window, queried with the given step: the one of the services if
+// serviceIDs isn't nil, or the global one
+func (c *TSBHttpClient) getTopologyAt(serviceIDs []string, start, end time.Time, step string) (*TopologyResponse, error) {
+	format := graphQLStepFormats[step]
+	query := fmt.Sprintf(`{
+    "query":"query ListNodesAndEdges($duration: Duration!) {topo: getGlobalTopology(duration: $duration) { nodes {id ,name, type, isReal, layers } calls { id, source, sourceComponents, target, targetComponents, detectPoints } } }",
+    "variables":{"duration":{"start":"%s","end":"%s","step":"%s"}}
+}`, start.Format(format), end.Format(format), step)
+	if serviceIDs != nil {
+		ids, err := json.Marshal(serviceIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal service IDs: %w", err)
+		}
+		query = fmt.Sprintf(`{
+    "query":"query ListNodesAndEdges($serviceIds: [ID!]!, $duration: Duration!) {topo: getServicesTopology(serviceIds: $serviceIds, duration: $duration) { nodes {id ,name, type, isReal, layers } calls { id, source, sourceComponents, target, targetComponents, detectPoints } } }",
+    "variables":{"serviceIds":%s,"duration":{"start":"%s","end":"%s","step":"%s"}}
+}`, ids, start.Format(format), end.Format(format), step)
 	}
 
-	body, err := c.callTSB(req)
+	debug("issuing query:\n%s", query)
+
+	body, err := c.queryOAP(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get topology: %w", err)
 	}
@@ -73,24 +262,168 @@ func (c *TSBHttpClient) GetTopology(start, end time.Time) (*TopologyResponse, er
 	return &out.Data.Response, err
 }
 
-// Calls TSB's ListServices endpoint
+// Returns the success rate (between 0 and 1) of the calls from the source to the target service of the topology,
+// from skywalking's service relation metrics. Returns false if there is no traffic between them in the window.
+func (c *TSBHttpClient) GetCallSuccessRate(source, target string, start, end time.Time) (float64, bool, error) {
+	sla, cpm, err := c.callMetrics(source, target, start, end)
+	if err != nil || cpm == 0 {
+		return 0, false, err
+	}
+	return float64(sla) / 10000, true, nil
+}
+
+// Returns the calls per minute from the source to the target service of the topology in the window, from
+// skywalking's service relation metrics
+func (c *TSBHttpClient) GetCallThroughput(source, target string, start, end time.Time) (int64, error) {
+	_, cpm, err := c.callMetrics(source, target, start, end)
+	return cpm, err
+}
+
+// Returns the calls per minute from the source to the target service of the topology in each hour of the window,
+// starting at the hour of start, from skywalking's service relation metrics
+func (c *TSBHttpClient) GetCallHourlyThroughput(source, target string, start, end time.Time) ([]int64, error) {
+	entity := fmt.Sprintf(`{scope: ServiceRelation, serviceName: %q, normal: true, destServiceName: %q, destNormal: true}`, source, target)
+	format := graphQLStepFormats["HOUR"]
+	duration := fmt.Sprintf(`{start: %q, end: %q, step: HOUR}`, start.Format(format), end.Format(format))
+	gql := fmt.Sprintf(`query { cpm: readMetricsValues(condition: {name: "service_relation_server_cpm", entity: %s}, duration: %s) `+
+		`{ values { values { value } } } }`, entity, duration)
+	query, err := json.Marshal(map[string]string{"query": gql})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	body, err := c.queryOAP(string(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hourly metrics of %s => %s: %w", source, target, err)
+	}
+
+	type respData struct {
+		Data struct {
+			CPM struct {
+				Values struct {
+					Values []struct {
+						Value int64 `json:"value"`
+					} `json:"values"`
+				} `json:"values"`
+			} `json:"cpm"`
+		} `json:"data"`
+	}
+	out := &respData{}
+	if err = json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("failed to parse hourly metrics of %s => %s: %w", source, target, err)
+	}
+	hourly := make([]int64, 0, len(out.Data.CPM.Values.Values))
+	for _, v := range out.Data.CPM.Values.Values {
+		hourly = append(hourly, v.Value)
+	}
+	return hourly, nil
+}
+
+// Returns the SLA (percentage of successful calls, times 100) and calls per minute of the service relation
+func (c *TSBHttpClient) callMetrics(source, target string, start, end time.Time) (int64, int64, error) {
+	entity := fmt.Sprintf(`{scope: ServiceRelation, serviceName: %q, normal: true, destServiceName: %q, destNormal: true}`, source, target)
+	s, e := c.duration(start, end)
+	duration := fmt.Sprintf(`{start: %q, end: %q, step: %s}`, s, e, c.step)
+	gql := fmt.Sprintf(`query { sla: readMetricsValue(condition: {name: "service_relation_server_call_sla", entity: %s}, duration: %s) `+
+		`cpm: readMetricsValue(condition: {name: "service_relation_server_cpm", entity: %s}, duration: %s) }`, entity, duration, entity, duration)
+	query, err := json.Marshal(map[string]string{"query": gql})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	body, err := c.queryOAP(string(query))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get metrics of %s => %s: %w", source, target, err)
+	}
+
+	type respData struct {
+		Data struct {
+			// percentage of successful calls, times 100
+			SLA int64 `json:"sla"`
+			// calls per minute
+			CPM int64 `json:"cpm"`
+		} `json:"data"`
+	}
+	out := &respData{}
+	if err = json.Unmarshal(body, out); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse metrics of %s => %s: %w", source, target, err)
+	}
+	return out.Data.SLA, out.Data.CPM, nil
+}
+
+// Calls TSB's ListServices endpoint for each of the organizations, scoped to the tenant or workspace if any, so
+// TSB doesn't send the services of the whole organization
 func (c *TSBHttpClient) GetServices() ([]Service, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/v2/organizations/%s/services", c.server, c.org), nil)
+	var services []Service
+	for _, org := range c.orgs {
+		url, err := c.servicesEndpoint(org)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		body, err := c.callTSB(cacheServices, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get services of organization %q: %w", org, err)
+		}
+
+		type respData struct {
+			Services []Service `json:"services"`
+		}
+		out := &respData{}
+		if err = json.Unmarshal(body, &out); err != nil {
+			return nil, fmt.Errorf("failed to parse services of organization %q: %w", org, err)
+		}
+		services = append(services, out.Services...)
+	}
+	return services, nil
+}
+
+// Returns the URL to list the services of the organization in, scoped to the tenant or workspace if any
+func (c *TSBHttpClient) servicesEndpoint(org string) (string, error) {
+	if c.tenant == "" {
+		return c.endpoint(endpointServices, org)
+	}
+	parent := fmt.Sprintf("organizations/%s/tenants/%s", org, c.tenant)
+	if c.workspace != "" {
+		parent += "/workspaces/" + c.workspace
+	}
+	return c.endpoint(endpointScopedServices, parent)
+}
+
+// Calls TSB's ListOrganizations endpoint, returning the names of the organizations visible to the credentials
+func (c *TSBHttpClient) ListOrganizations() ([]string, error) {
+	url, err := c.endpoint(endpointOrganizations)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	body, err := c.callTSB(req)
+	body, err := c.callTSB(cacheOrgs, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get services: %w", err)
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
 	}
 
 	type respData struct {
-		Services []Service `json:"services"`
+		Organizations []struct {
+			FQN string `json:"fqn"`
+		} `json:"organizations"`
 	}
 	out := &respData{}
-	err = json.Unmarshal(body, &out)
-	return out.Services, err
+	if err = json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse organizations: %w", err)
+	}
+	orgs := make([]string, 0, len(out.Organizations))
+	for _, o := range out.Organizations {
+		orgs = append(orgs, strings.TrimPrefix(o.FQN, "organizations/"))
+	}
+	return orgs, nil
 }
 
 // Service FQN -> Group FQN
@@ -107,14 +440,17 @@ type TrafficGroup struct {
 // Returns the traffic group that matches the provided service
 func (c *TSBHttpClient) LookupTrafficGroup(svc *Service) (*TrafficGroup, error) { // TODO: multi-error
 	// use the Lookup API to get the groups for each service
-	url := fmt.Sprintf("https://%s/v2/%s/groups", c.server, svc.FQN)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	url, err := c.endpoint(endpointLookupGroups, svc.FQN)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
 	if err != nil {
 		debug("failed to create request for service groups for %q", svc.FQN)
 		return nil, err
 	}
 
-	body, err := c.callTSB(req)
+	body, err := c.callTSB(cacheGroups, req)
 	if err != nil {
 		debug("failed to get service groups for %q: %v", svc.FQN, err)
 		return nil, err
@@ -131,44 +467,285 @@ func (c *TSBHttpClient) LookupTrafficGroup(svc *Service) (*TrafficGroup, error)
 	return &resp.TrafficGroups[0], nil
 }
 
+// Returns the traffic group with the given FQN
+func (c *TSBHttpClient) GetTrafficGroup(groupFQN string) (*trafficv2.Group, error) {
+	url, err := c.endpoint(endpointResource, groupFQN)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, err := c.callTSB(cacheGroups, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traffic group %q: %w", groupFQN, err)
+	}
+	group := &trafficv2.Group{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, group); err != nil {
+		return nil, fmt.Errorf("failed to decode traffic group %q: %w", groupFQN, err)
+	}
+	return group, nil
+}
+
 // Returns the TrafficSetting for the provided group FQN
 func (c *TSBHttpClient) GetTrafficSettings(groupFQN string) (*trafficv2.TrafficSetting, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/%s/settings", c.server, groupFQN), nil)
+	url, err := c.endpoint(endpointTrafficSettings, groupFQN)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	body, err := c.callTSB(req)
+	body, err := c.callTSB(cacheSettings, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get traffic settings: %w", err)
 	}
 
-	var out []trafficv2.TrafficSetting
-	_ = json.Unmarshal(body, &out)
-	if len(out) > 0 {
-		return &out[0], nil
+	var out []json.RawMessage
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, &settingsDecodeError{group: groupFQN, err: err}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	// protojson, as the API sends enums like the reachability mode by name
+	settings := &trafficv2.TrafficSetting{}
+	opts := protojson.UnmarshalOptions{DiscardUnknown: !c.strictDecoding}
+	if err := opts.Unmarshal(out[0], settings); err != nil {
+		return nil, &settingsDecodeError{group: groupFQN, err: err}
+	}
+	return settings, nil
+}
+
+// A TrafficSetting of a group that can't be decoded, e.g. because the API changed. Generation reports each group
+// failing so rather than stopping at the first one.
+type settingsDecodeError struct {
+	group string
+	err   error
+}
+
+func (e *settingsDecodeError) Error() string {
+	return fmt.Sprintf("failed to decode the TrafficSetting of group %q: %v", e.group, e.err)
+}
+
+func (e *settingsDecodeError) Unwrap() error { return e.err }
+
+// Names of the reachability modes in the JSON of the TSB API
+var reachabilityModes = map[string]trafficv2.ReachabilitySettings_Mode{
+	"UNSET":     trafficv2.ReachabilitySettings_UNSET,
+	"NAMESPACE": trafficv2.ReachabilitySettings_NAMESPACE,
+	"GROUP":     trafficv2.ReachabilitySettings_GROUP,
+	"WORKSPACE": trafficv2.ReachabilitySettings_WORKSPACE,
+	"CLUSTER":   trafficv2.ReachabilitySettings_CLUSTER,
+	"CUSTOM":    trafficv2.ReachabilitySettings_CUSTOM,
+}
+
+// Returns the reachability the group inherits from the default traffic settings of its workspace, tenant or
+// organization, the closest one setting it, or nil if none does
+func (c *TSBHttpClient) GetDefaultReachability(groupFQN string) (*trafficv2.ReachabilitySettings, error) {
+	parts := strings.Split(groupFQN, "/")
+	// the workspace, tenant and organization of the group, closest first
+	for n := len(parts) - 2; n >= 2; n -= 2 {
+		parent := strings.Join(parts[:n], "/")
+		url, err := c.endpoint(endpointTrafficSettings, parent)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		body, err := c.callTSB(cacheSettings, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the settings of %q: %w", parent, err)
+		}
+
+		var out []struct {
+			DefaultTrafficSetting struct {
+				Reachability *struct {
+					Mode  string   `json:"mode"`
+					Hosts []string `json:"hosts"`
+				} `json:"reachability"`
+			} `json:"defaultTrafficSetting"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			return nil, fmt.Errorf("failed to parse the settings of %q: %w", parent, err)
+		}
+		if len(out) == 0 || out[0].DefaultTrafficSetting.Reachability == nil {
+			continue
+		}
+		r := out[0].DefaultTrafficSetting.Reachability
+		mode, ok := reachabilityModes[r.Mode]
+		if r.Mode != "" && !ok {
+			return nil, fmt.Errorf("the settings of %q have an unknown reachability mode %q", parent, r.Mode)
+		}
+		if mode == trafficv2.ReachabilitySettings_UNSET && len(r.Hosts) == 0 {
+			continue
+		}
+		debug("group %q inherits the reachability of %q: %s %v", groupFQN, parent, r.Mode, r.Hosts)
+		return &trafficv2.ReachabilitySettings{Mode: mode, Hosts: r.Hosts}, nil
 	}
 	return nil, nil
 }
 
-func (c *TSBHttpClient) callTSB(req *http.Request) ([]byte, error) {
+// Returns whether the workspace with the given FQN exists
+func (c *TSBHttpClient) WorkspaceExists(workspaceFQN string) (bool, error) {
+	url, err := c.endpoint(endpointResource, workspaceFQN)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	status, _, err := c.doTSB(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to get workspace %q: %w", workspaceFQN, err)
+	}
+	switch {
+	case status == http.StatusNotFound:
+		return false, nil
+	case status >= 200 && status < 300:
+		return true, nil
+	default:
+		return false, fmt.Errorf("failed to get workspace %q: unexpected status %d", workspaceFQN, status)
+	}
+}
+
+// Returns the body of the response to the request, from the cache if it has it for the category
+func (c *TSBHttpClient) callTSB(category string, req *http.Request) ([]byte, error) {
+	if c.cache == nil {
+		_, body, err := c.doTSB(req)
+		return body, err
+	}
+
+	credential, err := c.credentialKey()
+	if err != nil {
+		return nil, err
+	}
+	path, err := c.cache.path(category, req, credential)
+	if err != nil {
+		return nil, err
+	}
+	if body, ok := c.cache.get(category, path); ok {
+		debug("using cached response to %v %q", req.Method, req.URL.String())
+		timing.apiCall(c.endpointName(req), 0, true)
+		explainf(explainAPI, "%s %s: cached, %d bytes", req.Method, req.URL.String(), len(body))
+		return body, nil
+	}
+
+	status, body, err := c.doTSB(req)
+	if err == nil && status >= 200 && status < 300 {
+		c.cache.put(path, body)
+	}
+	return body, err
+}
+
+// Like callTSB, but also returns the HTTP status code of the response. The request is sent to each server in turn,
+// starting from the one that worked last, until one of them is reachable and doesn't fail with a server error.
+// Requests that aren't idempotent are only sent once, as a failed one may have been applied already.
+func (c *TSBHttpClient) doTSB(req *http.Request) (int, []byte, error) {
+	var (
+		status int
+		body   []byte
+		err    error
+	)
+	started := time.Now()
+	defer func() { timing.apiCall(c.endpointName(req), time.Since(started), false) }()
+	start := int(c.active.Load())
+	for i := range c.servers {
+		n := (start + i) % len(c.servers)
+		if i > 0 {
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return 0, nil, fmt.Errorf("failed to rewind request body: %w", err)
+				}
+			}
+			debug("failing over to TSB server %q", c.servers[n])
+		}
+		req.URL.Host = c.servers[n]
+		status, body, err = c.doTSBOnce(req)
+		if err == nil && status < 500 {
+			c.active.Store(int32(n))
+			return status, body, nil
+		}
+		if !idempotent(req) {
+			debug("not retrying %v %q, it isn't idempotent", req.Method, req.URL.String())
+			break
+		}
+	}
+	return status, body, err
+}
+
+// Sends the request to the server in its URL. When the server rate limits it, every request is paused for as long
+// as its Retry-After asks, and the request sent again; a rate limited request wasn't processed, so that is safe
+// for every request.
+func (c *TSBHttpClient) doTSBOnce(req *http.Request) (int, []byte, error) {
+	relogged := false
+	for attempt := 0; ; attempt++ {
+		c.throttle.wait()
+		status, header, body, err := c.send(req)
+		switch {
+		// an unauthorized request of a session wasn't processed either: the session expired, log in again once
+		case err == nil && status == http.StatusUnauthorized && c.sessions.loginPath != "" && !relogged:
+			debug("the session with %q expired, logging in again", req.URL.Host)
+			c.expireSession(req.URL.Host)
+			relogged = true
+			attempt--
+		case err != nil || status != http.StatusTooManyRequests || attempt == maxThrottledRetries:
+			return status, body, err
+		default:
+			pause := retryAfter(header)
+			// the pause is left out of the diagnostic, so that it's reported once however long each pause is
+			diagnose(diagThrottled, req.URL.Host)
+			debug("pausing the requests to %q for %s", req.URL.Host, pause)
+			recordThrottling(pause)
+			c.throttle.pause(pause)
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return 0, nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+		}
+	}
+}
+
+// Sends the request once, returning the status, headers and body of the response
+func (c *TSBHttpClient) send(req *http.Request) (int, http.Header, []byte, error) {
 	debug("sending %v to %q", req.Method, req.URL.String())
 	req.Header.Set("content-type", "application/json")
-	req.SetBasicAuth(c.username, c.password)
-
+	if c.sessions.loginPath != "" {
+		// the jar adds the session cookie
+		if err := c.login(req.URL); err != nil {
+			return 0, nil, nil, err
+		}
+	} else if err := c.authenticate(req); err != nil {
+		return 0, nil, nil, err
+	}
+	// set last, so a proxy in front of TSB can be given its own credentials in any header
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to issue request: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to issue request: %w", err)
 	}
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return 0, nil, nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	explainf(explainAPI, "%s %s: %s, %d bytes", req.Method, req.URL.String(), resp.Status, len(body))
 
 	sample := string(body)
 	if len(body) > 80 {
 		sample = fmt.Sprintf("%s...", body[0:80])
 	}
 	debug("got body: %s", sample)
-	return body, nil
+	return resp.StatusCode, resp.Header, body, nil
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindowTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{value: "2024-01-02 15", want: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{value: "2024-01-02 1504", want: time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{value: "2024-01-02T15:04:05Z", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseWindowTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWindowTime(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(tt.want) {
+				t.Errorf("parseWindowTime(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindContextCancelsRequests(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an OAP too slow to ever answer
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	host := strings.TrimPrefix(server.URL, "https://")
+	c := &TSBHttpClient{server: host, servers: []string{host}, client: server.Client()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.BindContext(ctx)()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.queryOAP("{}"); !errors.Is(err, context.Canceled) {
+		t.Errorf("queryOAP() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// A time range to query the topology in
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%s,%s", formatWindowTime(w.Start), formatWindowTime(w.End))
+}
+
+// Formats a time of a window as precise as parseWindowTime read it
+func formatWindowTime(t time.Time) string {
+	switch {
+	case t.Minute() != 0:
+		return t.Format(graphQLStepFormats["MINUTE"])
+	case t.Hour() != 0:
+		return t.Format(graphQLStepFormats["HOUR"])
+	}
+	return t.Format(DATE_FORMAT)
+}
+
+// Parses a window in the START,END format, with the times in any of the formats of --start and --end
+func parseWindow(s string) (Window, error) {
+	startFlag, endFlag, ok := strings.Cut(s, ",")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q must be in the START,END format", s)
+	}
+	start, err := parseWindowTime(startFlag)
+	if err != nil {
+		return Window{}, fmt.Errorf("failed to parse start time %q: %w", startFlag, err)
+	}
+	end, err := parseWindowTime(endFlag)
+	if err != nil {
+		return Window{}, fmt.Errorf("failed to parse end time %q: %w", endFlag, err)
+	}
+	if end.Before(start) {
+		return Window{}, fmt.Errorf("window %q ends before it starts", s)
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+func newCompareCmd(runtime *Runtime) *cobra.Command {
+	var windowA, windowB string
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Report the calls that are new, removed or changed between two topology windows",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := parseWindow(windowA)
+			if err != nil {
+				return fmt.Errorf("invalid --window-a: %w", err)
+			}
+			b, err := parseWindow(windowB)
+			if err != nil {
+				return fmt.Errorf("invalid --window-b: %w", err)
+			}
+
+			graphA, err := fetchGraphForWindow(runtime, a.Start, a.End)
+			if err != nil {
+				return err
+			}
+			graphB, err := fetchGraphForWindow(runtime, b.Start, b.End)
+			if err != nil {
+				return err
+			}
+			compare(cmd.OutOrStdout(), a, graphA, b, graphB)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&windowA, "window-a", "", "Baseline window to compare, in YYYY-MM-DD,YYYY-MM-DD format, or with the finer times of --start and --end. REQUIRED")
+	cmd.Flags().StringVar(&windowB, "window-b", "", "Window to compare against the baseline, in YYYY-MM-DD,YYYY-MM-DD format, or with the finer times of --start and --end. REQUIRED")
+	return cmd
+}
+
+func compare(out io.Writer, a Window, graphA *Graph, b Window, graphB *Graph) {
+	edgesA := graphEdges(graphA)
+	edgesB := graphEdges(graphB)
+
+	var added, removed, changed []string
+	for key, e := range edgesB {
+		old, ok := edgesA[key]
+		if !ok {
+			added = append(added, e.String())
+		} else if d := e.diff(old); d != "" {
+			changed = append(changed, fmt.Sprintf("%s: %s", e, d))
+		}
+	}
+	for key, e := range edgesA {
+		if _, ok := edgesB[key]; !ok {
+			removed = append(removed, e.String())
+		}
+	}
+
+	fmt.Fprintf(out, "comparing %d edge(s) in %s with %d edge(s) in %s\n", len(edgesA), a, len(edgesB), b)
+	printSection(out, "new", added)
+	printSection(out, "removed", removed)
+	printSection(out, "changed", changed)
+	if len(added)+len(removed)+len(changed) == 0 {
+		fmt.Fprintf(out, "no differences\n")
+	}
+}
+
+func printSection(out io.Writer, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	slices.Sort(lines)
+	fmt.Fprintf(out, "%s:\n", title)
+	for _, l := range lines {
+		fmt.Fprintf(out, "  %s\n", l)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	date := func(s string) time.Time {
+		t, err := parseWindowTime(s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		window  string
+		want    Window
+		wantErr bool
+	}{
+		{window: "2023-07-01,2023-07-07", want: Window{Start: date("2023-07-01"), End: date("2023-07-07")}},
+		{window: "2023-07-01 09,2023-07-01 17", want: Window{Start: date("2023-07-01 09"), End: date("2023-07-01 17")}},
+		{window: "2023-07-01 0930,2023-07-02", want: Window{Start: date("2023-07-01 0930"), End: date("2023-07-02")}},
+		{window: "2023-07-01", wantErr: true},
+		{window: "2023-07-01,tomorrow", wantErr: true},
+		{window: "2023-07-07,2023-07-01", wantErr: true},
+		{window: "2023-07-01 17,2023-07-01 09", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			got, err := parseWindow(tt.window)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWindow(%q) error = %v, wantErr %v", tt.window, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseWindow(%q) = %v, want %v", tt.window, got, tt.want)
+			}
+			if got.String() != tt.window {
+				t.Errorf("parseWindow(%q).String() = %q, want it back", tt.window, got.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Flags that make no sense in a config file
+var notConfigurable = []string{"config", "help"}
+
+// Repeatable flags of key=value items, which a config file can also give as a map like the stringToString flags
+var keyValueLists = []string{"header"}
+
+// The values read from a config file, keyed by flag name
+type configValues map[string][]string
+
+// A problem in the config file, pointing at the offending position
+type configError struct {
+	path   string
+	line   int
+	column int
+	msg    string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.path, e.line, e.column, e.msg)
+}
+
+// Returns the flags that can be set in a config file, keyed by name. This is the schema of the config file: its
+// keys are the long names of the flags of every command, and values must be of the type of the flag.
+func configSchema(root *cobra.Command) map[string]*pflag.Flag {
+	schema := make(map[string]*pflag.Flag)
+	add := func(f *pflag.Flag) {
+		if _, ok := schema[f.Name]; !ok && !slices.Contains(notConfigurable, f.Name) {
+			schema[f.Name] = f
+		}
+	}
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		cmd.PersistentFlags().VisitAll(add)
+		cmd.Flags().VisitAll(add)
+		for _, c := range cmd.Commands() {
+			walk(c)
+		}
+	}
+	walk(root)
+	return schema
+}
+
+// Parses the config file and validates it against the schema; every problem found is returned
+func parseConfigFile(path string, schema map[string]*pflag.Flag) (configValues, []error) {
+	data, err := readInput("--config", path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read config file %q: %w", inputName(path), err)}
+	}
+
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, []error{fmt.Errorf("failed to parse config file %q: %w", inputName(path), err)}
+	}
+	values := make(configValues)
+	if len(doc.Content) == 0 {
+		// empty file
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{&configError{inputName(path), root.Line, root.Column, "config must be a map of flag names to values"}}
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		flag, ok := schema[key.Value]
+		if !ok {
+			errs = append(errs, &configError{inputName(path), key.Line, key.Column, fmt.Sprintf("unknown key %q", key.Value)})
+			continue
+		}
+		if _, dup := values[key.Value]; dup {
+			errs = append(errs, &configError{inputName(path), key.Line, key.Column, fmt.Sprintf("duplicate key %q", key.Value)})
+			continue
+		}
+		v, err := configValue(flag, value)
+		if err != nil {
+			errs = append(errs, &configError{inputName(path), value.Line, value.Column, fmt.Sprintf("invalid value for %q: %v", key.Value, err)})
+			continue
+		}
+		values[key.Value] = v
+	}
+	return values, errs
+}
+
+// Validates the YAML value against the type of the flag, and returns it in the form pflag can set
+func configValue(flag *pflag.Flag, node *yaml.Node) ([]string, error) {
+	if node.Kind == yaml.MappingNode && isKeyValueFlag(flag) {
+		return keyValueItems(flag, node)
+	}
+	isList := strings.HasSuffix(flag.Value.Type(), "Slice") || strings.HasSuffix(flag.Value.Type(), "Array")
+	if isList {
+		// a single value is a list of one, so flags can become lists without breaking existing config files
+		if node.Kind == yaml.ScalarNode {
+			return []string{node.Value}, nil
+		}
+		if node.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("expected a list")
+		}
+		var out []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("expected a list of strings")
+			}
+			out = append(out, item.Value)
+		}
+		return out, nil
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("expected a %s", flag.Value.Type())
+	}
+	switch flag.Value.Type() {
+	case "bool":
+		if node.Tag != "!!bool" {
+			return nil, fmt.Errorf("expected a bool, got %q", node.Value)
+		}
+	case "int":
+		if node.Tag != "!!int" {
+			return nil, fmt.Errorf("expected an integer, got %q", node.Value)
+		}
+	case "float64":
+		if node.Tag != "!!int" && node.Tag != "!!float" {
+			return nil, fmt.Errorf("expected a number, got %q", node.Value)
+		}
+	case "duration":
+		if _, err := parseDuration(node.Value); err != nil {
+			return nil, err
+		}
+	}
+	return []string{node.Value}, nil
+}
+
+func isKeyValueFlag(flag *pflag.Flag) bool {
+	return flag.Value.Type() == "stringToString" || slices.Contains(keyValueLists, flag.Name)
+}
+
+// Returns the key=value items of a map value. A stringToString flag is set once with all of them, as pflag parses
+// its values with more than one = as a CSV record.
+func keyValueItems(flag *pflag.Flag, node *yaml.Node) ([]string, error) {
+	var items []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Kind != yaml.ScalarNode || value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("expected a map of strings")
+		}
+		items = append(items, key.Value+"="+value.Value)
+	}
+	if flag.Value.Type() != "stringToString" || len(items) == 0 || len(items) == 1 && strings.Count(items[0], "=") == 1 {
+		return items, nil
+	}
+	var record strings.Builder
+	w := csv.NewWriter(&record)
+	if err := w.Write(items); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return []string{strings.TrimSuffix(record.String(), "\n")}, w.Error()
+}
+
+// Sets every flag that was not given in the command line from the config file. This runs before logging is set
+// up, so its debug logs, like the one of fetching a --config URL, are dropped.
+func applyConfigFile(path string, root *cobra.Command, flags *pflag.FlagSet) error {
+	values, errs := parseConfigFile(path, configSchema(root))
+	if len(errs) > 0 {
+		return joinConfigErrors(errs)
+	}
+
+	for name, v := range values {
+		// keys for flags of other commands don't apply, and the command line takes precedence
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if strings.HasSuffix(flag.Value.Type(), "Slice") {
+			v = []string{strings.Join(v, ",")}
+		}
+		for _, item := range v {
+			if err := flags.Set(name, item); err != nil {
+				return fmt.Errorf("failed to set %q from config file %q: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func joinConfigErrors(errs []error) error {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("invalid config file:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with config files",
+		// config files are validated offline; don't require the TSB connection flags
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate <config-file>",
+		Short: "Validate a config file against the config schema",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, errs := parseConfigFile(args[0], configSchema(cmd.Root())); len(errs) > 0 {
+				return joinConfigErrors(errs)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "config file %q is valid\n", args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of config files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			js, err := json.MarshalIndent(jsonSchema(configSchema(cmd.Root())), "", "    ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(js))
+			return nil
+		},
+	})
+	return cmd
+}
+
+// Returns the JSON Schema describing config files
+func jsonSchema(schema map[string]*pflag.Flag) map[string]any {
+	props := make(map[string]any)
+	names := maps.Keys(schema)
+	slices.Sort(names)
+	for _, name := range names {
+		flag := schema[name]
+		prop := map[string]any{"description": flag.Usage}
+		switch t := flag.Value.Type(); {
+		case t == "bool":
+			prop["type"] = "boolean"
+		case t == "int":
+			prop["type"] = "integer"
+		case t == "float64":
+			prop["type"] = "number"
+		case t == "stringToString":
+			prop["type"] = []string{"object", "string"}
+			prop["additionalProperties"] = map[string]any{"type": "string"}
+		case slices.Contains(keyValueLists, name):
+			prop["type"] = []string{"array", "object", "string"}
+			prop["items"] = map[string]any{"type": "string"}
+			prop["additionalProperties"] = map[string]any{"type": "string"}
+		case strings.HasSuffix(t, "Slice") || strings.HasSuffix(t, "Array"):
+			prop["type"] = []string{"array", "string"}
+			prop["items"] = map[string]any{"type": "string"}
+		default:
+			prop["type"] = "string"
+		}
+		props[name] = prop
+	}
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "generate-sidecar-tool config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           props,
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyConfigFileKeyValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantLabels  map[string]string
+		wantHeaders []string
+		wantErr     bool
+	}{
+		{
+			name:        "maps",
+			config:      "common-labels:\n  team: platform\n  selector: \"app=a,b\"\n  quoted: 'say \"hi\"'\nheader:\n  X-A: \"1\"\n  X-B: b=c\n",
+			wantLabels:  map[string]string{"team": "platform", "selector": "app=a,b", "quoted": `say "hi"`},
+			wantHeaders: []string{"X-A=1", "X-B=b=c"},
+		},
+		{
+			name:       "single entry map",
+			config:     "common-labels:\n  team: a,b\n",
+			wantLabels: map[string]string{"team": "a,b"},
+		},
+		{
+			name:        "strings",
+			config:      "common-labels: team=platform,tier=web\nheader: [X-A=1]\n",
+			wantLabels:  map[string]string{"team": "platform", "tier": "web"},
+			wantHeaders: []string{"X-A=1"},
+		},
+		{
+			name:    "nested map",
+			config:  "common-labels:\n  team:\n    name: platform\n",
+			wantErr: true,
+		},
+		{
+			name:    "map for a list flag",
+			config:  "namespaces:\n  a: b\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var labels map[string]string
+			var headers, namespaces []string
+			cmd := &cobra.Command{Use: "test"}
+			cmd.Flags().StringToStringVar(&labels, "common-labels", nil, "")
+			cmd.Flags().StringArrayVar(&headers, "header", nil, "")
+			cmd.Flags().StringSliceVar(&namespaces, "namespaces", nil, "")
+
+			err := applyConfigFile(path, cmd, cmd.Flags())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(labels, tt.wantLabels) {
+				t.Errorf("common-labels = %v, want %v", labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("header = %q, want %q", headers, tt.wantHeaders)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaKeyValues(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringToString("common-labels", nil, "")
+	cmd.Flags().StringArray("header", nil, "")
+	cmd.Flags().StringSlice("namespaces", nil, "")
+	props := jsonSchema(configSchema(cmd))["properties"].(map[string]any)
+
+	tests := []struct {
+		flag           string
+		wantType       []string
+		wantProperties bool
+	}{
+		{flag: "common-labels", wantType: []string{"object", "string"}, wantProperties: true},
+		{flag: "header", wantType: []string{"array", "object", "string"}, wantProperties: true},
+		{flag: "namespaces", wantType: []string{"array", "string"}},
+	}
+	for _, tt := range tests {
+		prop := props[tt.flag].(map[string]any)
+		if !reflect.DeepEqual(prop["type"], tt.wantType) {
+			t.Errorf("%s type = %v, want %v", tt.flag, prop["type"], tt.wantType)
+		}
+		if _, ok := prop["additionalProperties"]; ok != tt.wantProperties {
+			t.Errorf("%s additionalProperties = %v, want %v", tt.flag, ok, tt.wantProperties)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Config modes of traffic groups the tool generates for. TSB omits BRIDGED, the default, from the JSON of groups.
+const (
+	configModeDirect  = "DIRECT"
+	configModeBridged = "BRIDGED"
+)
+
+var knownConfigModes = []string{configModeDirect, configModeBridged, ""}
+
+// What to do with the calls from traffic groups in a config mode the tool doesn't know, for --on-unknown-mode
+const (
+	onUnknownModeSkip    = "skip"
+	onUnknownModeBridged = "bridged"
+	onUnknownModeDirect  = "direct"
+	onUnknownModeFail    = "fail"
+)
+
+var onUnknownModes = []string{onUnknownModeSkip, onUnknownModeBridged, onUnknownModeDirect, onUnknownModeFail}
+
+// Handles the calls from traffic groups in a config mode the tool doesn't know per --on-unknown-mode, so a new TSB
+// mode doesn't silently get the resources of another one: the calls are dropped, their groups treated as BRIDGED or
+// DIRECT, or the run fails. Each of those groups is reported once, and set to the mode it's treated as.
+func resolveConfigModes(runtime *Runtime, graph *Graph) error {
+	reported := make(map[string]bool)
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		group := call.SourceTrafficGroup
+		if group == nil || slices.Contains(knownConfigModes, group.ConfigMode) {
+			calls = append(calls, call)
+			continue
+		}
+		if runtime.onUnknownMode == onUnknownModeFail {
+			return fmt.Errorf("traffic group %q has the unknown config mode %q; set --on-unknown-mode to generate anyway", group.FQN, group.ConfigMode)
+		}
+		if !reported[group.FQN] {
+			reported[group.FQN] = true
+			diagnose(diagUnknownConfigMode, group.FQN, group.ConfigMode, runtime.onUnknownMode)
+		}
+		switch runtime.onUnknownMode {
+		case onUnknownModeSkip:
+			explainf(explainGraph, "call %s: skipped, traffic group %s has the unknown config mode %q", call.ID, group.FQN, group.ConfigMode)
+			continue
+		case onUnknownModeDirect:
+			group.ConfigMode = configModeDirect
+		default:
+			group.ConfigMode = configModeBridged
+		}
+		calls = append(calls, call)
+	}
+	graph.Calls = calls
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveConfigModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		wantIDs  []string
+		wantMode string
+		wantErr  bool
+	}{
+		{mode: onUnknownModeSkip, wantIDs: []string{"direct", "no-group"}},
+		{mode: onUnknownModeBridged, wantIDs: []string{"new-1", "direct", "new-2", "no-group"}, wantMode: configModeBridged},
+		{mode: onUnknownModeDirect, wantIDs: []string{"new-1", "direct", "new-2", "no-group"}, wantMode: configModeDirect},
+		{mode: onUnknownModeFail, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			diagnostics = nil
+			defer func() { diagnostics = nil }()
+			unknown := &TrafficGroup{FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/new", ConfigMode: "AMBIENT"}
+			direct := &TrafficGroup{FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/direct", ConfigMode: configModeDirect}
+			graph := &Graph{Calls: []*Call{
+				{ID: "new-1", SourceTrafficGroup: unknown},
+				{ID: "direct", SourceTrafficGroup: direct},
+				{ID: "new-2", SourceTrafficGroup: unknown},
+				{ID: "no-group"},
+			}}
+			err := resolveConfigModes(&Runtime{onUnknownMode: tt.mode}, graph)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveConfigModes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var ids []string
+			for _, call := range graph.Calls {
+				ids = append(ids, call.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("resolveConfigModes() calls = %v, want %v", ids, tt.wantIDs)
+			}
+			if tt.wantMode != "" && unknown.ConfigMode != tt.wantMode {
+				t.Errorf("resolveConfigModes() set the mode to %q, want %q", unknown.ConfigMode, tt.wantMode)
+			}
+			if direct.ConfigMode != configModeDirect {
+				t.Errorf("resolveConfigModes() changed the known mode to %q", direct.ConfigMode)
+			}
+			if len(diagnostics) != 1 || diagnostics[0].Code != diagUnknownConfigMode {
+				t.Errorf("resolveConfigModes() diagnostics = %v, want one %s", diagnostics, diagUnknownConfigMode)
+			}
+		})
+	}
+}
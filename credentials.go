@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// What credentials are replaced with in the logs
+const redactedCredential = "[REDACTED]"
+
+var (
+	secretsMu sync.Mutex
+	// credentials given to the tool, redacted from the logs verbatim wherever they appear
+	secrets []string
+	// with --unsafe-log-credentials or --redact-debug=false, nothing is redacted
+	unsafeLogCredentials bool
+	redactDebug          = true
+)
+
+// Credentials the tool doesn't know the value of, like the tokens read from a --token-file for each request, by their
+// shape: the values of the credential headers, of the password and token fields, and the password of URLs. What
+// they match is replaced but for their first group, and their second one if they have one.
+var credentialPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:proxy-)?authorization"?\s*[:=]\s*\[?"?)(?:(?:basic|bearer|negotiate)\s+)?[^"\s,\]}]+`),
+	regexp.MustCompile(`(?i)((?:x-tetrate-token|cookie|set-cookie)"?\s*[:=]\s*\[?"?)[^"\s,\]}]+`),
+	regexp.MustCompile(`(?i)("?(?:password|passwd|secret|token|access_token|refresh_token|api[_-]?key)"?\s*[:=]\s*"?)[^"\s,&}\[\]]+`),
+	regexp.MustCompile(`(\w+://[^/\s:@]+:)[^/\s@]+(@)`),
+}
+
+// Records a credential given to the tool, so it's redacted from the logs. Short values would redact too much of the
+// rest of the logs, and can't be told apart from it anyway.
+func addSecret(value string) {
+	if len(value) < 4 {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if !slices.Contains(secrets, value) {
+		secrets = append(secrets, value)
+	}
+}
+
+// Records the values of the headers given with --header: a proxy in front of TSB may take its credentials in any
+// header, not only in the credential ones
+func addSecretHeaders(headers http.Header) {
+	for _, values := range headers {
+		for _, v := range values {
+			addSecret(v)
+		}
+	}
+}
+
+// Returns the text with the credentials redacted, unless --unsafe-log-credentials or --redact-debug=false
+func redactCredentials(text string) string {
+	if unsafeLogCredentials {
+		return text
+	}
+	secretsMu.Lock()
+	for _, s := range secrets {
+		text = strings.ReplaceAll(text, s, redactedCredential)
+	}
+	secretsMu.Unlock()
+	for _, p := range credentialPatterns {
+		text = p.ReplaceAllString(text, "${1}"+redactedCredential+"${2}")
+	}
+	return text
+}
+
+// Redacts the credentials in what's written to it before writing it to the underlying writer. Each write is
+// redacted on its own, which is fine for the logs, written a line at a time.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactCredentials(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// a Monday
+	from := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "every minute", expr: "* * * * *", want: time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{name: "daily", expr: "0 3 * * *", want: time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)},
+		{name: "step", expr: "*/15 * * * *", want: time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{name: "range with step", expr: "0-30/10 11 * * *", want: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "list", expr: "0 9,17 * * *", want: time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC)},
+		{name: "day of week", expr: "0 3 * * 5", want: time.Date(2024, 1, 5, 3, 0, 0, 0, time.UTC)},
+		{name: "sunday as 7", expr: "0 3 * * 7", want: time.Date(2024, 1, 7, 3, 0, 0, 0, time.UTC)},
+		{name: "month", expr: "0 0 1 3 *", want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "day of month or week", expr: "0 0 15 * 3", want: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{name: "leap day", expr: "0 0 29 2 *", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "too few fields", expr: "0 3 * *", wantErr: true},
+		{name: "out of range", expr: "60 * * * *", wantErr: true},
+		{name: "reversed range", expr: "0 5-1 * * *", wantErr: true},
+		{name: "zero step", expr: "*/0 * * * *", wantErr: true},
+		{name: "not a number", expr: "a * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := schedule.next(from); !got.Equal(tt.want) {
+				t.Errorf("parseCron(%q).next(%s) = %s, want %s", tt.expr, from, got, tt.want)
+			}
+		})
+	}
+}
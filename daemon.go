@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// Runs the generation on every tick of the --schedule, forever. With --watch-changes, TSB's audit log is polled in
+// between too, and the groups whose membership changed are regenerated as soon as the changes are seen. A failed run
+// is reported and doesn't stop the daemon; the next run tries again. Diagnostics are only reported when they first
+// appear and when they're resolved.
+func daemon(runtime *Runtime, stdout io.Writer) error {
+	var err error
+	if reported, err = loadReportedDiagnostics(runtime.reportedWarningsFile); err != nil {
+		return err
+	}
+
+	var scheduled <-chan time.Time
+	var next time.Time
+	schedule := func() bool {
+		if runtime.schedule == nil {
+			return true
+		}
+		next = runtime.schedule.next(time.Now())
+		if next.IsZero() {
+			warn("the schedule never matches again, stopping")
+			return false
+		}
+		debug("next run at %s", next.Format(time.RFC3339))
+		scheduled = time.After(time.Until(next))
+		return true
+	}
+	if !schedule() {
+		return nil
+	}
+
+	// every run is of a window as long as the one of --start and --end, ending when it runs
+	window := runtime.end.Sub(runtime.start)
+
+	var poll <-chan time.Time
+	if runtime.watchChanges > 0 {
+		ticker := time.NewTicker(runtime.watchChanges)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	since := time.Now()
+
+	for {
+		select {
+		case <-scheduled:
+			daemonRun(runtime, stdout, next, window, nil)
+			if !schedule() {
+				return nil
+			}
+		case now := <-poll:
+			changes, err := runtime.client.ListChanges(since)
+			if err != nil {
+				warn("failed to poll the changes: %v", err)
+				continue
+			}
+			if len(changes) > 0 {
+				// the audit log includes the changes at since, which were seen already
+				since = changes[len(changes)-1].CreateTime.Add(time.Nanosecond)
+			}
+			if changed := changedMembership(changes); len(changed) > 0 {
+				debug("the membership of %v changed, regenerating their groups", changed)
+				daemonRun(runtime, stdout, now, window, changed)
+			}
+		}
+	}
+}
+
+// Runs the generation for the window ending now, for the groups in the changed workspaces and groups only if not nil,
+// and reports the result
+func daemonRun(runtime *Runtime, stdout io.Writer, at time.Time, window time.Duration, changed []string) {
+	runtime.end = time.Now()
+	runtime.start = runtime.end.Add(-window)
+	runtime.changed = changed
+	defer func() { runtime.changed = nil }()
+	if err := generate(runtime, stdout); err != nil {
+		warn("run at %s failed: %v", at.Format(time.RFC3339), err)
+		return
+	}
+	debug("run at %s succeeded", at.Format(time.RFC3339))
+	if changed != nil {
+		// the diagnostics of the groups that weren't regenerated aren't resolved
+		return
+	}
+	if err := reported.update(diagnostics); err != nil {
+		warn("%v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// The location of a workload as hinted by the name of its topology node
+type nodeHint struct {
+	Namespace string
+	Cluster   string
+}
+
+// Parses the namespace and cluster out of a topology node name. Mesh nodes are named either
+// `<subset>|<service>|<namespace>|<cluster>|<env>` or `<service>.<namespace>`; for the latter the
+// namespace is only trusted if the service has a deployment in it.
+func parseNodeHint(service *Service, key string) (nodeHint, bool) {
+	if parts := strings.Split(key, "|"); len(parts) == 5 {
+		hint := nodeHint{Namespace: parts[2], Cluster: parts[3]}
+		if hint.Cluster == "-" {
+			hint.Cluster = ""
+		}
+		return hint, hint.Namespace != ""
+	}
+
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		ns := key[i+1:]
+		if slices.Contains(parseNamespace(service), ns) {
+			return nodeHint{Namespace: ns}, true
+		}
+	}
+	return nodeHint{}, false
+}
+
+// Returns the cluster and namespace of a service deployment, from its FQN
+func parseDeployment(dep ServiceDeployment) (cluster, namespace string) {
+	fqnParts := strings.Split(dep.FQN, "/")
+	for i := 0; i+1 < len(fqnParts); i += 2 {
+		switch fqnParts[i] {
+		case "clusters":
+			cluster = fqnParts[i+1]
+		case "namespaces":
+			namespace = fqnParts[i+1]
+		}
+	}
+	return cluster, namespace
+}
+
+// Returns the name of the Kubernetes service of a service deployment, from its FQN
+func deploymentService(dep ServiceDeployment) string {
+	fqnParts := strings.Split(dep.FQN, "/")
+	for i := 0; i+1 < len(fqnParts); i += 2 {
+		if fqnParts[i] == "services" {
+			return fqnParts[i+1]
+		}
+	}
+	return ""
+}
+
+// Returns the namespaces of the deployments of the service the topology node refers to. Falls back to every
+// namespace of the service when the node has no usable hint or none of the deployments match it.
+func attributeNamespaces(service *Service, key string) []string {
+	hint, ok := parseNodeHint(service, key)
+	if !ok {
+		debug("no namespace hint in node %q; using every namespace of %q", key, service.FQN)
+		return parseNamespace(service)
+	}
+
+	var results []string
+	for _, dep := range service.ServiceDeployments {
+		cluster, ns := parseDeployment(dep)
+		if ns != hint.Namespace || (hint.Cluster != "" && cluster != hint.Cluster) {
+			continue
+		}
+		if !slices.Contains(results, ns) {
+			results = append(results, ns)
+		}
+	}
+	if len(results) == 0 {
+		debug("no deployment of %q matches node %q; using every namespace", service.FQN, key)
+		return parseNamespace(service)
+	}
+	debug("node %q attributed to namespaces %v of %q", key, results, service.FQN)
+	return results
+}
+
+// Where the clusters of the calls come from, for --clusters-from
+const (
+	clustersFromDeployments = "deployments"
+	clustersFromTopology    = "topology"
+)
+
+// Returns the clusters of a side of a call: with --clusters-from=topology, the cluster its topology node is named
+// after, so the calls of each cluster are told apart; otherwise, or if the node names none or one the service isn't
+// deployed to, the clusters the service is deployed to in the namespaces
+func callClusters(runtime *Runtime, service *Service, key string, namespaces []string) []string {
+	deployed := serviceClusters(service, namespaces)
+	if runtime.clustersFrom == clustersFromTopology {
+		hint, ok := parseNodeHint(service, key)
+		switch {
+		case !ok || hint.Cluster == "":
+			debug("topology node %q names no cluster; using the clusters of the deployments of %q", key, service.FQN)
+		case !slices.Contains(deployed, hint.Cluster):
+			// a stale or mismatched node name, that would open reachability in a cluster the service isn't in
+			diagnose(diagUndeployedCluster, key, hint.Cluster, service.FQN, namespaces)
+		default:
+			return []string{hint.Cluster}
+		}
+	}
+	return deployed
+}
+
+// Returns the clusters the service is deployed to in any of the given namespaces
+func serviceClusters(service *Service, namespaces []string) []string {
+	var results []string
+	for _, dep := range service.ServiceDeployments {
+		cluster, ns := parseDeployment(dep)
+		if cluster == "" || !slices.Contains(namespaces, ns) || slices.Contains(results, cluster) {
+			continue
+		}
+		results = append(results, cluster)
+	}
+	slices.Sort(results)
+	return results
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/anypb"
+	"istio.io/api/networking/v1beta1"
+)
+
+// Returns the namespaces only ever seen as destinations in the graph, with no outbound calls observed, sorted. They
+// are candidates for the strictest Sidecars. Each one comes with a service deployed in it, to look up its group by.
+// The outbound calls are the observed ones, including the ones this run dropped or scoped out, so a namespace whose
+// calls were filtered isn't mistaken for a destination-only one; the namespaces of the --exclusions-file are never
+// returned, as nothing is generated for them.
+func destinationOnlyNamespaces(runtime *Runtime, graph *Graph) ([]string, map[string]*Service) {
+	targets := make(map[string]*Service)
+	for _, call := range append(slices.Clone(graph.Calls), graph.ExcludedCalls...) {
+		for _, ns := range call.TargetNamespaces {
+			if targets[ns] == nil {
+				targets[ns] = call.TargetService
+			}
+		}
+	}
+	var namespaces []string
+	for ns := range targets {
+		if _, excluded := runtime.exclusions[ns]; excluded || graph.ObservedSources[ns] {
+			delete(targets, ns)
+			continue
+		}
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+	return namespaces, targets
+}
+
+// Returns a Sidecar allowing only the baseline hosts for each destination-only namespace in a DIRECT group, which
+// nothing else is generated for. The namespaces in BRIDGED groups, or in none, are left alone: TSB would override
+// the Sidecars of the former, and the latter can't be annotated with the group they belong to.
+func destinationOnlySidecars(runtime *Runtime, graph *Graph) ([]*typesv2.Object, error) {
+	namespaces, services := destinationOnlyNamespaces(runtime, graph)
+	var results []*typesv2.Object
+	for _, ns := range namespaces {
+		tg, err := runtime.client.LookupTrafficGroup(services[ns])
+		if err != nil {
+			return nil, err
+		}
+		if tg == nil || tg.ConfigMode != "DIRECT" {
+			debug("destination-only namespace %q isn't in a DIRECT group, not generating a Sidecar for it", ns)
+			continue
+		}
+		annotations, err := directModeAnnotations(tg.FQN)
+		if err != nil {
+			diagnose(diagInvalidGroupFQN, services[ns].FQN, err)
+			continue
+		}
+		spec, err := anypb.New(&v1beta1.Sidecar{
+			Egress: []*v1beta1.IstioEgressListener{{Hosts: slices.Clone(baselineHosts)}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating anypb: %w", err)
+		}
+		explainf(explainPolicy, "Sidecar %s/reachability-sidecar: allowing only the baseline hosts, the namespace is destination-only", ns)
+		results = append(results, &typesv2.Object{
+			Metadata: &typesv2.ObjectMeta{
+				Annotations: hostOrigins(nil).annotate(annotations, ns, baselineHosts),
+				Namespace:   ns,
+				Name:        "reachability-sidecar",
+			},
+			ApiVersion: api.IstioNetworkingBeta1API,
+			Kind:       api.IstioSidecarKind,
+			Spec:       spec,
+		})
+	}
+	return results, nil
+}
+
+// Writes the section of the text report listing the destination-only namespaces
+func writeDestinationOnly(out io.Writer, namespaces []string) {
+	if len(namespaces) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nDESTINATION-ONLY NAMESPACES (no outbound calls observed, candidates for the strictest Sidecars)")
+	for _, ns := range namespaces {
+		fmt.Fprintln(out, ns)
+	}
+}
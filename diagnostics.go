@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Codes of the diagnostics the tool emits. They are stable so that automation and docs can refer to them: never
+// renumber or reuse one, add new codes instead.
+const (
+	// topology
+	diagErrorOnlyCall        = "GST-101"
+	diagNoTrafficGroup       = "GST-104"
+	diagCreatingTrafficGroup = "GST-105"
+	diagInvalidGroupFQN      = "GST-106"
+	diagUnresolvedNodes      = "GST-107"
+	diagHeuristicMapping     = "GST-108"
+	diagMappingProposals     = "GST-109"
+	diagDuplicateKey         = "GST-110"
+	diagThrottled            = "GST-111"
+	diagBudgetExceeded       = "GST-112"
+	diagUnknownConfigMode    = "GST-113"
+	diagCrossTrustDomain     = "GST-114"
+	diagSharedGroup          = "GST-115"
+	diagUndeployedCluster    = "GST-116"
+	// generation
+	diagAmbientSidecar   = "GST-201"
+	diagAmbientGroup     = "GST-202"
+	diagWorkspaceExists  = "GST-203"
+	diagEmptySettingsFQN = "GST-204"
+	diagSkippedHosts     = "GST-205"
+	diagSplitGroup       = "GST-206"
+	diagQuirkGroup       = "GST-207"
+	diagSettingsDecode   = "GST-208"
+	diagSplitSelector    = "GST-209"
+	// checks
+	diagLint            = "GST-301"
+	diagPolicyViolation = "GST-302"
+	// cluster
+	diagPrune = "GST-401"
+)
+
+// Message templates of the diagnostics, by code
+var diagnosticCatalog = map[string]string{
+	diagErrorOnlyCall:        "excluding call %s from %q to %q: its success rate is %.2f%%",
+	diagNoTrafficGroup:       "no trafficgroup found for source service %q, skipping...",
+	diagCreatingTrafficGroup: "no trafficgroup found for source service %q, creating %q",
+	diagInvalidGroupFQN:      "skipping calls from service %q, its traffic group FQN is invalid: %v",
+	diagUnresolvedNodes:      "dropped the calls of topology nodes with no TSB service; map them in --mapping-file:\n  %s",
+	diagHeuristicMapping:     "mapped topology node %q to service %q, with confidence %.2f",
+	diagMappingProposals:     "proposed --mapping-file entries for topology nodes with no TSB service; check them before adding them:\n  %s",
+	diagDuplicateKey:         "services %q and %q share the aggregation key %q, %s",
+	diagThrottled:            "rate limited by %s, pausing the requests",
+	diagUnknownConfigMode:    "traffic group %q has the unknown config mode %q, handling its calls per --on-unknown-mode=%s",
+	diagCrossTrustDomain:     "dropped the calls from trust domain %s to %s; use --allow-cross-trust-domain to generate reachability across trust domains, or drop --require-cross-trust-domain-opt-in",
+	diagSharedGroup:          "traffic group %q has namespaces of owners other than %q, not generating for it: %v",
+	diagUndeployedCluster:    "topology node %q names cluster %q, where %q has no deployment in %v; using the clusters of its deployments",
+	diagBudgetExceeded:       "the run exceeded its %s budget of %s after %s, in the %s phase; skipping the metrics enrichment left",
+	diagEmptySettingsFQN:     "the TrafficSetting of traffic group %q has no FQN",
+	diagSkippedHosts:         "the TrafficSetting of traffic group %q has reachability mode %s, not adding the hosts its calls need; allow them by hand: %s",
+	diagSplitGroup:           "the source namespaces of traffic group %q need different hosts, splitting it into %s",
+	diagSplitSelector:        "traffic group %q selects its split namespaces with a wildcard, or only them; remove them from its namespace selector by hand so they only belong to their new group: %s",
+	diagSettingsDecode:       "the TrafficSetting of traffic group %q can't be decoded, not generating for it: %v",
+	diagQuirkGroup:           "traffic group %q is BRIDGED, its TrafficSetting can't add the localhost egress listener of the hostnetwork namespaces; configure them by hand: %s",
+	diagAmbientSidecar:       "namespace %q is in ambient mode, not generating Sidecar %s",
+	diagAmbientGroup:         "traffic group %q selects ambient namespaces, its TrafficSetting has no effect on them",
+	diagWorkspaceExists:      "workspace %q already exists, make sure it selects the namespaces of the new groups: %s",
+	diagLint:                 "lint: %s",
+	diagPolicyViolation:      "%s %s violates policies: %s",
+	diagPrune:                "resources generated by the previous run that are no longer generated; prune them:\n  %s",
+}
+
+type Diagnostic struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Every diagnostic emitted in the run, for the --summary-file
+var diagnostics []Diagnostic
+
+// Hosts the calls of each traffic group need but weren't added to its TrafficSetting, as its reachability mode
+// isn't CUSTOM, by group FQN; for the --summary-file
+var skippedHosts = make(map[string][]string)
+
+// FQNs of the traffic groups skipped as their TrafficSetting can't be decoded, in order; for the --summary-file
+var undecodedGroups []string
+
+// Emits the diagnostic with the given code as a warning, and records it for the summary. In daemon mode, the ones
+// a previous run reported already are only logged as debug.
+func diagnose(code string, a ...any) {
+	d := Diagnostic{Code: code, Message: fmt.Sprintf(diagnosticCatalog[code], a...)}
+	diagnostics = append(diagnostics, d)
+	emitEvent(&Event{Type: eventWarning, Code: d.Code, Message: d.Message})
+	if reported != nil && reported.has(d) {
+		debug("%s (reported already)", d.key())
+		return
+	}
+	warn("%s", d.key())
+}
+
+// What the --summary-file of a run holds
+type Summary struct {
+	// Number of generated resources, or zero if the run failed before generating them
+	Resources   int          `json:"resources"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	// Hosts needed but not added to TrafficSettings with another reachability mode than CUSTOM, by group FQN
+	SkippedHosts map[string][]string `json:"skippedHosts,omitempty"`
+	// Traffic groups nothing was generated for as their TrafficSetting can't be decoded
+	UndecodedGroups []string `json:"undecodedGroups,omitempty"`
+	// How much TSB rate limited the run, if it did
+	Throttling *Throttling `json:"throttling,omitempty"`
+	// How long each phase of the run took, in order
+	Phases []*PhaseTiming `json:"phases,omitempty"`
+	// Requests sent to each API endpoint
+	APICalls []*APICalls `json:"apiCalls,omitempty"`
+	// Why the run failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Writes the summary of the run as JSON to the file, if one is configured
+func writeSummary(path string, resources int, runErr error) error {
+	if path == "" {
+		return nil
+	}
+	summary := &Summary{Resources: resources, Diagnostics: diagnostics, SkippedHosts: skippedHosts, UndecodedGroups: undecodedGroups,
+		Throttling: throttling, Phases: timing.timings(), APICalls: timing.apiCallCounts()}
+	if summary.Diagnostics == nil {
+		summary.Diagnostics = []Diagnostic{}
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(summary, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write summary file %q: %w", path, err)
+	}
+	return nil
+}
+
+// Emits a diagnostic for each of the traffic groups with hosts skipped because of their reachability mode
+func reportSkippedHosts(trafficSettings map[string]*trafficv2.TrafficSetting) {
+	groups := maps.Keys(skippedHosts)
+	slices.Sort(groups)
+	for _, groupFQN := range groups {
+		settings, ok := trafficSettings[groupFQN]
+		if !ok {
+			continue
+		}
+		diagnose(diagSkippedHosts, groupFQN, settings.GetReachability().GetMode(), strings.Join(skippedHosts[groupFQN], ", "))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Values of --dry-run, honored by every path that changes the cluster
+const (
+	dryRunNone   = "none"
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+// Prints the change to a resource in the unified diff format, to the log like kubectl diff would. Either side is
+// empty for a resource that is created or deleted.
+func printDiff(name string, live, desired []byte) {
+	from, to := "a/"+name, "b/"+name
+	if len(live) == 0 {
+		from = "/dev/null"
+	}
+	if len(desired) == 0 {
+		to = "/dev/null"
+	}
+	oldLines, newLines := diffLines(live), diffLines(desired)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+	fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(len(oldLines)), hunkRange(len(newLines)))
+	for _, l := range oldLines {
+		fmt.Fprintf(&b, "-%s\n", l)
+	}
+	for _, l := range newLines {
+		fmt.Fprintf(&b, "+%s\n", l)
+	}
+	fmt.Fprint(logOut, b.String())
+}
+
+func diffLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(bytes.TrimSuffix(data, []byte("\n"))), "\n")
+}
+
+// Returns the range of a hunk side of the given number of lines, starting at the first one
+func hunkRange(lines int) string {
+	if lines == 0 {
+		return "0,0"
+	}
+	return fmt.Sprintf("1,%d", lines)
+}
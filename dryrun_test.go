@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+func TestApplyDryRun(t *testing.T) {
+	tests := []struct {
+		dryRun    string
+		wantCalls []string
+		wantLog   string
+	}{
+		{dryRun: dryRunClient, wantLog: "--- /dev/null\n+++ b/Sidecar/a/reachability-sidecar\n@@ -0,0 +1,10 @@\n"},
+		{
+			dryRun:    dryRunServer,
+			wantCalls: []string{"get --ignore-not-found -o json -f -", "apply --dry-run=server -f -", "diff -f -"},
+			wantLog:   "+    - b/*\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dryRun, func(t *testing.T) {
+			// kubectl diff exits with 1 when there are differences
+			kubectl := fakeKubectl(t, "case \"$1\" in diff) echo '+    - b/*'; exit 1;; esac\n")
+			runtime := &Runtime{kubectl: kubectl, dryRun: tt.dryRun, gitopsNamespace: "gitops"}
+			var err error
+			log := captureLog(t, func() { err = apply(runtime, []*typesv2.Object{testSidecar(t, "a", "istio-system/*", "b/*")}) })
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if calls := kubectlCalls(t, kubectl); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("kubectl calls = %q, want %q", calls, tt.wantCalls)
+			}
+			if !strings.Contains(log, tt.wantLog) {
+				t.Errorf("logged %q, want it to contain %q", log, tt.wantLog)
+			}
+		})
+	}
+}
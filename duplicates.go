@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Values of --on-duplicate-key
+const (
+	duplicateKeyFirst           = "first"
+	duplicateKeySkip            = "skip"
+	duplicateKeyError           = "error"
+	duplicateKeyMergeNamespaces = "merge-namespaces"
+)
+
+var duplicateKeyStrategies = []string{duplicateKeyFirst, duplicateKeySkip, duplicateKeyError, duplicateKeyMergeNamespaces}
+
+// What each --on-duplicate-key does, for the diagnostic
+var duplicateKeyResolutions = map[string]string{
+	duplicateKeyFirst:           "attributing its calls to the first one",
+	duplicateKeySkip:            "dropping its calls",
+	duplicateKeyMergeNamespaces: "attributing its calls to the first one, in the namespaces of both",
+}
+
+// Indexes the services by the aggregation keys of their metrics, which topology nodes are named by. When several
+// services share a key, the strategy decides which service its nodes resolve to, if any.
+func servicesByAggregationKey(strategy string, services []Service) (map[string]*Service, error) {
+	byKey := make(map[string]*Service)
+	skipped := make(map[string]bool)
+	for i := range services {
+		svc := &services[i]
+		for _, metric := range svc.Metrics {
+			key := metric.AggregationKey
+			debug("service %q has FQN %q", key, svc.FQN)
+			existing, ok := byKey[key]
+			if !ok {
+				if !skipped[key] {
+					byKey[key] = svc
+				}
+				continue
+			}
+			if existing.FQN == svc.FQN {
+				continue
+			}
+
+			if strategy == duplicateKeyError {
+				return nil, fmt.Errorf("services %q and %q share the aggregation key %q, see --on-duplicate-key", existing.FQN, svc.FQN, key)
+			}
+			diagnose(diagDuplicateKey, existing.FQN, svc.FQN, key, duplicateKeyResolutions[strategy])
+			switch strategy {
+			case duplicateKeySkip:
+				delete(byKey, key)
+				skipped[key] = true
+			case duplicateKeyMergeNamespaces:
+				byKey[key] = mergeDeployments(existing, svc)
+			}
+		}
+	}
+	return byKey, nil
+}
+
+// Returns a copy of the service deployed in the namespaces of the other one too
+func mergeDeployments(svc, other *Service) *Service {
+	merged := *svc
+	merged.ServiceDeployments = slices.Clone(svc.ServiceDeployments)
+	for _, dep := range other.ServiceDeployments {
+		if !slices.ContainsFunc(merged.ServiceDeployments, func(d ServiceDeployment) bool { return d.FQN == dep.FQN }) {
+			merged.ServiceDeployments = append(merged.ServiceDeployments, dep)
+		}
+	}
+	return &merged
+}
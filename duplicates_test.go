@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// Returns a service with the aggregation key, deployed in the namespaces of cluster c1
+func testService(fqn, key string, namespaces ...string) Service {
+	svc := Service{FQN: fqn}
+	svc.Metrics = append(svc.Metrics, struct {
+		AggregationKey string `json:"aggregationKey"`
+	}{AggregationKey: key})
+	for _, ns := range namespaces {
+		svc.ServiceDeployments = append(svc.ServiceDeployments, ServiceDeployment{FQN: "organizations/o/clusters/c1/namespaces/" + ns + "/services/" + fqn})
+	}
+	return svc
+}
+
+func TestServicesByAggregationKey(t *testing.T) {
+	services := func() []Service {
+		return []Service{
+			testService("a", "shared", "ns-a"),
+			testService("b", "shared", "ns-b"),
+			testService("c", "own", "ns-c"),
+		}
+	}
+	tests := []struct {
+		strategy string
+		// aggregation key => FQN of the service it resolves to, and its namespaces
+		want       map[string]string
+		namespaces []string
+		wantErr    bool
+	}{
+		{strategy: duplicateKeyFirst, want: map[string]string{"shared": "a", "own": "c"}, namespaces: []string{"ns-a"}},
+		{strategy: duplicateKeySkip, want: map[string]string{"own": "c"}},
+		{strategy: duplicateKeyMergeNamespaces, want: map[string]string{"shared": "a", "own": "c"}, namespaces: []string{"ns-a", "ns-b"}},
+		{strategy: duplicateKeyError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			byKey, err := servicesByAggregationKey(tt.strategy, services())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("servicesByAggregationKey(%q) error = %v, wantErr %v", tt.strategy, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(byKey) != len(tt.want) {
+				t.Fatalf("servicesByAggregationKey(%q) has %d keys, want %d", tt.strategy, len(byKey), len(tt.want))
+			}
+			for key, fqn := range tt.want {
+				if svc := byKey[key]; svc == nil || svc.FQN != fqn {
+					t.Errorf("servicesByAggregationKey(%q)[%q] = %v, want %q", tt.strategy, key, svc, fqn)
+				}
+			}
+			if svc := byKey["shared"]; svc != nil {
+				if got := parseNamespace(svc); !slices.Equal(got, tt.namespaces) {
+					t.Errorf("servicesByAggregationKey(%q)[shared] is in %v, want %v", tt.strategy, got, tt.namespaces)
+				}
+			}
+		})
+	}
+}
+
+func TestServicesByAggregationKeySameService(t *testing.T) {
+	svc := testService("a", "key", "ns")
+	svc.Metrics = append(svc.Metrics, svc.Metrics[0])
+	byKey, err := servicesByAggregationKey(duplicateKeyError, []Service{svc})
+	if err != nil {
+		t.Fatalf("a service with the same key twice isn't a duplicate: %v", err)
+	}
+	if byKey["key"] == nil || byKey["key"].FQN != "a" {
+		t.Errorf("servicesByAggregationKey()[key] = %v, want a", byKey["key"])
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Days and weeks in a duration, which time.ParseDuration doesn't know
+var dayUnits = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// Parses a duration like time.ParseDuration does, also accepting days and weeks, e.g. 90m, 36h, 2w or 1d12h
+func parseDuration(s string) (time.Duration, error) {
+	hours := dayUnits.ReplaceAllStringFunc(s, func(m string) string {
+		n, _ := strconv.ParseFloat(m[:len(m)-1], 64)
+		n *= 24
+		if m[len(m)-1] == 'w' {
+			n *= 7
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64) + "h"
+	})
+	d, err := time.ParseDuration(hours)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, must be a number with a unit of ns, us, ms, s, m, h, d or w, like 90m, 36h or 2w, or several like 1d12h", s)
+	}
+	return d, nil
+}
+
+// A duration flag parsed with parseDuration, so every time flag takes the same units
+type durationValue time.Duration
+
+// compile-time assert we satisfy the interface we intend to
+var _ pflag.Value = new(durationValue)
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+// Zero is "0" rather than "0s", so help leaves out zero defaults like it does for pflag's own duration flags
+func (d *durationValue) String() string {
+	if *d == 0 {
+		return "0"
+	}
+	return time.Duration(*d).String()
+}
+
+// Named like the type of pflag's own duration flags, so help and config files treat them the same
+func (d *durationValue) Type() string { return "duration" }
+
+// Defines a duration flag taking days and weeks too
+func durationVar(flags *pflag.FlagSet, p *time.Duration, name string, value time.Duration, usage string) {
+	*p = value
+	flags.Var((*durationValue)(p), name, usage)
+}
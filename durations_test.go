@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "90m", want: 90 * time.Minute},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "1d", want: 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "1d12h", want: 36 * time.Hour},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "1w2d3h", want: 9*24*time.Hour + 3*time.Hour},
+		{value: "0", want: 0},
+		{value: "2", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "1y", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDurationValueString(t *testing.T) {
+	tests := []struct {
+		value time.Duration
+		want  string
+	}{
+		{value: 0, want: "0"},
+		{value: 36 * time.Hour, want: "36h0m0s"},
+	}
+	for _, tt := range tests {
+		d := durationValue(tt.value)
+		if got := d.String(); got != tt.want {
+			t.Errorf("durationValue(%v).String() = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
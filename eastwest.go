@@ -0,0 +1,58 @@
+package main
+
+import "golang.org/x/exp/slices"
+
+// Returns whether the call goes from a cluster to another one, that is, the source and target have no cluster in
+// common; or for targets that fail over, whether any of their clusters is not one of the source. Calls whose
+// clusters are unknown are assumed to be local.
+func crossesClusters(call *Call) bool {
+	if len(call.SourceClusters) == 0 || len(call.TargetClusters) == 0 {
+		return false
+	}
+	if call.Failover {
+		for _, c := range call.TargetClusters {
+			if !slices.Contains(call.SourceClusters, c) {
+				return true
+			}
+		}
+		return false
+	}
+	return !containsAny(call.SourceClusters, call.TargetClusters)
+}
+
+// Returns the calls to the east-west gateway namespace that a cross-cluster call needs to keep working once the
+// namespaces are locked down: from the source namespaces to the gateway in the remote cluster and, with
+// --east-west-remote, from the destination namespaces to the gateway in their own cluster. The keys are the
+// topology keys of the source and target nodes, as for newCall.
+func eastWestCalls(runtime *Runtime, call *Call, sourceKey, targetKey string) ([]*Call, error) {
+	if runtime.eastWestNamespace == "" || !crossesClusters(call) {
+		return nil, nil
+	}
+
+	gateway := []string{runtime.eastWestNamespace}
+	source := *call
+	source.TargetNamespaces = gateway
+	source.EastWest = true
+	calls := []*Call{&source}
+	explainf(explainGraph, "call %s: crosses from clusters %v to %v, allowing %v to reach east-west namespace %q",
+		call.ID, call.SourceClusters, call.TargetClusters, call.SourceNamespaces, runtime.eastWestNamespace)
+
+	if runtime.eastWestRemote {
+		remote, err := newCall(runtime, call.ID, call.TargetService, call.TargetService, targetKey, targetKey)
+		if err != nil {
+			return nil, err
+		}
+		remote.SourceNamespaces = slices.Clone(call.TargetNamespaces)
+		remote.SourceClusters = call.TargetClusters
+		remote.TargetNamespaces = gateway
+		remote.TargetClusters = call.TargetClusters
+		remote.Components = call.Components
+		remote.SourceComponents, remote.TargetComponents = call.SourceComponents, call.TargetComponents
+		remote.SourceLayers, remote.TargetLayers = call.TargetLayers, call.TargetLayers
+		remote.EastWest = true
+		calls = append(calls, remote)
+		explainf(explainGraph, "call %s: allowing %v to reach east-west namespace %q in clusters %v",
+			call.ID, remote.SourceNamespaces, runtime.eastWestNamespace, call.TargetClusters)
+	}
+	return calls, nil
+}
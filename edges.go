@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// An edge between two services, aggregating every call seen between them
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Stable identifiers of the source and target services, see serviceStableID
+	SourceID         string   `json:"sourceId,omitempty"`
+	TargetID         string   `json:"targetId,omitempty"`
+	SourceNamespaces []string `json:"sourceNamespaces"`
+	TargetNamespaces []string `json:"targetNamespaces"`
+	TrafficGroup     string   `json:"trafficGroup,omitempty"`
+	// Start of the first window the edge was observed in, as far as the state file goes back
+	FirstSeen time.Time `json:"firstSeen,omitempty"`
+}
+
+func (e *Edge) String() string {
+	return fmt.Sprintf("%s => %s", e.Source, e.Target)
+}
+
+// Returns the key identifying the edge across runs. It uses the stable identifiers of the services, so the edge
+// is still the same one after the services are re-registered in TSB with a different FQN; edges persisted
+// without them (by older versions of the tool) fall back to the FQNs.
+func (e *Edge) Key() string {
+	source, target := e.SourceID, e.TargetID
+	if source == "" {
+		source = e.Source
+	}
+	if target == "" {
+		target = e.Target
+	}
+	return fmt.Sprintf("%s => %s", source, target)
+}
+
+// Returns how the edge changed from the other one, or the empty string if it didn't
+func (e *Edge) diff(other *Edge) string {
+	var changes []string
+	if e.Source != other.Source {
+		changes = append(changes, fmt.Sprintf("source FQN %q => %q", other.Source, e.Source))
+	}
+	if e.Target != other.Target {
+		changes = append(changes, fmt.Sprintf("target FQN %q => %q", other.Target, e.Target))
+	}
+	if !slices.Equal(e.SourceNamespaces, other.SourceNamespaces) {
+		changes = append(changes, fmt.Sprintf("source namespaces %v => %v", other.SourceNamespaces, e.SourceNamespaces))
+	}
+	if !slices.Equal(e.TargetNamespaces, other.TargetNamespaces) {
+		changes = append(changes, fmt.Sprintf("target namespaces %v => %v", other.TargetNamespaces, e.TargetNamespaces))
+	}
+	if e.TrafficGroup != other.TrafficGroup {
+		changes = append(changes, fmt.Sprintf("traffic group %q => %q", other.TrafficGroup, e.TrafficGroup))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// Identifies a service by its canonical name and the clusters and namespaces it is deployed in. Unlike the FQN,
+// it doesn't change when the service is re-onboarded in TSB. Services without a canonical name fall back to the FQN.
+func serviceStableID(svc *Service) string {
+	if svc.CanonicalName == "" {
+		return svc.FQN
+	}
+	var locations []string
+	for _, dep := range svc.ServiceDeployments {
+		cluster, ns := parseDeployment(dep)
+		locations = append(locations, cluster+"/"+ns)
+	}
+	slices.Sort(locations)
+	return fmt.Sprintf("%s@%s", svc.CanonicalName, strings.Join(slices.Compact(locations), ","))
+}
+
+// Collapses the calls of the graph into the edges between services, keyed by Edge.Key
+func graphEdges(graph *Graph) map[string]*Edge {
+	edges := make(map[string]*Edge)
+	for _, call := range graph.Calls {
+		e := &Edge{
+			Source:   call.SourceService.FQN,
+			Target:   call.TargetService.FQN,
+			SourceID: serviceStableID(call.SourceService),
+			TargetID: serviceStableID(call.TargetService),
+		}
+		if existing, ok := edges[e.Key()]; ok {
+			e = existing
+		} else {
+			edges[e.Key()] = e
+		}
+		e.SourceNamespaces = mergeSorted(e.SourceNamespaces, call.SourceNamespaces)
+		e.TargetNamespaces = mergeSorted(e.TargetNamespaces, call.TargetNamespaces)
+		if call.SourceTrafficGroup != nil {
+			e.TrafficGroup = call.SourceTrafficGroup.FQN
+		}
+	}
+	return edges
+}
+
+// Returns the sorted union of both lists, without duplicates
+func mergeSorted(a, b []string) []string {
+	out := append(slices.Clone(a), b...)
+	slices.Sort(out)
+	return slices.Compact(out)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"github.com/tetrateio/tetrate/pkg/fqn"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Host allowing every namespace, standing for the CLUSTER reachability mode
+const allHosts = "*/*"
+
+// Wraps an APIClient so that, with --effective-settings, the groups without a TrafficSetting of their own are
+// reconciled against the reachability they inherit from the default traffic settings of their workspace, tenant or
+// organization. The inherited hosts are recorded, so the generated TrafficSettings only allow the hosts missing from
+// them, and the ones adding nothing are dropped: TSB already applies them.
+type effectiveSettingsClient struct {
+	APIClient
+
+	mu sync.Mutex
+	// group FQN => hosts it inherits, for the groups without a TrafficSetting of their own
+	inherited map[string][]string
+}
+
+// compile-time assert we satisfy the interface we intend to
+var _ APIClient = &effectiveSettingsClient{}
+
+func newEffectiveSettingsClient(client APIClient) *effectiveSettingsClient {
+	return &effectiveSettingsClient{APIClient: client, inherited: make(map[string][]string)}
+}
+
+// Returns the TrafficSetting of the group, or for a group without one, a CUSTOM TrafficSetting with the baseline hosts
+// to generate it from, recording the hosts it inherits. An inherited CLUSTER mode is recorded as a host allowing every
+// namespace, so the hosts of the calls are known to be effective already, but it's never generated.
+func (c *effectiveSettingsClient) GetTrafficSettings(groupFQN string) (*trafficv2.TrafficSetting, error) {
+	settings, err := c.APIClient.GetTrafficSettings(groupFQN)
+	if err != nil || settings != nil {
+		return settings, err
+	}
+	inherited, err := c.GetDefaultReachability(groupFQN)
+	if err != nil || inherited == nil {
+		return nil, err
+	}
+
+	var hosts []string
+	switch inherited.GetMode() {
+	case trafficv2.ReachabilitySettings_CUSTOM, trafficv2.ReachabilitySettings_UNSET:
+		hosts = slices.Clone(inherited.GetHosts())
+	case trafficv2.ReachabilitySettings_CLUSTER:
+		hosts = []string{allHosts}
+	default:
+		// the NAMESPACE, GROUP and WORKSPACE modes depend on the group, so they're generated as usual
+		debug("group %q inherits the %s reachability mode, not reconciling against it", groupFQN, inherited.GetMode())
+		return nil, nil
+	}
+	meta, err := bridgedModeMeta(groupFQN)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.inherited[groupFQN] = hosts
+	c.mu.Unlock()
+	debug("group %q has no TrafficSetting, it inherits the hosts %v", groupFQN, hosts)
+	return &trafficv2.TrafficSetting{
+		Reachability: &trafficv2.ReachabilitySettings{
+			Mode:  trafficv2.ReachabilitySettings_CUSTOM,
+			Hosts: slices.Clone(baselineHosts),
+		},
+		Fqn: fqn.Tctl{}.FromMeta(api.TrafficAPI, api.TrafficSettingKind, meta),
+	}, nil
+}
+
+// Reduces the TrafficSettings of the groups without one of their own to the baseline hosts and the ones they don't
+// inherit already, and drops the ones left with the baseline hosts only
+func (c *effectiveSettingsClient) dropInherited(results []*typesv2.Object) ([]*typesv2.Object, error) {
+	out := results[:0]
+	for _, obj := range results {
+		if obj.GetKind() != api.TrafficSettingKind {
+			out = append(out, obj)
+			continue
+		}
+		meta := obj.GetMetadata()
+		groupFQN := fmt.Sprintf("organizations/%s/tenants/%s/workspaces/%s/trafficgroups/%s",
+			meta.GetOrganization(), meta.GetTenant(), meta.GetWorkspace(), meta.GetGroup())
+		c.mu.Lock()
+		inherited, ok := c.inherited[groupFQN]
+		c.mu.Unlock()
+		if !ok {
+			out = append(out, obj)
+			continue
+		}
+		settings := &trafficv2.TrafficSetting{}
+		if err := obj.GetSpec().UnmarshalTo(settings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal TrafficSetting %s: %w", objectName(obj), err)
+		}
+		var delta []string
+		for _, host := range settings.GetReachability().GetHosts() {
+			if !slices.Contains(baselineHosts, host) && !hostCovered(inherited, host) {
+				delta = append(delta, host)
+			}
+		}
+		if len(delta) > 0 {
+			debug("TrafficSetting of %s adds %v to the hosts it inherits", groupFQN, delta)
+			if err := keepHosts(obj, settings, mergeSorted(slices.Clone(baselineHosts), delta)); err != nil {
+				return nil, err
+			}
+			out = append(out, obj)
+			continue
+		}
+		explainf(explainPolicy, "TrafficSetting of %s: not generated, the hosts of its calls are effective through inheritance already", groupFQN)
+	}
+	return out, nil
+}
+
+// Replaces the hosts of the TrafficSetting object with the given ones, and their origins with the ones of those hosts
+func keepHosts(obj *typesv2.Object, settings *trafficv2.TrafficSetting, hosts []string) error {
+	settings.Reachability.Hosts = hosts
+	spec, err := anypb.New(settings)
+	if err != nil {
+		return fmt.Errorf("creating anypb: %w", err)
+	}
+	obj.Spec = spec
+
+	annotations := obj.GetMetadata().GetAnnotations()
+	origins := make(map[string]string)
+	if err := json.Unmarshal([]byte(annotations[hostOriginsAnnotation]), &origins); err != nil {
+		return nil
+	}
+	for host := range origins {
+		if !slices.Contains(hosts, host) {
+			delete(origins, host)
+		}
+	}
+	data, err := json.Marshal(origins)
+	if err != nil {
+		return fmt.Errorf("failed to marshal host origins: %w", err)
+	}
+	annotations[hostOriginsAnnotation] = string(data)
+	return nil
+}
+
+// Returns whether one of the hosts allows the host: the same host, or a wildcard for its namespace or every one
+func hostCovered(hosts []string, host string) bool {
+	return slices.ContainsFunc(hosts, func(h string) bool {
+		return h == host || h == allHosts || (strings.HasSuffix(h, "/*") && hostNamespace(h) == hostNamespace(host))
+	})
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestHostCovered(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		host  string
+		want  bool
+	}{
+		{name: "same host", hosts: []string{"b/reviews.b.svc.cluster.local"}, host: "b/reviews.b.svc.cluster.local", want: true},
+		{name: "namespace wildcard", hosts: []string{"b/*"}, host: "b/reviews.b.svc.cluster.local", want: true},
+		{name: "every host", hosts: []string{allHosts}, host: "b/reviews.b.svc.cluster.local", want: true},
+		{name: "other namespace", hosts: []string{"a/*"}, host: "b/reviews.b.svc.cluster.local", want: false},
+		{name: "other host", hosts: []string{"b/ratings.b.svc.cluster.local"}, host: "b/reviews.b.svc.cluster.local", want: false},
+		{name: "no hosts", host: "b/*", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostCovered(tt.hosts, tt.host); got != tt.want {
+				t.Errorf("hostCovered(%v, %q) = %v, want %v", tt.hosts, tt.host, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Generates resources from the graph of calls
+type Emitter interface {
+	// Name of the emitter, for logging and errors
+	Name() string
+	Emit(graph *Graph) ([]*typesv2.Object, error)
+}
+
+// The built-in emitter, generating Sidecars for groups in DIRECT mode and TrafficSettings for the rest
+type SettingsEmitter struct {
+	client APIClient
+	// the --aggregate-by of the TrafficSettings
+	aggregateBy string
+	// with --effective-settings, reconciles against the reachability the groups inherit
+	effectiveSettings bool
+}
+
+// compile-time assert we satisfy the interface we intend to
+var _ Emitter = &SettingsEmitter{}
+
+func (e *SettingsEmitter) Name() string { return "settings" }
+
+func (e *SettingsEmitter) Emit(graph *Graph) ([]*typesv2.Object, error) {
+	if !e.effectiveSettings {
+		return generateSettings(e.client, graph, e.aggregateBy)
+	}
+	client := newEffectiveSettingsClient(e.client)
+	results, err := generateSettings(client, graph, e.aggregateBy)
+	if err != nil {
+		return nil, err
+	}
+	return client.dropInherited(results)
+}
+
+// Runs an external executable as emitter. The executable gets the graph as JSON in its standard input, and
+// must print to its standard output a JSON list of the resources to generate, each one of them in the
+// `{"apiVersion": ..., "kind": ..., "metadata": {...}, "spec": {...}}` form.
+type ExecEmitter struct {
+	path string
+}
+
+// compile-time assert we satisfy the interface we intend to
+var _ Emitter = &ExecEmitter{}
+
+func (e *ExecEmitter) Name() string { return e.path }
+
+// A resource as printed by external emitters
+type emittedResource struct {
+	ApiVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   *typesv2.ObjectMeta `json:"metadata"`
+	Spec       map[string]any      `json:"spec"`
+}
+
+func (e *ExecEmitter) Emit(graph *Graph) ([]*typesv2.Object, error) {
+	input, err := json.Marshal(graph)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal graph: %w", err)
+	}
+
+	cmd := exec.Command(e.path)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run: %w: %s", err, stderr.String())
+	}
+
+	var resources []emittedResource
+	if err := json.Unmarshal(out, &resources); err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+
+	results := make([]*typesv2.Object, 0, len(resources))
+	for i, r := range resources {
+		if r.ApiVersion == "" || r.Kind == "" {
+			return nil, fmt.Errorf("resource %d has no apiVersion or kind", i)
+		}
+		spec, err := structpb.NewStruct(r.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spec in resource %d: %w", i, err)
+		}
+		any, err := anypb.New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("creating anypb: %w", err)
+		}
+		results = append(results, &typesv2.Object{
+			ApiVersion: r.ApiVersion,
+			Kind:       r.Kind,
+			Metadata:   r.Metadata,
+			Spec:       any,
+		})
+	}
+	return results, nil
+}
+
+// Returns the emitters configured for the run: the built-in one, followed by the external ones
+func newEmitters(runtime *Runtime) []Emitter {
+	emitters := []Emitter{&SettingsEmitter{client: runtime.client, aggregateBy: runtime.aggregateBy, effectiveSettings: runtime.effectiveSettings}}
+	for _, path := range runtime.emitters {
+		emitters = append(emitters, &ExecEmitter{path: path})
+	}
+	return emitters
+}
+
+// Runs every configured emitter over the graph and returns all the generated resources
+func emit(runtime *Runtime, graph *Graph) ([]*typesv2.Object, error) {
+	var results []*typesv2.Object
+	for _, e := range newEmitters(runtime) {
+		objs, err := e.Emit(graph)
+		if err != nil {
+			return nil, fmt.Errorf("emitter %q: %w", e.Name(), err)
+		}
+		debug("emitter %q generated %d resources", e.Name(), len(objs))
+		emitGenerated(objs...)
+		results = append(results, objs...)
+	}
+	return results, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Names of the TSB REST endpoints the tool calls
+const (
+	endpointOrganizations   = "organizations"
+	endpointServices        = "services"
+	endpointScopedServices  = "scoped-services"
+	endpointLookupGroups    = "lookup-groups"
+	endpointTrafficSettings = "traffic-settings"
+	endpointResource        = "resource"
+	endpointAuditLogs       = "audit-logs"
+)
+
+const apiVersionAuto = "auto"
+
+// Paths of the endpoints in each TSB API version the tool supports, newest first. The placeholders are filled in
+// with the arguments given to endpointResolver.path, e.g. an organization name or a resource FQN.
+var apiEndpoints = []struct {
+	version string
+	paths   map[string]string
+}{
+	{"v2", map[string]string{
+		endpointOrganizations:   "/v2/organizations",
+		endpointServices:        "/v2/organizations/%s/services",
+		endpointScopedServices:  "/v2/%s/services",
+		endpointLookupGroups:    "/v2/%s/groups",
+		endpointTrafficSettings: "/v2/%s/settings",
+		endpointResource:        "/v2/%s",
+		endpointAuditLogs:       "/v2/%s/audit/logs",
+	}},
+}
+
+// Resolves the REST paths of the endpoints for the API version the server supports, rather than hardcoding them,
+// so a new TSB API revision only needs its paths added to apiEndpoints.
+type endpointResolver struct {
+	// the --api-version to use, or "auto" to negotiate it with the server
+	version string
+	paths   map[string]string
+}
+
+// Returns the path of the endpoint, negotiating the API version with the server on first use
+func (c *TSBHttpClient) endpoint(name string, args ...any) (string, error) {
+	if c.endpoints.paths == nil {
+		if err := c.resolveEndpoints(); err != nil {
+			return "", err
+		}
+	}
+	path, ok := c.endpoints.paths[name]
+	if !ok {
+		return "", fmt.Errorf("TSB API %s has no %s endpoint", c.endpoints.version, name)
+	}
+	path = fmt.Sprintf(path, args...)
+	c.endpointNames.Store(path, name)
+	return fmt.Sprintf("https://%s%s", c.server, path), nil
+}
+
+// Returns the name of the endpoint the request is sent to, for the API call counts: the one its URL was built for, or
+// its path for the ones not built from apiEndpoints, like the GraphQL one of OAP
+func (c *TSBHttpClient) endpointName(req *http.Request) string {
+	if name, ok := c.endpointNames.Load(req.URL.Path); ok {
+		return name.(string)
+	}
+	return req.URL.Path
+}
+
+// Picks the newest API version whose organizations endpoint the server serves, unless one was given
+func (c *TSBHttpClient) resolveEndpoints() error {
+	for _, v := range apiEndpoints {
+		if c.endpoints.version != apiVersionAuto {
+			if v.version == c.endpoints.version {
+				c.endpoints.paths = v.paths
+				return nil
+			}
+			continue
+		}
+
+		req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, fmt.Sprintf("https://%s%s", c.server, v.paths[endpointOrganizations]), nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		status, _, err := c.doTSB(req)
+		if err != nil {
+			return fmt.Errorf("failed to negotiate the TSB API version: %w", err)
+		}
+		if status != http.StatusNotFound {
+			debug("server supports TSB API %s (status %d)", v.version, status)
+			c.endpoints = endpointResolver{version: v.version, paths: v.paths}
+			return nil
+		}
+		debug("server doesn't support TSB API %s", v.version)
+	}
+
+	if c.endpoints.version != apiVersionAuto {
+		return fmt.Errorf("unsupported --api-version %q", c.endpoints.version)
+	}
+	return fmt.Errorf("the server supports none of the TSB API versions this tool knows")
+}
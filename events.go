@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// Types of the events of the --events-file
+const (
+	eventRunStarted        = "run-started"
+	eventRunCompleted      = "run-completed"
+	eventPhaseStarted      = "phase-started"
+	eventPhaseCompleted    = "phase-completed"
+	eventWarning           = "warning"
+	eventResourceGenerated = "resource-generated"
+	eventResourceDropped   = "resource-dropped"
+)
+
+// A progress event of a run, written as a line of JSON to the --events-file so wrappers can track the run
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// of the phase events, the phase and how long it took once completed
+	Phase    string `json:"phase,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// of the warning events, the diagnostic
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// of the resource events, the generated object
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+	// of the run-completed events, the resources generated and why the run failed, if it did
+	Resources int    `json:"resources,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Where the events go, nil without --events-file
+var events *eventWriter
+
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Sends the events to the --events-file: a file, overwritten, or an inherited file descriptor given as fd:<n>. The
+// credentials in them are redacted like in the logs.
+func setupEvents(target string) error {
+	if target == "" {
+		return nil
+	}
+	var w io.Writer
+	if fd, ok := strings.CutPrefix(target, "fd:"); ok {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid --events-file %q, must be a file or fd:<n>", target)
+		}
+		// the events would be mixed with the resources or the logs
+		if n == 1 || n == 2 {
+			return fmt.Errorf("invalid --events-file %q, can't be the standard output or error", target)
+		}
+		w = os.NewFile(uintptr(n), target)
+	} else {
+		// the file is not buffered, so there is nothing to flush and it's closed on exit
+		f, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to create --events-file %q: %w", target, err)
+		}
+		w = f
+	}
+	events = &eventWriter{enc: json.NewEncoder(&redactingWriter{w: w})}
+	return nil
+}
+
+// Emits a resource-generated event for each of the objects, as the stage of the generation producing them is done
+func emitGenerated(objs ...*typesv2.Object) {
+	for _, obj := range objs {
+		emitEvent(&Event{Type: eventResourceGenerated, Kind: obj.GetKind(), Name: objectName(obj)})
+	}
+}
+
+// Writes the event, stamped with the current time, if there is an --events-file
+func emitEvent(e *Event) {
+	e.Time = time.Now().UTC()
+	if progressFn != nil {
+		progressFn(e)
+	}
+	if events == nil {
+		return
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	// each event is a single write, so readers never see half of one
+	if err := events.enc.Encode(e); err != nil {
+		debug("failed to write event %s: %v", e.Type, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// A namespace deliberately left out of the generation, from the --exclusions-file, e.g.:
+//
+//   - namespace: legacy-billing
+//     reason: "still on VMs, reachability managed by hand"
+type Exclusion struct {
+	Namespace string `json:"namespace"`
+	// Why it is excluded, shown in reports so audits tell deliberate gaps from oversights
+	Reason string `json:"reason"`
+}
+
+// Reads the exclusions from the YAML file, returning the reason of each excluded namespace
+func loadExclusions(path string) (map[string]string, error) {
+	data, err := readInput("--exclusions-file", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --exclusions-file %q: %w", inputName(path), err)
+	}
+	var exclusions []Exclusion
+	if err := yaml.UnmarshalStrict(data, &exclusions); err != nil {
+		return nil, fmt.Errorf("failed to parse --exclusions-file %q: %w", inputName(path), err)
+	}
+	reasons := make(map[string]string, len(exclusions))
+	for i, e := range exclusions {
+		if e.Namespace == "" {
+			return nil, fmt.Errorf("exclusion %d in %q has no namespace", i, inputName(path))
+		}
+		if e.Reason == "" {
+			return nil, fmt.Errorf("exclusion of namespace %q in %q has no reason", e.Namespace, inputName(path))
+		}
+		reasons[e.Namespace] = e.Reason
+	}
+	debug("loaded %d exclusions from %q", len(reasons), inputName(path))
+	return reasons, nil
+}
+
+// Removes the excluded namespaces from the sources of the calls, so nothing is generated for them, recording them
+// in the calls for the reports. Calls left with no source namespace are moved to the excluded calls of the graph.
+func applyExclusions(exclusions map[string]string, graph *Graph) {
+	if len(exclusions) == 0 {
+		return
+	}
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		var sources []string
+		for _, ns := range call.SourceNamespaces {
+			if reason, ok := exclusions[ns]; ok {
+				call.ExcludedNamespaces = append(call.ExcludedNamespaces, ns)
+				explainf(explainGraph, "call %s: not generating for source namespace %q, it is intentionally excluded (%s)", call.ID, ns, reason)
+				continue
+			}
+			sources = append(sources, ns)
+		}
+		call.SourceNamespaces = sources
+		if len(sources) == 0 && len(call.ExcludedNamespaces) > 0 {
+			graph.ExcludedCalls = append(graph.ExcludedCalls, call)
+			continue
+		}
+		calls = append(calls, call)
+	}
+	graph.Calls = calls
+}
+
+// How reports show the namespace excluded for the reason
+func exclusionPolicy(reason string) string {
+	return fmt.Sprintf("intentionally excluded (%s)", reason)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// Fails the run when it generates fewer resources, or covers fewer source namespaces, than --expect-min-resources
+// and --expect-min-namespaces, as an empty generation is more often caused by wrong credentials or scoping than by
+// namespaces going away. Runs regenerating only the groups that changed aren't checked, as they're partial.
+func checkExpectations(runtime *Runtime, graph *Graph, results []*typesv2.Object) error {
+	if runtime.changed != nil {
+		return nil
+	}
+	if len(results) < runtime.expectMinResources {
+		return fmt.Errorf("the run generated %d resources, fewer than --expect-min-resources=%d; check the credentials "+
+			"and the scoping flags of the run", len(results), runtime.expectMinResources)
+	}
+	var namespaces []string
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup != nil {
+			namespaces = mergeSorted(namespaces, call.SourceNamespaces)
+		}
+	}
+	if len(namespaces) < runtime.expectMinNamespaces {
+		return fmt.Errorf("the run generated for %d source namespaces, fewer than --expect-min-namespaces=%d; check the "+
+			"credentials and the scoping flags of the run", len(namespaces), runtime.expectMinNamespaces)
+	}
+	debug("the run generated %d resources for %d source namespaces", len(results), len(namespaces))
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func newExplainCmd(runtime *Runtime) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain <source-namespace> <destination-namespace>",
+		Short: "Explain which observed calls justify the source namespace reaching the destination namespace",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			explain(cmd.OutOrStdout(), runtime, graph, args[0], args[1])
+			return nil
+		},
+	}
+}
+
+// Returns the calls in the graph that make srcNs need to reach destNs
+func findEdges(graph *Graph, srcNs, destNs string) []*Call {
+	var edges []*Call
+	for _, call := range graph.Calls {
+		if slices.Contains(call.SourceNamespaces, srcNs) && slices.Contains(call.TargetNamespaces, destNs) {
+			edges = append(edges, call)
+		}
+	}
+	return edges
+}
+
+func explain(out io.Writer, runtime *Runtime, graph *Graph, srcNs, destNs string) {
+	window := fmt.Sprintf("%s and %s", runtime.start.Format(DATE_FORMAT), runtime.end.Format(DATE_FORMAT))
+
+	edges := findEdges(graph, srcNs, destNs)
+	if len(edges) == 0 {
+		fmt.Fprintf(out, "no observed calls from namespace %q to namespace %q between %s\n", srcNs, destNs, window)
+		return
+	}
+
+	fmt.Fprintf(out, "namespace %q reaches namespace %q because of %d observed call(s) between %s:\n", srcNs, destNs, len(edges), window)
+	for _, call := range edges {
+		switch {
+		case call.Mirrored:
+			fmt.Fprintf(out, "  call %s (reverse direction, from --assume-bidirectional)\n", call.ID)
+		case call.EastWest:
+			fmt.Fprintf(out, "  call %s (through the east-west gateway, from --east-west-namespace)\n", call.ID)
+		case call.Manual:
+			fmt.Fprintf(out, "  call %s (manual, from --extra-edges: %s)\n", call.ID, call.ManualReason)
+		default:
+			fmt.Fprintf(out, "  call %s\n", call.ID)
+		}
+		fmt.Fprintf(out, "    source:      %s\n", serviceName(runtime.nameStyle, call.SourceService))
+		fmt.Fprintf(out, "    destination: %s\n", serviceName(runtime.nameStyle, call.TargetService))
+		if call.SourceTrafficGroup == nil {
+			fmt.Fprintf(out, "    no traffic group for the source service; this call does not generate any policy\n")
+		} else {
+			fmt.Fprintf(out, "    traffic group: %s (%s)\n", call.SourceTrafficGroup.FQN, call.SourceTrafficGroup.ConfigMode)
+		}
+	}
+}
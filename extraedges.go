@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/slices"
+)
+
+// Annotation of the generated objects allowing hosts because of --extra-edges, listing those edges
+const manualEdgesAnnotation = "generate-sidecar-tool/manual-edges"
+
+// A dependency declared in the --extra-edges file rather than observed
+type ExtraEdge struct {
+	// FQNs of the source and target services
+	Source string
+	Target string
+	// Why the dependency exists although it isn't observed, e.g. "DR failover path"
+	Reason string
+}
+
+// Reads the --extra-edges CSV file, with a source service FQN, target service FQN and reason in each row, and an
+// optional source,target,reason header:
+//
+//	source,target,reason
+//	organizations/tetrate/services/billing,organizations/tetrate/services/ledger,quarterly close job
+func loadExtraEdges(path string) ([]ExtraEdge, error) {
+	data, err := readInput("--extra-edges", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --extra-edges %q: %w", inputName(path), err)
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	var edges []ExtraEdge
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse --extra-edges %q: %w", inputName(path), err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "source") {
+			continue
+		}
+		if record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("row %d of --extra-edges %q has no source or target", line, inputName(path))
+		}
+		edges = append(edges, ExtraEdge{Source: record[0], Target: record[1], Reason: record[2]})
+	}
+	debug("loaded %d extra edges from %q", len(edges), inputName(path))
+	return edges, nil
+}
+
+// Adds a call to the graph for each of the extra edges, marked as manual
+func addExtraEdges(runtime *Runtime, graph *Graph) error {
+	if len(runtime.extraEdges) == 0 {
+		return nil
+	}
+	servicesByFQN := make(map[string]*Service)
+	for i := range graph.Services {
+		servicesByFQN[graph.Services[i].FQN] = &graph.Services[i]
+	}
+	for i, e := range runtime.extraEdges {
+		source, target := servicesByFQN[e.Source], servicesByFQN[e.Target]
+		if source == nil || target == nil {
+			return fmt.Errorf("extra edge from %q to %q: no such service in TSB", e.Source, e.Target)
+		}
+		call, err := newCall(runtime, fmt.Sprintf("manual-%d", i+1), source, target, "", "")
+		if err != nil {
+			return err
+		}
+		call.Manual, call.ManualReason = true, e.Reason
+		graph.Calls = append(graph.Calls, call)
+		explainf(explainGraph, "call %s: %s => %s, declared in --extra-edges: %s", call.ID, source.FQN, target.FQN, e.Reason)
+	}
+	return nil
+}
+
+// Annotates the generated Sidecars and TrafficSettings that the manual calls of the graph add hosts to with those
+// calls, so their provenance is visible on the objects themselves
+func annotateManualEdges(graph *Graph, results []*typesv2.Object) {
+	annotateCalls(graph, results, manualEdgesAnnotation, func(call *Call) (string, bool) {
+		return call.ManualReason, call.Manual
+	})
+}
+
+// Sets the annotation of the generated Sidecars and TrafficSettings to the calls they allow that describe selects,
+// as "source => target (note)" with the note it returns for them
+func annotateCalls(graph *Graph, results []*typesv2.Object, annotation string, describe func(*Call) (string, bool)) {
+	// Sidecar namespace or TrafficSetting group FQN => described edges
+	edges := make(map[string][]string)
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil {
+			continue
+		}
+		note, ok := describe(call)
+		if !ok {
+			continue
+		}
+		edge := fmt.Sprintf("%s => %s (%s)", call.SourceService.FQN, call.TargetService.FQN, note)
+		keys := []string{call.SourceTrafficGroup.FQN}
+		if call.SourceTrafficGroup.ConfigMode == "DIRECT" {
+			keys = call.SourceNamespaces
+		}
+		for _, k := range keys {
+			if !slices.Contains(edges[k], edge) {
+				edges[k] = append(edges[k], edge)
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+
+	for _, obj := range results {
+		meta := obj.GetMetadata()
+		var key string
+		switch obj.GetKind() {
+		case api.IstioSidecarKind:
+			key = meta.GetNamespace()
+		case api.TrafficSettingKind:
+			key = fmt.Sprintf("organizations/%s/tenants/%s/workspaces/%s/trafficgroups/%s",
+				meta.GetOrganization(), meta.GetTenant(), meta.GetWorkspace(), meta.GetGroup())
+		default:
+			continue
+		}
+		if len(edges[key]) == 0 {
+			continue
+		}
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[annotation] = strings.Join(edges[key], "; ")
+	}
+}
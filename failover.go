@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// A destination that fails over to its deployments in other localities, as namespace/service
+type failoverHost struct {
+	Namespace string
+	Service   string
+}
+
+// Returns the destinations the DestinationRules in the cluster enable locality failover for. TSB translates its
+// resilience settings into DestinationRules too, so they are found this way as well.
+func detectFailoverHosts(kubectl *Kubectl) ([]failoverHost, error) {
+	out, err := kubectl.run(nil, "get", "destinationrules.networking.istio.io", "--all-namespaces", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DestinationRules: %w", err)
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				Host          string `json:"host"`
+				TrafficPolicy struct {
+					LoadBalancer struct {
+						LocalityLbSetting *struct {
+							Enabled *bool `json:"enabled"`
+						} `json:"localityLbSetting"`
+					} `json:"loadBalancer"`
+					OutlierDetection json.RawMessage `json:"outlierDetection"`
+				} `json:"trafficPolicy"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse DestinationRules: %w", err)
+	}
+
+	var hosts []failoverHost
+	for _, dr := range list.Items {
+		policy := dr.Spec.TrafficPolicy
+		lb := policy.LoadBalancer.LocalityLbSetting
+		// Istio only fails over with outlier detection, which tells it the local endpoints are unhealthy
+		if lb == nil || (lb.Enabled != nil && !*lb.Enabled) || len(policy.OutlierDetection) == 0 {
+			continue
+		}
+		// hosts are either short names in the namespace of the rule, or <service>.<namespace>[.svc.cluster.local]
+		parts := strings.Split(dr.Spec.Host, ".")
+		host := failoverHost{Namespace: dr.Metadata.Namespace, Service: parts[0]}
+		if len(parts) > 1 {
+			host.Namespace = parts[1]
+		}
+		if host.Service == "*" {
+			debug("skipping wildcard failover host %q", dr.Spec.Host)
+			continue
+		}
+		debug("locality failover enabled for %s/%s", host.Namespace, host.Service)
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// Returns whether the service is one of the failover destinations, in any of its namespaces
+func hasFailover(hosts []failoverHost, service *Service) bool {
+	for _, dep := range service.ServiceDeployments {
+		_, ns := parseDeployment(dep)
+		if slices.Contains(hosts, failoverHost{Namespace: ns, Service: deploymentService(dep)}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Widens the target of the call to every namespace and cluster its service is deployed in when it fails over, since
+// traffic goes to them when the local endpoints are unhealthy even if the window didn't show it.
+func includeFailover(runtime *Runtime, call *Call) {
+	if !hasFailover(runtime.failoverHosts, call.TargetService) {
+		return
+	}
+	call.Failover = true
+	call.TargetNamespaces = mergeSorted(call.TargetNamespaces, parseNamespace(call.TargetService))
+	call.TargetClusters = serviceClusters(call.TargetService, call.TargetNamespaces)
+	explainf(explainGraph, "call %s: %s fails over, allowing its namespaces %v in clusters %v",
+		call.ID, call.TargetService.FQN, call.TargetNamespaces, call.TargetClusters)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// Risks of applying a generated object over the live one, from the safest to the riskiest
+const (
+	riskNone     = "none"
+	riskAddition = "addition"
+	riskRemoval  = "removal"
+	riskNew      = "new"
+)
+
+var riskLevels = []string{riskNone, riskAddition, riskRemoval, riskNew}
+
+// A change --apply-max-risk kept from being applied, waiting for approval in the state file
+type PendingChange struct {
+	// short hash of the manifest, to approve it by
+	ID       string            `json:"id"`
+	Resource GeneratedResource `json:"resource"`
+	Risk     string            `json:"risk"`
+	Reason   string            `json:"reason"`
+	// manifest to apply once approved, with the resourceVersion of the live object it was compared to, if it exists,
+	// so it isn't applied over a later change
+	Manifest        string    `json:"manifest"`
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	Queued          time.Time `json:"queued"`
+}
+
+// The changes queued for approval in the run, for the state file
+var pendingChanges []*PendingChange
+
+// The parts of a live or generated object that tell the risk of a change
+type hostsObject struct {
+	Metadata struct {
+		ResourceVersion string            `json:"resourceVersion"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Egress []struct {
+			Hosts []string `json:"hosts"`
+		} `json:"egress"`
+		Reachability struct {
+			Hosts []string `json:"hosts"`
+		} `json:"reachability"`
+	} `json:"spec"`
+}
+
+// Returns the hosts the Sidecar or TrafficSetting allows, sorted
+func (o *hostsObject) hosts() []string {
+	hosts := o.Spec.Reachability.Hosts
+	for _, e := range o.Spec.Egress {
+		hosts = mergeSorted(hosts, e.Hosts)
+	}
+	return mergeSorted(nil, hosts)
+}
+
+// Returns the object in the cluster the manifest is for, or nil if there is none
+func getLiveObject(kubectl *Kubectl, manifest []byte) (*hostsObject, error) {
+	out, err := kubectl.run(manifest, "get", "--ignore-not-found", "-o", "json", "-f", "-")
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(out))) == 0 {
+		return nil, nil
+	}
+	live := &hostsObject{}
+	if err := yaml.Unmarshal(out, live); err != nil {
+		return nil, fmt.Errorf("failed to parse live object: %w", err)
+	}
+	return live, nil
+}
+
+// Returns the risk of applying the manifest over the live object, and why: new objects lock down the workloads they
+// apply to, removing hosts can break the calls to them, and adding hosts only allows more
+func changeRisk(manifest []byte, live *hostsObject) (string, string, error) {
+	if live == nil {
+		return riskNew, "new object, locking down the hosts its workloads can reach", nil
+	}
+	desired := &hostsObject{}
+	if err := yaml.Unmarshal(manifest, desired); err != nil {
+		return "", "", fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	want, have := desired.hosts(), live.hosts()
+	var added, removed []string
+	for _, h := range want {
+		if !slices.Contains(have, h) {
+			added = append(added, h)
+		}
+	}
+	for _, h := range have {
+		if !slices.Contains(want, h) {
+			removed = append(removed, h)
+		}
+	}
+	switch {
+	case len(removed) > 0:
+		return riskRemoval, "removes " + strings.Join(removed, ", "), nil
+	case len(added) > 0:
+		return riskAddition, "adds " + strings.Join(added, ", "), nil
+	}
+	return riskNone, "no host changes", nil
+}
+
+// Returns whether a change of the risk can be applied without approval with the --apply-max-risk
+func riskAllowed(risk, maxRisk string) bool {
+	return maxRisk == "" || slices.Index(riskLevels, risk) <= slices.Index(riskLevels, maxRisk)
+}
+
+// Returns the ID of the change to the manifest: a short hash of it without its revision label, which changes every
+// run, so the same change keeps its ID when queued again by later runs
+func changeID(manifest []byte) (string, error) {
+	obj := make(map[string]any)
+	if err := yaml.Unmarshal(manifest, &obj); err != nil {
+		return "", fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if metadata, ok := obj["metadata"].(map[string]any); ok {
+		if labels, ok := metadata["labels"].(map[string]any); ok {
+			delete(labels, revisionLabel)
+		}
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:8], nil
+}
+
+// Queues the change for approval instead of applying it
+func queueChange(resource GeneratedResource, risk, reason string, manifest []byte, resourceVersion string) error {
+	id, err := changeID(manifest)
+	if err != nil {
+		return err
+	}
+	change := &PendingChange{
+		ID:              id,
+		Resource:        resource,
+		Risk:            risk,
+		Reason:          reason,
+		Manifest:        string(manifest),
+		ResourceVersion: resourceVersion,
+		Queued:          time.Now(),
+	}
+	pendingChanges = append(pendingChanges, change)
+	warn("%s: queued for approval, the risk of the change (%s: %s) is above --apply-max-risk; approve it with `approve %s`",
+		resource, risk, reason, change.ID)
+	return nil
+}
+
+func newApproveCmd(runtime *Runtime) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "approve [id...]",
+		Short: "List the changes --apply-max-risk queued for approval, or apply the given ones",
+		Long: `List the changes --apply-max-risk queued for approval, or apply the given ones.
+
+With --apply and --apply-max-risk, the changes riskier than the threshold aren't applied but queued in the
+--state-file. Without arguments, the queued changes are listed with their ID, risk and reason; with IDs, or --all,
+those changes are applied and removed from the queue. A change is only applied if the live object hasn't changed
+since it was queued; otherwise, generate again to queue it anew.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runtime.stateFile == "" {
+				return fmt.Errorf("approve requires --state-file, where the changes are queued")
+			}
+			// the state file is rewritten, so approving can't overlap with a run
+			release, err := acquireLock(runtime)
+			if err != nil {
+				return err
+			}
+			defer release()
+			state, err := loadState(runtime.stateFile)
+			if err != nil {
+				return err
+			}
+			if state == nil || len(state.Pending) == 0 {
+				fmt.Fprintln(logOut, "no changes queued for approval")
+				return nil
+			}
+			if len(args) == 0 && !all {
+				writePending(cmd.OutOrStdout(), state.Pending)
+				return nil
+			}
+			for _, id := range args {
+				if !slices.ContainsFunc(state.Pending, func(c *PendingChange) bool { return c.ID == id }) {
+					return fmt.Errorf("no change %q queued for approval", id)
+				}
+			}
+
+			var remaining []*PendingChange
+			var applyErr error
+			for _, change := range state.Pending {
+				if applyErr != nil || (!all && !slices.Contains(args, change.ID)) {
+					remaining = append(remaining, change)
+					continue
+				}
+				if applyErr = applyApproved(runtime.kubectl, change, runtime.dryRun); applyErr != nil {
+					remaining = append(remaining, change)
+				}
+			}
+			if runtime.dryRun != dryRunNone {
+				// nothing was applied, so the queue stays as it is
+				return applyErr
+			}
+			// the approved changes are removed from the queue even if a later one fails
+			state.Pending = remaining
+			if err := writeState(runtime.stateFile, state); err != nil {
+				return err
+			}
+			return applyErr
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Apply every change queued for approval")
+	return cmd
+}
+
+// Applies the approved change, conditionally on the live object not having changed since it was queued. With
+// --dry-run, kubectl only validates it, client or server side.
+func applyApproved(kubectl *Kubectl, change *PendingChange, dryRun string) error {
+	args := []string{"apply", "-f", "-"}
+	if change.ResourceVersion == "" {
+		args = []string{"create", "--save-config", "-f", "-"}
+	}
+	if dryRun != dryRunNone {
+		args = append(args, "--dry-run="+dryRun)
+	}
+	out, err := kubectl.run([]byte(change.Manifest), args...)
+	if err != nil {
+		if isConflict(err) {
+			return fmt.Errorf("%s changed since change %s was queued; generate again to queue it anew: %w", change.Resource, change.ID, err)
+		}
+		return fmt.Errorf("failed to apply change %s to %s: %w", change.ID, change.Resource, err)
+	}
+	fmt.Fprintf(logOut, "%s\n", strings.TrimSpace(string(out)))
+	return nil
+}
+
+func writePending(out io.Writer, pending []*PendingChange) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRISK\tRESOURCE\tREASON\tQUEUED")
+	for _, c := range pending {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Risk, c.Resource, c.Reason, c.Queued.Format(time.RFC3339))
+	}
+	w.Flush()
+}
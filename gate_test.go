@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const testSidecarManifest = `apiVersion: networking.istio.io/v1beta1
+kind: Sidecar
+metadata:
+  name: reachability-sidecar
+  namespace: a
+  labels:
+    generate-sidecar-tool/revision: %s
+spec:
+  egress:
+  - hosts:
+    - istio-system/*
+    - b/*
+`
+
+// Returns the live object for the YAML manifest
+func testLiveObject(t *testing.T, manifest string) *hostsObject {
+	t.Helper()
+	live := &hostsObject{}
+	if err := yaml.Unmarshal([]byte(manifest), live); err != nil {
+		t.Fatal(err)
+	}
+	return live
+}
+
+func TestChangeRisk(t *testing.T) {
+	manifest := []byte(`spec:
+  egress:
+  - hosts: [istio-system/*, b/*]
+`)
+	tests := []struct {
+		name       string
+		live       string
+		none       bool
+		wantRisk   string
+		wantReason string
+	}{
+		{name: "new object", none: true, wantRisk: riskNew, wantReason: "new object, locking down the hosts its workloads can reach"},
+		{name: "same hosts", live: "spec: {egress: [{hosts: [b/*, istio-system/*]}]}", wantRisk: riskNone, wantReason: "no host changes"},
+		{name: "added host", live: "spec: {egress: [{hosts: [istio-system/*]}]}", wantRisk: riskAddition, wantReason: "adds b/*"},
+		{name: "removed host", live: "spec: {egress: [{hosts: [istio-system/*, b/*, c/*]}]}", wantRisk: riskRemoval, wantReason: "removes c/*"},
+		{name: "added and removed", live: "spec: {egress: [{hosts: [istio-system/*, c/*]}]}", wantRisk: riskRemoval, wantReason: "removes c/*"},
+		{name: "from a TrafficSetting", live: "spec: {reachability: {hosts: [istio-system/*]}}", wantRisk: riskAddition, wantReason: "adds b/*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var live *hostsObject
+			if !tt.none {
+				live = testLiveObject(t, tt.live)
+			}
+			risk, reason, err := changeRisk(manifest, live)
+			if err != nil {
+				t.Fatalf("changeRisk() error = %v", err)
+			}
+			if risk != tt.wantRisk || reason != tt.wantReason {
+				t.Errorf("changeRisk() = %q, %q, want %q, %q", risk, reason, tt.wantRisk, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRiskAllowed(t *testing.T) {
+	tests := []struct {
+		risk, maxRisk string
+		want          bool
+	}{
+		{risk: riskNew, maxRisk: "", want: true},
+		{risk: riskNone, maxRisk: riskNone, want: true},
+		{risk: riskAddition, maxRisk: riskNone, want: false},
+		{risk: riskAddition, maxRisk: riskAddition, want: true},
+		{risk: riskRemoval, maxRisk: riskAddition, want: false},
+		{risk: riskNew, maxRisk: riskRemoval, want: false},
+		{risk: riskRemoval, maxRisk: riskNew, want: true},
+	}
+	for _, tt := range tests {
+		if got := riskAllowed(tt.risk, tt.maxRisk); got != tt.want {
+			t.Errorf("riskAllowed(%q, %q) = %v, want %v", tt.risk, tt.maxRisk, got, tt.want)
+		}
+	}
+}
+
+func TestChangeID(t *testing.T) {
+	id := func(manifest string) string {
+		t.Helper()
+		id, err := changeID([]byte(manifest))
+		if err != nil {
+			t.Fatalf("changeID() error = %v", err)
+		}
+		return id
+	}
+	first := id(fmt.Sprintf(testSidecarManifest, "20240101T000000Z"))
+	if len(first) != 8 {
+		t.Errorf("changeID() = %q, want 8 characters", first)
+	}
+	if again := id(fmt.Sprintf(testSidecarManifest, "20240102T000000Z")); again != first {
+		t.Errorf("changeID() = %q for another revision, want %q", again, first)
+	}
+	if other := id(fmt.Sprintf(testSidecarManifest, "20240101T000000Z") + "    - c/*\n"); other == first {
+		t.Errorf("changeID() = %q for other hosts too", other)
+	}
+	if _, err := changeID([]byte("a: [")); err == nil {
+		t.Errorf("changeID() of an invalid manifest didn't fail")
+	}
+}
+
+func TestSavePending(t *testing.T) {
+	a := GeneratedResource{Kind: "Sidecar", Namespace: "a", Name: "reachability-sidecar"}
+	b := GeneratedResource{Kind: "Sidecar", Namespace: "b", Name: "reachability-sidecar"}
+	previous := &State{
+		Window:  Window{End: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Edges:   []*Edge{{}},
+		Pending: []*PendingChange{{ID: "a-old", Resource: a}, {ID: "b-old", Resource: b}},
+	}
+	pendingChanges = []*PendingChange{{ID: "a-new", Resource: a}}
+	defer func() { pendingChanges = nil }()
+
+	runtime := &Runtime{stateFile: filepath.Join(t.TempDir(), "state.json")}
+	if err := savePending(runtime, previous); err != nil {
+		t.Fatalf("savePending() error = %v", err)
+	}
+	state, err := loadState(runtime.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, c := range state.Pending {
+		ids = append(ids, c.ID)
+	}
+	if fmt.Sprint(ids) != "[b-old a-new]" {
+		t.Errorf("savePending() queued %v, want [b-old a-new]", ids)
+	}
+	if !state.Window.End.Equal(previous.Window.End) || len(state.Edges) != 1 {
+		t.Errorf("savePending() didn't keep the rest of the previous state: %+v", state)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// What an in-process generation runs with. Zero fields keep the ones of the runtime, which is required.
+type GenerateOptions struct {
+	// the configured runtime, with its own TSB client; Generate works on a copy of it
+	Runtime *Runtime
+	// window to generate from
+	Start, End time.Time
+	// namespaces and service FQNs to limit the generation to, like --scope-from
+	Scope []string
+}
+
+// What an in-process generation produced
+type GenerateResult struct {
+	Window      Window
+	Graph       *Graph
+	Resources   []*typesv2.Object
+	Revision    string
+	Diagnostics []Diagnostic
+}
+
+var (
+	// a run keeps its diagnostics, timing and progress in package state, so in-process generations take turns
+	generateMu sync.Mutex
+	// the callback of the in-process generation in progress, getting the same events as the --events-file
+	progressFn func(*Event)
+)
+
+// Generates the resources for the window and scope of the options without printing, applying or recording them, for
+// serve to generate in process. The progress callback, if any, gets the events of the run as they happen, like the
+// --events-file. A run keeps its diagnostics, timing and logging in package state, so concurrent calls are serialized:
+// each waits for the ones before it. The requests to TSB are sent with the context, so canceling it stops the call.
+func Generate(ctx context.Context, opts GenerateOptions, progress func(*Event)) (result *GenerateResult, err error) {
+	if opts.Runtime == nil {
+		return nil, errors.New("no runtime to generate with")
+	}
+	generateMu.Lock()
+	defer generateMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	runtime := *opts.Runtime
+	if !opts.Start.IsZero() {
+		runtime.start = opts.Start
+	}
+	if !opts.End.IsZero() {
+		runtime.end = opts.End
+	}
+	if opts.Scope != nil {
+		runtime.scope = opts.Scope
+	}
+	if !runtime.start.Before(runtime.end) {
+		return nil, fmt.Errorf("the start of the window %s isn't before its end", Window{Start: runtime.start, End: runtime.end})
+	}
+
+	progressFn = progress
+	diagnostics, skippedHosts, undecodedGroups, throttling, pendingChanges = nil, make(map[string][]string), nil, nil, nil
+	timing = newRunTiming(runtime.budgets, runtime.onBudgetExceeded)
+	emitEvent(&Event{Type: eventRunStarted})
+	defer func() {
+		completed := &Event{Type: eventRunCompleted}
+		if result != nil {
+			completed.Resources = len(result.Resources)
+		}
+		if err != nil {
+			completed.Error = err.Error()
+		}
+		emitEvent(completed)
+		progressFn, timing = nil, nil
+	}()
+	if runtime.client != nil {
+		defer runtime.client.BindContext(ctx)()
+	}
+
+	graph, err := fetchGraph(&runtime)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timing.begin(phaseGeneration)
+	results, err := generateResources(&runtime, graph)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	revision, err := resultsRevision(runtime.revision, time.Now(), results)
+	if err != nil {
+		return nil, err
+	}
+	labelRevision(results, revision)
+	if err := timing.end(); err != nil {
+		return nil, err
+	}
+
+	return &GenerateResult{
+		Window:      Window{Start: runtime.start, End: runtime.end},
+		Graph:       graph,
+		Resources:   results,
+		Revision:    revision,
+		Diagnostics: diagnostics,
+	}, nil
+}
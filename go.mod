@@ -4,13 +4,16 @@ go 1.20
 
 require (
 	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
 	github.com/tetrateio/api v0.0.0-20230727031048-0a7e0d2cfcae
 	github.com/tetrateio/tetrate v0.0.2-0.20230727134335-50925c84a5a9
 	golang.org/x/exp v0.0.0-20230725093048-515e97ebf090
 	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
 	istio.io/api v1.19.0-alpha.1.0.20230707182832-df0d3338f45a
 	istio.io/client-go v1.19.0-alpha.1.0.20230707183633-3e6aaa13c63c
 	k8s.io/apimachinery v0.27.4
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -65,7 +68,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/rubenv/sql-migrate v1.2.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tetrateio/ngac v0.0.4 // indirect
 	github.com/tetratelabs/multierror v1.1.1 // indirect
 	github.com/tetratelabs/telemetry v0.7.5 // indirect
@@ -88,5 +90,4 @@ require (
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b // indirect
 	sigs.k8s.io/json v0.0.0-20221116044647-bc3834ca7abd // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.3.0 // indirect
-	sigs.k8s.io/yaml v1.3.0 // indirect
 )
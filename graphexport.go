@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Labels and relationship types of the exported graph
+const (
+	exportService   = "Service"
+	exportNamespace = "Namespace"
+	// a service calls another one
+	exportCalls = "CALLS"
+	// a service is deployed in a namespace
+	exportIn = "IN"
+)
+
+// client of the Neo4j HTTP API, so an unresponsive database fails the export instead of hanging it
+var neo4jClient = &http.Client{Timeout: 5 * time.Minute}
+
+// A node of the exported graph
+type exportNode struct {
+	ID    string `json:"id"`
+	Label string `json:"-"`
+	Name  string `json:"name"`
+}
+
+// A relationship of the exported graph
+type exportRelationship struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Type  string `json:"-"`
+	// the call the CALLS relationship comes from, and how it was found
+	CallID     string   `json:"callId,omitempty"`
+	Kind       string   `json:"kind,omitempty"`
+	Components []string `json:"components,omitempty"`
+}
+
+// The graph to export, with the service and namespace nodes sorted by ID
+type exportGraph struct {
+	Nodes         []*exportNode
+	Relationships []*exportRelationship
+}
+
+func newGraphCmd(runtime *Runtime) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Work with the graph of observed calls",
+	}
+
+	var csvDir, neo4j, neo4jUser, neo4jPassword, neo4jPasswordFile, neo4jDatabase string
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Export the graph of observed calls to a graph database, or as CSV files to import in one",
+		Long: `Export the graph of observed calls to a graph database, or as CSV files to import in one.
+
+The graph has a Service node for each service in a call, and a Namespace node for each of their namespaces. Services
+are IN their namespaces, and CALLS the services they were observed calling, with the call ID, its components and how
+it was found (observed, mirrored, east-west or manual), so path and centrality queries can be run on it.
+
+With --csv-dir, nodes.csv and relationships.csv are written in the format of neo4j-admin import, which other graph
+databases can load too. With --neo4j, the graph is merged into the database through the Neo4j HTTP API, e.g.
+http://neo4j:7474; the Bolt protocol isn't supported.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (csvDir == "") == (neo4j == "") {
+				return fmt.Errorf("exactly one of --csv-dir and --neo4j must be set")
+			}
+			if neo4jPasswordFile != "" {
+				if neo4jPassword != "" {
+					return fmt.Errorf("only one of --neo4j-password and --neo4j-password-file can be set")
+				}
+				data, err := os.ReadFile(neo4jPasswordFile)
+				if err != nil {
+					return fmt.Errorf("failed to read --neo4j-password-file %q: %w", neo4jPasswordFile, err)
+				}
+				neo4jPassword = strings.TrimSpace(string(data))
+			}
+			addSecret(neo4jPassword)
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			exported := buildExportGraph(graph)
+			if csvDir != "" {
+				return writeExportCSV(csvDir, exported)
+			}
+			if runtime.dryRun != dryRunNone {
+				fmt.Fprintf(logOut, "would merge %d nodes and %d relationships into %s (dry run)\n",
+					len(exported.Nodes), len(exported.Relationships), neo4j)
+				return nil
+			}
+			return pushNeo4j(neo4j, neo4jDatabase, neo4jUser, neo4jPassword, exported)
+		},
+	}
+	export.Flags().StringVar(&csvDir, "csv-dir", "", "Directory to write nodes.csv and relationships.csv to, in the neo4j-admin import format")
+	export.Flags().StringVar(&neo4j, "neo4j", "", "URL of the Neo4j HTTP API to merge the graph into, e.g. http://neo4j:7474")
+	export.Flags().StringVar(&neo4jDatabase, "neo4j-database", "neo4j", "Neo4j database to merge the graph into")
+	export.Flags().StringVar(&neo4jUser, "neo4j-user", "neo4j", "Neo4j username")
+	export.Flags().StringVar(&neo4jPassword, "neo4j-password", "", "Neo4j password; prefer --neo4j-password-file, as flags show up in the process list")
+	export.Flags().StringVar(&neo4jPasswordFile, "neo4j-password-file", "", "File with the Neo4j password, like a mounted secret")
+	cmd.AddCommand(export)
+	return cmd
+}
+
+// Returns the nodes and relationships of the calls in the graph
+func buildExportGraph(graph *Graph) *exportGraph {
+	nodes := make(map[string]*exportNode)
+	rels := make(map[string]*exportRelationship)
+	addService := func(svc *Service, namespaces []string) {
+		nodes[svc.FQN] = &exportNode{ID: svc.FQN, Label: exportService, Name: serviceName(nameStyleDisplay, svc)}
+		for _, ns := range namespaces {
+			id := "namespace/" + ns
+			nodes[id] = &exportNode{ID: id, Label: exportNamespace, Name: ns}
+			rels[svc.FQN+" "+exportIn+" "+id] = &exportRelationship{Start: svc.FQN, End: id, Type: exportIn}
+		}
+	}
+
+	for _, call := range graph.Calls {
+		addService(call.SourceService, append(slices.Clone(call.SourceNamespaces), call.ExcludedNamespaces...))
+		addService(call.TargetService, call.TargetNamespaces)
+		kind := "observed"
+		switch {
+		case call.Mirrored:
+			kind = "mirrored"
+		case call.EastWest:
+			kind = "east-west"
+		case call.Manual:
+			kind = "manual"
+		}
+		key := call.SourceService.FQN + " " + exportCalls + " " + call.TargetService.FQN + " " + call.ID
+		rels[key] = &exportRelationship{
+			Start:      call.SourceService.FQN,
+			End:        call.TargetService.FQN,
+			Type:       exportCalls,
+			CallID:     call.ID,
+			Kind:       kind,
+			Components: call.Components,
+		}
+	}
+
+	out := &exportGraph{}
+	ids := maps.Keys(nodes)
+	slices.Sort(ids)
+	for _, id := range ids {
+		out.Nodes = append(out.Nodes, nodes[id])
+	}
+	keys := maps.Keys(rels)
+	slices.Sort(keys)
+	for _, k := range keys {
+		out.Relationships = append(out.Relationships, rels[k])
+	}
+	return out
+}
+
+// Writes the graph as nodes.csv and relationships.csv, with the headers neo4j-admin import expects. Arrays are
+// joined with ";", its default array delimiter.
+func writeExportCSV(dir string, graph *exportGraph) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create --csv-dir %q: %w", dir, err)
+	}
+
+	nodes := [][]string{{"id:ID", ":LABEL", "name"}}
+	for _, n := range graph.Nodes {
+		nodes = append(nodes, []string{n.ID, n.Label, n.Name})
+	}
+	rels := [][]string{{":START_ID", ":END_ID", ":TYPE", "callId", "kind", "components:string[]"}}
+	for _, r := range graph.Relationships {
+		rels = append(rels, []string{r.Start, r.End, r.Type, r.CallID, r.Kind, strings.Join(r.Components, ";")})
+	}
+
+	for name, records := range map[string][][]string{"nodes.csv": nodes, "relationships.csv": rels} {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.WriteAll(records); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("failed to write %q: %w", path, err)
+		}
+	}
+	fmt.Fprintf(logOut, "wrote %d nodes and %d relationships to %s\n", len(graph.Nodes), len(graph.Relationships), dir)
+	return nil
+}
+
+// Cypher statements merging the graph, so exporting it again updates the nodes and relationships rather than
+// duplicating them
+var neo4jStatements = map[string]string{
+	exportService:   "UNWIND $rows AS row MERGE (n:Service {id: row.id}) SET n.name = row.name",
+	exportNamespace: "UNWIND $rows AS row MERGE (n:Namespace {id: row.id}) SET n.name = row.name",
+	exportIn:        "UNWIND $rows AS row MATCH (s:Service {id: row.start}), (n:Namespace {id: row.end}) MERGE (s)-[:IN]->(n)",
+	exportCalls: "UNWIND $rows AS row MATCH (s:Service {id: row.start}), (t:Service {id: row.end}) " +
+		"MERGE (s)-[r:CALLS {callId: row.callId}]->(t) SET r.kind = row.kind, r.components = row.components",
+}
+
+// Merges the graph into the Neo4j database in a single transaction, through its HTTP API
+func pushNeo4j(endpoint, database, user, password string, graph *exportGraph) error {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid --neo4j %q: %w", endpoint, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return fmt.Errorf("invalid --neo4j %q: only the HTTP API is supported, e.g. http://neo4j:7474", endpoint)
+	}
+
+	rows := make(map[string][]any)
+	for _, n := range graph.Nodes {
+		rows[n.Label] = append(rows[n.Label], n)
+	}
+	for _, r := range graph.Relationships {
+		rows[r.Type] = append(rows[r.Type], r)
+	}
+	type statement struct {
+		Statement  string         `json:"statement"`
+		Parameters map[string]any `json:"parameters"`
+	}
+	var statements []statement
+	// nodes first, so the relationships find them
+	for _, kind := range []string{exportService, exportNamespace, exportIn, exportCalls} {
+		if len(rows[kind]) > 0 {
+			statements = append(statements, statement{Statement: neo4jStatements[kind], Parameters: map[string]any{"rows": rows[kind]}})
+		}
+	}
+	body, err := json.Marshal(map[string]any{"statements": statements})
+	if err != nil {
+		return fmt.Errorf("failed to marshal statements: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, base.JoinPath("db", database, "tx", "commit").String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if password != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := neo4jClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send the graph to Neo4j: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to send the graph to Neo4j: unexpected status %d", resp.StatusCode)
+	}
+
+	// statement errors are reported in the body, with a 200
+	var result struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse the Neo4j response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("the Neo4j database rejected the graph: %s: %s", result.Errors[0].Code, result.Errors[0].Message)
+	}
+	fmt.Fprintf(logOut, "merged %d nodes and %d relationships into Neo4j\n", len(graph.Nodes), len(graph.Relationships))
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPushNeo4j(t *testing.T) {
+	graph := &exportGraph{
+		Nodes:         []*exportNode{{ID: "a", Label: exportService, Name: "a"}, {ID: "namespace/ns", Label: exportNamespace, Name: "ns"}},
+		Relationships: []*exportRelationship{{Start: "a", End: "namespace/ns", Type: exportIn}},
+	}
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantErr  bool
+	}{
+		{name: "merged", status: http.StatusOK, response: `{"results":[],"errors":[]}`},
+		{name: "statement error", status: http.StatusOK, response: `{"errors":[{"code":"Neo.ClientError.Statement.SyntaxError","message":"bad"}]}`, wantErr: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, response: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statements int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/db/graphs/tx/commit" {
+					t.Errorf("pushNeo4j() sent to %q", r.URL.Path)
+				}
+				if user, password, ok := r.BasicAuth(); !ok || user != "neo4j" || password != "s3cret" {
+					t.Errorf("pushNeo4j() authenticated as %q, %q", user, password)
+				}
+				var body struct {
+					Statements []json.RawMessage `json:"statements"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("pushNeo4j() sent an invalid body: %v", err)
+				}
+				statements = len(body.Statements)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			err := pushNeo4j(server.URL, "graphs", "neo4j", "s3cret", graph)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pushNeo4j() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// one for each kind of node and relationship in the graph
+			if statements != 3 {
+				t.Errorf("pushNeo4j() sent %d statements, want 3", statements)
+			}
+		})
+	}
+	if err := pushNeo4j("bolt://neo4j:7687", "neo4j", "neo4j", "", graph); err == nil {
+		t.Errorf("pushNeo4j() accepted a Bolt URL")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// What --graph-out writes and --graph-in reads: the graph built from TSB, and the window it was built for
+type graphFile struct {
+	Window   Window    `json:"window"`
+	Calls    []*Call   `json:"calls"`
+	Services []Service `json:"services"`
+}
+
+// Writes the graph of the window to the file, so later runs can generate from it with --graph-in
+func writeGraph(path string, window Window, graph *Graph) error {
+	data, err := json.MarshalIndent(&graphFile{Window: window, Calls: graph.Calls, Services: graph.Services}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal graph: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write --graph-out %q: %w", path, err)
+	}
+	debug("wrote graph with %d calls to %q", len(graph.Calls), path)
+	return nil
+}
+
+// Reads the graph written by --graph-out, and the window it was built for
+func readGraph(path string) (*Graph, Window, error) {
+	data, err := readInput("--graph-in", path)
+	if err != nil {
+		return nil, Window{}, fmt.Errorf("failed to read --graph-in %q: %w", inputName(path), err)
+	}
+	file := &graphFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, Window{}, fmt.Errorf("failed to parse --graph-in %q: %w", inputName(path), err)
+	}
+	debug("read graph with %d calls of the window %s from %q", len(file.Calls), file.Window, inputName(path))
+	return &Graph{Calls: file.Calls, Services: file.Services}, file.Window, nil
+}
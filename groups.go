@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	tsbv2 "github.com/tetrateio/api/tsb/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	workspaceAPI     = "api.tsb.tetrate.io/v2"
+	workspaceKind    = "Workspace"
+	trafficGroupKind = "Group"
+)
+
+// Returns the organization of a TSB FQN, e.g. "tetrate" for "organizations/tetrate/services/foo"
+func fqnOrganization(fqn string) string {
+	parts := strings.Split(fqn, "/")
+	if len(parts) < 2 || parts[0] != "organizations" {
+		return ""
+	}
+	return parts[1]
+}
+
+// Creates a BRIDGED traffic group for each source namespace of the calls whose service has no group, and attaches
+// them to the calls so that reachability is generated for them. Calls from services in several namespaces are split
+// into a call per namespace, as each namespace gets its own group. Returns the resources for the new groups, and for
+// the workspaces holding them if they don't exist yet.
+func createGroups(runtime *Runtime, graph *Graph) ([]*typesv2.Object, error) {
+	groups := make(map[string]*TrafficGroup)
+	// workspace FQN => namespaces of the new groups in it
+	workspaces := make(map[string][]string)
+
+	calls := make([]*Call, 0, len(graph.Calls))
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup != nil {
+			calls = append(calls, call)
+			continue
+		}
+
+		wsFQN := fmt.Sprintf("organizations/%s/tenants/%s/workspaces/%s",
+			fqnOrganization(call.SourceService.FQN), runtime.createGroupsTenant, runtime.createGroupsWorkspace)
+		for _, ns := range call.SourceNamespaces {
+			groupFQN := fmt.Sprintf("%s/trafficgroups/%s", wsFQN, ns)
+			if _, ok := groups[groupFQN]; !ok {
+				groups[groupFQN] = &TrafficGroup{ConfigMode: "BRIDGED", FQN: groupFQN}
+				workspaces[wsFQN] = append(workspaces[wsFQN], ns)
+				diagnose(diagCreatingTrafficGroup, call.SourceService.FQN, groupFQN)
+			}
+
+			split := *call
+			split.SourceNamespaces = []string{ns}
+			split.SourceTrafficGroup = groups[groupFQN]
+			calls = append(calls, &split)
+		}
+	}
+	graph.Calls = calls
+
+	var results []*typesv2.Object
+	wsFQNs := maps.Keys(workspaces)
+	slices.Sort(wsFQNs)
+	for _, wsFQN := range wsFQNs {
+		namespaces := workspaces[wsFQN]
+		slices.Sort(namespaces)
+		selector := make([]string, 0, len(namespaces))
+		for _, ns := range namespaces {
+			selector = append(selector, "*/"+ns)
+		}
+
+		meta, err := parseFQN(wsFQN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create workspace for the groups of %s: %w", strings.Join(namespaces, ", "), err)
+		}
+		exists, err := runtime.client.WorkspaceExists(wsFQN)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			diagnose(diagWorkspaceExists, wsFQN, strings.Join(selector, ", "))
+		} else {
+			ws, err := newObject(workspaceAPI, workspaceKind,
+				&typesv2.ObjectMeta{Organization: meta.Organization, Tenant: meta.Tenant, Name: meta.Workspace},
+				&tsbv2.Workspace{NamespaceSelector: &typesv2.NamespaceSelector{Names: selector}})
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, ws)
+		}
+
+		for i, ns := range namespaces {
+			group, err := newObject(api.TrafficAPI, trafficGroupKind,
+				&typesv2.ObjectMeta{Organization: meta.Organization, Tenant: meta.Tenant, Workspace: meta.Workspace, Name: ns},
+				&trafficv2.Group{
+					NamespaceSelector: &typesv2.NamespaceSelector{Names: []string{selector[i]}},
+					ConfigMode:        typesv2.ConfigMode_BRIDGED,
+				})
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, group)
+		}
+	}
+	return results, nil
+}
+
+// Wraps the spec into an object of the given kind
+func newObject(apiVersion, kind string, meta *typesv2.ObjectMeta, spec proto.Message) (*typesv2.Object, error) {
+	any, err := anypb.New(spec)
+	if err != nil {
+		return nil, fmt.Errorf("creating anypb: %w", err)
+	}
+	return &typesv2.Object{
+		ApiVersion: apiVersion,
+		Kind:       kind,
+		Metadata:   meta,
+		Spec:       any,
+	}, nil
+}
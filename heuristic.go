@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// A service proposed for a topology node that resolved to none, and how confident the heuristic is about it
+type mappingProposal struct {
+	Service *Service
+	// between 0 and 1
+	Confidence float64
+}
+
+// Weights of the parts of a node name the heuristic compares with the service; they add up to 1
+const (
+	heuristicNameWeight      = 0.6
+	heuristicNamespaceWeight = 0.3
+	heuristicClusterWeight   = 0.1
+)
+
+// Proposes the service a topology node with no service most likely is, comparing the parts of its name with the names,
+// namespaces and clusters of the deployments and the canonical name of each service. Returns nil if the heuristic is
+// disabled or nothing matches; a tie between services halves the confidence, so it's never auto-accepted.
+func proposeMapping(runtime *Runtime, servicesByFQN map[string]*Service, key string) *mappingProposal {
+	if !runtime.suggestMappings && runtime.autoMapConfidence == 0 {
+		return nil
+	}
+	tokens := strings.FieldsFunc(key, func(r rune) bool { return r == '|' || r == '.' || r == '/' })
+
+	var best *mappingProposal
+	tie := false
+	fqns := maps.Keys(servicesByFQN)
+	slices.Sort(fqns)
+	for _, fqn := range fqns {
+		svc := servicesByFQN[fqn]
+		score := heuristicScore(svc, tokens)
+		switch {
+		case score == 0:
+		case best == nil || score > best.Confidence:
+			best, tie = &mappingProposal{Service: svc, Confidence: score}, false
+		case score == best.Confidence:
+			tie = true
+		}
+	}
+	if best != nil && tie {
+		best.Confidence /= 2
+	}
+	return best
+}
+
+// Scores how well the service matches the parts of a node name; zero if its name doesn't match at all
+func heuristicScore(svc *Service, tokens []string) float64 {
+	var names, namespaces, clusters []string
+	if svc.CanonicalName != "" {
+		names = append(names, strings.Split(svc.CanonicalName, ".")[0])
+	}
+	for _, dep := range svc.ServiceDeployments {
+		cluster, ns := parseDeployment(dep)
+		names = append(names, deploymentService(dep))
+		namespaces = append(namespaces, ns)
+		clusters = append(clusters, cluster)
+	}
+
+	name := 0.0
+	for _, token := range tokens {
+		for _, n := range names {
+			switch {
+			case n == "":
+			case token == n:
+				name = 1
+			// renamed services usually keep a common prefix; short tokens like subsets match too much
+			case name < 0.5 && len(token) >= 3 && (strings.HasPrefix(token, n) || strings.HasPrefix(n, token)):
+				name = 0.5
+			}
+		}
+	}
+	if name == 0 {
+		return 0
+	}
+
+	score := name * heuristicNameWeight
+	if containsAny(tokens, namespaces) {
+		score += heuristicNamespaceWeight
+	}
+	if containsAny(tokens, clusters) {
+		score += heuristicClusterWeight
+	}
+	return score
+}
+
+// Reports the proposals that weren't auto-accepted, as --mapping-file entries for the user to check and add
+func reportMappingProposals(proposals map[string]*mappingProposal) {
+	if len(proposals) == 0 {
+		return
+	}
+	keys := maps.Keys(proposals)
+	slices.Sort(keys)
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		p := proposals[key]
+		entries = append(entries, fmt.Sprintf("%q: %s  # confidence %.2f", key, p.Service.FQN, p.Confidence))
+	}
+	diagnose(diagMappingProposals, strings.Join(entries, "\n  "))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Context key marking requests with other methods than GET as safe to send again. It stays within the process: the
+// servers never see it.
+type idempotentKey struct{}
+
+// Returns the context marking the requests created with it as safe to send again
+func withIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// Returns whether sending the request again has the same effect as sending it once: for reads, and for the requests
+// marked with withIdempotent, which only read despite their method
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+func newImpactCmd(runtime *Runtime) *cobra.Command {
+	return &cobra.Command{
+		Use:   "impact",
+		Short: "Estimate how much the generated policies reduce the Envoy config of each namespace",
+		Long: `Estimate how much the generated policies reduce the Envoy config of each namespace.
+
+Without a Sidecar or reachability settings, every proxy gets the config (clusters, endpoints, routes) for every
+service in the mesh. With them, proxies only get the config for the services in the namespaces they can reach.
+The number of services each proxy knows about is used as the measure of its config size.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			impact(cmd.OutOrStdout(), graph)
+			return nil
+		},
+	}
+}
+
+// Returns the FQNs of the services deployed in each namespace
+func servicesByNamespace(services []Service) map[string][]string {
+	byNs := make(map[string][]string)
+	for _, svc := range services {
+		for _, ns := range parseNamespace(&svc) {
+			if !slices.Contains(byNs[ns], svc.FQN) {
+				byNs[ns] = append(byNs[ns], svc.FQN)
+			}
+		}
+	}
+	return byNs
+}
+
+// Returns the namespaces each source namespace is allowed to reach by the generated policies
+func allowedNamespaces(graph *Graph) map[string][]string {
+	allowed := make(map[string][]string)
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil {
+			// no policy is generated for it
+			continue
+		}
+		for _, ns := range call.SourceNamespaces {
+			if _, ok := allowed[ns]; !ok {
+				for _, host := range baselineHosts {
+					allowed[ns] = append(allowed[ns], hostNamespace(host))
+				}
+			}
+			for _, dest := range call.TargetNamespaces {
+				if !slices.Contains(allowed[ns], dest) {
+					allowed[ns] = append(allowed[ns], dest)
+				}
+			}
+		}
+	}
+	return allowed
+}
+
+func impact(out io.Writer, graph *Graph) {
+	byNs := servicesByNamespace(graph.Services)
+	total := len(graph.Services)
+	allowed := allowedNamespaces(graph)
+
+	namespaces := maps.Keys(allowed)
+	slices.Sort(namespaces)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tREACHABLE NAMESPACES\tSERVICES BEFORE\tSERVICES AFTER\tREDUCTION")
+	var before, after int
+	for _, ns := range namespaces {
+		retained := make(map[string]bool)
+		for _, dest := range allowed[ns] {
+			for _, svc := range byNs[dest] {
+				retained[svc] = true
+			}
+		}
+		before += total
+		after += len(retained)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", ns, len(allowed[ns]), total, len(retained), reduction(total, len(retained)))
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%d\t%d\t%s\n", before, after, reduction(before, after))
+	w.Flush()
+}
+
+func reduction(before, after int) string {
+	if before == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", 100*float64(before-after)/float64(before))
+}
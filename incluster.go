@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// token Kubernetes mounts in pods for their service account
+	defaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// namespace of the pod, mounted along the token
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	// address of the TSB front envoy in the management cluster, by its namespace
+	inClusterServerFormat = "envoy.%s.svc.cluster.local:8443"
+)
+
+// Returns whether the tool runs in a Kubernetes pod
+func inKubernetesPod() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// Returns the address of TSB in the management cluster the pod runs in: the front envoy in the namespace, or the
+// namespace of the pod if empty
+func inClusterServer(namespace string) (string, error) {
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to find the namespace of the pod, set --tsb-namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	return fmt.Sprintf(inClusterServerFormat, namespace), nil
+}
+
+// Reads the token to call TSB with from the file. Projected service account tokens are rotated by the kubelet, so
+// it is read again for every request rather than once.
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token file %q: %w", path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	addSecret(token)
+	return token, nil
+}
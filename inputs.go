@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdinInput is the input path that reads the standard input
+const stdinInput = "-"
+
+var (
+	// bearer token sent to fetch the inputs given as URLs, from --input-token
+	inputToken string
+	// the input reading stdin, as it can only be read once
+	stdinMu   sync.Mutex
+	stdinUser string
+
+	// client fetching the inputs given as URLs, so an unresponsive config service fails the run instead of hanging it
+	inputClient = &http.Client{Timeout: time.Minute}
+)
+
+// Reads an input file: the standard input for "-", an http(s) URL, or a local file otherwise. URLs are fetched with
+// the basic credentials in their user info if any, or the --input-token as bearer token, so inputs can come from
+// internal config services. The flag names the input in errors and in the stdin check, as only one input can read it.
+func readInput(flag, path string) ([]byte, error) {
+	if path == stdinInput {
+		stdinMu.Lock()
+		defer stdinMu.Unlock()
+		if stdinUser != "" && stdinUser != flag {
+			return nil, fmt.Errorf("%s and %s can't both read the standard input", stdinUser, flag)
+		}
+		stdinUser = flag
+		return io.ReadAll(os.Stdin)
+	}
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return os.ReadFile(path)
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	user := u.User
+	u.User = nil
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if password, ok := user.Password(); ok {
+		req.SetBasicAuth(user.Username(), password)
+	} else if inputToken != "" {
+		req.Header.Set("Authorization", "Bearer "+inputToken)
+	}
+	resp, err := inputClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %d", u.Redacted(), resp.StatusCode)
+	}
+	debug("fetched %s for %s", u.Redacted(), flag)
+	return io.ReadAll(resp.Body)
+}
+
+// Returns the input path to print in messages: URLs without the password of their user info
+func inputName(path string) string {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
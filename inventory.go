@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// The services a namespace was observed calling
+type NamespaceInventory struct {
+	Namespace    string        `json:"namespace"`
+	Dependencies []*Dependency `json:"dependencies"`
+}
+
+// A service a namespace depends on
+type Dependency struct {
+	Service string `json:"service"`
+	// Namespaces the service was called in
+	Namespaces []string `json:"namespaces"`
+	// Components (protocols) the calls were detected with
+	Components []string `json:"components,omitempty"`
+	// Services of the namespace calling it
+	Callers []string `json:"callers"`
+}
+
+// The document the inventory subcommand prints
+type Inventory struct {
+	Window     Window                `json:"window"`
+	Namespaces []*NamespaceInventory `json:"namespaces"`
+}
+
+func newInventoryCmd(runtime *Runtime) *cobra.Command {
+	return &cobra.Command{
+		Use:   "inventory",
+		Short: "Print a JSON catalog of the services each namespace was observed calling",
+		Long: `Print a JSON catalog of the services each namespace was observed calling.
+
+Unlike the generated policies, the inventory lists every destination service rather than namespace, and includes
+the calls from services without a traffic group, so it can serve as a service dependency catalog.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			return writeInventory(cmd.OutOrStdout(), inventory(runtime, graph))
+		},
+	}
+}
+
+// Returns the dependencies of each namespace in the graph, sorted by namespace and service
+func inventory(runtime *Runtime, graph *Graph) *Inventory {
+	// namespace => service FQN => dependency
+	deps := make(map[string]map[string]*Dependency)
+	for _, call := range graph.Calls {
+		if call.Mirrored || call.EastWest {
+			// not observed
+			continue
+		}
+		for _, ns := range call.SourceNamespaces {
+			if deps[ns] == nil {
+				deps[ns] = make(map[string]*Dependency)
+			}
+			dep, ok := deps[ns][call.TargetService.FQN]
+			if !ok {
+				dep = &Dependency{Service: call.TargetService.FQN}
+				deps[ns][call.TargetService.FQN] = dep
+			}
+			dep.Namespaces = mergeSorted(dep.Namespaces, call.TargetNamespaces)
+			dep.Components = mergeSorted(dep.Components, call.Components)
+			dep.Callers = mergeSorted(dep.Callers, []string{call.SourceService.FQN})
+		}
+	}
+
+	inv := &Inventory{Window: Window{Start: runtime.start, End: runtime.end}, Namespaces: make([]*NamespaceInventory, 0, len(deps))}
+	namespaces := maps.Keys(deps)
+	slices.Sort(namespaces)
+	for _, ns := range namespaces {
+		services := maps.Keys(deps[ns])
+		slices.Sort(services)
+		nsInv := &NamespaceInventory{Namespace: ns, Dependencies: make([]*Dependency, 0, len(services))}
+		for _, svc := range services {
+			nsInv.Dependencies = append(nsInv.Dependencies, deps[ns][svc])
+		}
+		inv.Namespaces = append(inv.Namespaces, nsInv)
+	}
+	return inv
+}
+
+func writeInventory(out io.Writer, inv *Inventory) error {
+	data, err := json.MarshalIndent(inv, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %w", err)
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
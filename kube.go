@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"sigs.k8s.io/yaml"
+)
+
+// --sidecar-output forms of the Sidecars in the output
+const (
+	// wrapped in TSB objects, for tctl
+	sidecarOutputTctl = "tctl"
+	// as plain Kubernetes manifests, for kubectl
+	sidecarOutputK8s = "k8s"
+)
+
+// Runs kubectl against the configured cluster
+type Kubectl struct {
+	path    string
+	context string
+}
+
+func NewKubectl(cfg *Config) *Kubectl {
+	return &Kubectl{path: cfg.kubectl, context: cfg.kubeContext}
+}
+
+// Runs kubectl with the arguments, feeding it stdin if not nil, and returns its output
+func (k *Kubectl) run(stdin []byte, args ...string) ([]byte, error) {
+	out, _, err := k.exec(stdin, args...)
+	return out, err
+}
+
+// Runs kubectl diff on the manifest, returning the diff of the live resource against it; empty if there is none
+func (k *Kubectl) diff(manifest []byte) ([]byte, error) {
+	out, code, err := k.exec(manifest, "diff", "-f", "-")
+	// kubectl diff exits with 1 when there are differences, and above on errors
+	if code == 1 {
+		return out, nil
+	}
+	return out, err
+}
+
+// Runs kubectl, returning its output and exit code
+func (k *Kubectl) exec(stdin []byte, args ...string) ([]byte, int, error) {
+	if k.context != "" {
+		args = append([]string{"--context", k.context}, args...)
+	}
+	debug("running %s %s", k.path, strings.Join(args, " "))
+
+	cmd := exec.Command(k.path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		code := -1
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		return out, code, fmt.Errorf("%s %s: %w: %s", k.path, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, 0, nil
+}
+
+// Annotations TSB uses in Kubernetes manifests to know the hierarchy a resource belongs to
+var tsbHierarchyAnnotations = []struct {
+	key   string
+	value func(*typesv2.ObjectMeta) string
+}{
+	{"tsb.tetrate.io/organization", (*typesv2.ObjectMeta).GetOrganization},
+	{"tsb.tetrate.io/tenant", (*typesv2.ObjectMeta).GetTenant},
+	{"tsb.tetrate.io/workspace", (*typesv2.ObjectMeta).GetWorkspace},
+	{"tsb.tetrate.io/trafficGroup", (*typesv2.ObjectMeta).GetGroup},
+}
+
+// Converts a generated object into the Kubernetes manifest of the resource, as TSB GitOps expects it: the TSB
+// hierarchy goes in annotations, and TSB resources without a namespace go in the given one.
+func toKubernetesManifest(obj *typesv2.Object, namespace string) (map[string]any, error) {
+	spec, err := obj.GetSpec().UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack the spec of %s %s: %w", obj.GetKind(), objectName(obj), err)
+	}
+	js, err := protojson.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the spec of %s %s: %w", obj.GetKind(), objectName(obj), err)
+	}
+	specMap := make(map[string]any)
+	if err := json.Unmarshal(js, &specMap); err != nil {
+		return nil, fmt.Errorf("failed to convert the spec of %s %s: %w", obj.GetKind(), objectName(obj), err)
+	}
+	// TSB identifies resources in Kubernetes from their metadata
+	delete(specMap, "fqn")
+	delete(specMap, "etag")
+
+	meta := obj.GetMetadata()
+	annotations := make(map[string]any)
+	for k, v := range meta.GetAnnotations() {
+		annotations[k] = v
+	}
+	for _, a := range tsbHierarchyAnnotations {
+		if v := a.value(meta); v != "" {
+			annotations[a.key] = v
+		}
+	}
+
+	name := meta.GetName()
+	if name == "" {
+		name = "default"
+	}
+	if meta.GetNamespace() != "" {
+		namespace = meta.GetNamespace()
+	}
+	metadata := map[string]any{
+		"name":      name,
+		"namespace": namespace,
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if len(meta.GetLabels()) > 0 {
+		metadata["labels"] = meta.GetLabels()
+	}
+
+	return map[string]any{
+		"apiVersion": obj.GetApiVersion(),
+		"kind":       obj.GetKind(),
+		"metadata":   metadata,
+		"spec":       specMap,
+	}, nil
+}
+
+// Returns the Kubernetes manifest of the object as YAML
+func kubernetesYAML(obj *typesv2.Object, namespace string) ([]byte, error) {
+	return kubernetesYAMLAt(obj, namespace, "")
+}
+
+// Like kubernetesYAML, but with the resourceVersion the live resource must have for the API server to accept it
+func kubernetesYAMLAt(obj *typesv2.Object, namespace, resourceVersion string) ([]byte, error) {
+	manifest, err := toKubernetesManifest(obj, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if resourceVersion != "" {
+		manifest["metadata"].(map[string]any)["resourceVersion"] = resourceVersion
+	}
+	return yaml.Marshal(manifest)
+}
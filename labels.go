@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Prefix of the labels and annotations the tool manages itself, like the revisionLabel status finds the generated
+// objects by, so they can't be set with --common-labels or --common-annotations
+const reservedKeyPrefix = "generate-sidecar-tool/"
+
+// A --common-labels or --common-annotations value, templated with the commonMetadata of each object
+type commonValue struct {
+	key  string
+	tmpl *template.Template
+}
+
+// The values the --common-labels and --common-annotations templates can use
+type commonMetadata struct {
+	Organization string
+	Tenant       string
+	Workspace    string
+	Group        string
+	Namespace    string
+	Kind         string
+	Name         string
+}
+
+// Parses the --common-labels or --common-annotations of the flag as templates, refusing keys that aren't valid
+// Kubernetes label and annotation keys or that are reserved to the tool
+func parseCommonValues(flag string, values map[string]string) ([]commonValue, error) {
+	var parsed []commonValue
+	for k, v := range values {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s key %q: %s", flag, k, strings.Join(errs, "; "))
+		}
+		if strings.HasPrefix(k, reservedKeyPrefix) {
+			return nil, fmt.Errorf("invalid %s key %q: the %s keys are managed by the tool", flag, k, reservedKeyPrefix)
+		}
+		tmpl, err := template.New(k).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s=%q: %w", flag, k, v, err)
+		}
+		parsed = append(parsed, commonValue{key: k, tmpl: tmpl})
+	}
+	return parsed, nil
+}
+
+// Returns the TSB hierarchy of the object: its own for TSB resources, or the one of the group of DIRECT Sidecars,
+// which carry it in annotations
+func objectHierarchy(obj *typesv2.Object) commonMetadata {
+	meta := obj.GetMetadata()
+	data := commonMetadata{
+		Organization: meta.GetOrganization(),
+		Tenant:       meta.GetTenant(),
+		Workspace:    meta.GetWorkspace(),
+		Group:        meta.GetGroup(),
+		Namespace:    meta.GetNamespace(),
+		Kind:         obj.GetKind(),
+		Name:         meta.GetName(),
+	}
+	fields := []*string{&data.Organization, &data.Tenant, &data.Workspace, &data.Group}
+	for i, a := range tsbHierarchyAnnotations {
+		if *fields[i] == "" {
+			*fields[i] = meta.GetAnnotations()[a.key]
+		}
+	}
+	return data
+}
+
+// Sets the --common-labels and --common-annotations on every generated object. The rendered label values must be
+// valid Kubernetes label values.
+func applyCommonMetadata(labels, annotations []commonValue, results []*typesv2.Object) error {
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+	render := func(values []commonValue, data commonMetadata, into map[string]string, label bool) error {
+		for _, v := range values {
+			var buf bytes.Buffer
+			if err := v.tmpl.Execute(&buf, data); err != nil {
+				return fmt.Errorf("failed to render %s of %s %s: %w", v.key, data.Kind, data.Name, err)
+			}
+			if errs := validation.IsValidLabelValue(buf.String()); label && len(errs) > 0 {
+				return fmt.Errorf("invalid value %q of label %s of %s %s: %s", buf.String(), v.key, data.Kind, data.Name, strings.Join(errs, "; "))
+			}
+			into[v.key] = buf.String()
+		}
+		return nil
+	}
+	for _, obj := range results {
+		if obj.Metadata == nil {
+			obj.Metadata = &typesv2.ObjectMeta{}
+		}
+		data := objectHierarchy(obj)
+		if len(labels) > 0 && obj.Metadata.Labels == nil {
+			obj.Metadata.Labels = make(map[string]string)
+		}
+		if err := render(labels, data, obj.Metadata.Labels, true); err != nil {
+			return err
+		}
+		if len(annotations) > 0 && obj.Metadata.Annotations == nil {
+			obj.Metadata.Annotations = make(map[string]string)
+		}
+		if err := render(annotations, data, obj.Metadata.Annotations, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/slices"
+	"istio.io/api/networking/v1beta1"
+)
+
+const (
+	lintOff   = "off"
+	lintWarn  = "warn"
+	lintError = "error"
+
+	// Istio's root namespace, where a Sidecar without selector applies to the whole mesh
+	istioRootNamespace = "istio-system"
+)
+
+// Returns the hosts allowed by a generated Sidecar or TrafficSetting; other kinds have none
+func objectHosts(obj *typesv2.Object) ([]string, error) {
+	spec, err := obj.GetSpec().UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the spec of %s %s: %w", obj.GetKind(), objectName(obj), err)
+	}
+	switch s := spec.(type) {
+	case *v1beta1.Sidecar:
+		var hosts []string
+		for _, e := range s.GetEgress() {
+			hosts = append(hosts, e.GetHosts()...)
+		}
+		return hosts, nil
+	case *trafficv2.TrafficSetting:
+		return s.GetReachability().GetHosts(), nil
+	default:
+		return nil, nil
+	}
+}
+
+// Flags anti-patterns in the generated resources as warnings. With --lint=error, any finding also fails the run.
+func lint(runtime *Runtime, graph *Graph, results []*typesv2.Object) error {
+	if runtime.lint == lintOff {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for i := range graph.Services {
+		for _, ns := range parseNamespace(&graph.Services[i]) {
+			known[ns] = true
+		}
+	}
+	for _, host := range baselineHosts {
+		known[hostNamespace(host)] = true
+	}
+
+	var findings []string
+	for _, obj := range results {
+		hosts, err := objectHosts(obj)
+		if err != nil {
+			return err
+		}
+		for _, f := range lintObject(obj, hosts, known) {
+			findings = append(findings, fmt.Sprintf("%s %s: %s", obj.GetKind(), objectName(obj), f))
+		}
+	}
+
+	for _, f := range findings {
+		diagnose(diagLint, f)
+	}
+	if runtime.lint == lintError && len(findings) > 0 {
+		return fmt.Errorf("generated resources have %d lint finding(s), see the %s warnings", len(findings), diagLint)
+	}
+	return nil
+}
+
+// Returns the findings for a single resource, given the hosts it allows and the namespaces known to TSB
+func lintObject(obj *typesv2.Object, hosts []string, known map[string]bool) []string {
+	var findings []string
+	if obj.GetKind() == api.IstioSidecarKind && obj.GetMetadata().GetNamespace() == istioRootNamespace {
+		findings = append(findings, fmt.Sprintf("Sidecar in %s applies to the whole mesh", istioRootNamespace))
+	}
+
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] {
+			findings = append(findings, fmt.Sprintf("host %q is listed more than once", host))
+			continue
+		}
+		seen[host] = true
+
+		ns, name, _ := strings.Cut(host, "/")
+		switch {
+		case ns == "*" && name == "*":
+			findings = append(findings, fmt.Sprintf("host %q allows every host in the mesh", host))
+		case ns != "*" && ns != "." && ns != "~" && !known[ns]:
+			findings = append(findings, fmt.Sprintf("host %q references namespace %q, which has no service in TSB", host, ns))
+		}
+		if name != "*" && slices.Contains(hosts, ns+"/*") {
+			findings = append(findings, fmt.Sprintf("host %q is redundant with %q", host, ns+"/*"))
+		}
+	}
+	return findings
+}
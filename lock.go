@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	lockFile  = "file"
+	lockLease = "lease"
+)
+
+// Who holds a lock and since when; it's what the lock file holds
+type lockHolder struct {
+	Identity string    `json:"identity"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// Returns the identity of this run for the lock, as host and process
+func lockIdentity() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// Takes the --lock, so that concurrent generation and apply runs don't interleave their writes. Returns the function
+// releasing it. Locks older than --lock-ttl are considered left behind by a crashed run, and taken over.
+func acquireLock(runtime *Runtime) (func(), error) {
+	if runtime.lock == "" {
+		return func() {}, nil
+	}
+	kind, target, _ := strings.Cut(runtime.lock, ":")
+	switch kind {
+	case lockFile:
+		return acquireFileLock(target, runtime.lockTTL)
+	case lockLease:
+		if runtime.dryRun != dryRunNone {
+			// a dry run doesn't change the cluster, not even to lock it
+			debug("not taking lease %s in a dry run", target)
+			return func() {}, nil
+		}
+		return acquireLeaseLock(runtime.kubectl, target, runtime.lockTTL)
+	default:
+		return nil, fmt.Errorf("invalid --lock %q, must be %s:<path> or %s:<namespace>/<name>", runtime.lock, lockFile, lockLease)
+	}
+}
+
+// Locks by creating the file exclusively
+func acquireFileLock(path string, ttl time.Duration) (func(), error) {
+	holder, err := json.Marshal(lockHolder{Identity: lockIdentity(), Acquired: time.Now()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock: %w", err)
+	}
+
+	release := func() { releaseFileLock(path, holder, ttl) }
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = f.Write(holder)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %q: %w", path, err)
+			}
+			debug("took lock file %q", path)
+			return release, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file %q: %w", path, err)
+		}
+
+		current := lockHolder{}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &current)
+		}
+		if err != nil || time.Since(current.Acquired) < ttl {
+			return nil, fmt.Errorf("another run holds lock file %q (%s since %s); remove it if that run is gone",
+				path, current.Identity, current.Acquired.Format(time.RFC3339))
+		}
+		taken, err := takeOverFileLock(path, data, holder, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			warn("took over lock file %q, left behind by %s at %s", path, current.Identity, current.Acquired.Format(time.RFC3339))
+			return release, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to take lock file %q, another run keeps taking it", path)
+}
+
+// Replaces the stale lock file with the holder, if it still has the stale content. Only the run holding the takeover
+// guard does, so two runs finding the same stale lock can't both take it, and the lock file is replaced with a rename,
+// so it's never missing for another run to create.
+func takeOverFileLock(path string, stale, holder []byte, ttl time.Duration) (bool, error) {
+	unguard, ok, err := guardFileLock(path, ttl)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer unguard()
+
+	// released or taken over since it was read
+	if current, err := os.ReadFile(path); err != nil || !bytes.Equal(current, stale) {
+		return false, nil
+	}
+	tmp := path + ".takeover.tmp"
+	if err := os.WriteFile(tmp, holder, 0o644); err != nil {
+		return false, fmt.Errorf("failed to write lock file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to take over lock file %q: %w", path, err)
+	}
+	return true, nil
+}
+
+// Removes the lock file if it still has the holder. A run outliving the --lock-ttl may have had it taken over, and
+// the lock is then the other run's. The takeover guard is held meanwhile, so the lock can't be taken over between
+// checking and removing it; if another run holds the guard, it's taking over the lock, and it's left to it.
+func releaseFileLock(path string, holder []byte, ttl time.Duration) {
+	unguard, ok, err := guardFileLock(path, ttl)
+	if err != nil {
+		warn("failed to release lock file %q: %v", path, err)
+		return
+	}
+	if !ok {
+		warn("not releasing lock file %q, another run is taking it over", path)
+		return
+	}
+	defer unguard()
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		warn("failed to release lock file %q: %v", path, err)
+		return
+	}
+	if !bytes.Equal(current, holder) {
+		warn("not releasing lock file %q, another run took it over", path)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		warn("failed to release lock file %q: %v", path, err)
+	}
+}
+
+// Takes the takeover guard of the lock file by creating its .takeover file exclusively, returning the function
+// releasing it, or false if another run holds it. A guard older than the TTL was left behind by a run that crashed
+// holding it, and is removed first, like a stale lock is taken over.
+func guardFileLock(path string, ttl time.Duration) (func(), bool, error) {
+	guard := path + ".takeover"
+	if err := expireGuard(guard, ttl); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		debug("another run holds the takeover guard of lock file %q", path)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create %q: %w", guard, err)
+	}
+	f.Close()
+	return func() { os.Remove(guard) }, true, nil
+}
+
+// Removes the takeover guard if it's older than the TTL. It's moved aside first, and put back if it turns out to be
+// the new guard of another run that removed the stale one already, so two runs finding the same stale guard can't
+// both take it.
+func expireGuard(guard string, ttl time.Duration) error {
+	stale, err := os.Stat(guard)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && time.Since(stale.ModTime()) < ttl) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %q: %w", guard, err)
+	}
+	aside := fmt.Sprintf("%s.%d.%d", guard, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(guard, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// another run removed it first
+			return nil
+		}
+		return fmt.Errorf("failed to remove stale %q: %w", guard, err)
+	}
+	defer os.Remove(aside)
+	if moved, err := os.Stat(aside); err == nil && !os.SameFile(stale, moved) {
+		if err := os.Link(aside, guard); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to restore %q: %w", guard, err)
+		}
+		return nil
+	}
+	warn("removed %q, left behind at %s by a run that crashed taking over the lock", guard, stale.ModTime().Format(time.RFC3339))
+	return nil
+}
+
+// Locks by creating a coordination.k8s.io Lease in the cluster, which fails if it exists already
+func acquireLeaseLock(kubectl *Kubectl, target string, ttl time.Duration) (func(), error) {
+	namespace, name, ok := strings.Cut(target, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid --lock lease %q, must be <namespace>/<name>", target)
+	}
+	manifest, err := leaseManifest(namespace, name, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+	release := func() {
+		if _, err := kubectl.run(nil, "delete", "lease", "-n", namespace, name, "--ignore-not-found"); err != nil {
+			warn("failed to release lease %s/%s: %v", namespace, name, err)
+		}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := kubectl.run(manifest, "create", "-f", "-"); err == nil {
+			debug("took lease %s/%s", namespace, name)
+			return release, nil
+		}
+
+		out, err := kubectl.run(nil, "get", "lease", "-n", namespace, name, "-o", "json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lease %s/%s: %w", namespace, name, err)
+		}
+		var lease struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+			Spec struct {
+				HolderIdentity string    `json:"holderIdentity"`
+				AcquireTime    time.Time `json:"acquireTime"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(out, &lease); err != nil {
+			return nil, fmt.Errorf("failed to parse lease %s/%s: %w", namespace, name, err)
+		}
+		if time.Since(lease.Spec.AcquireTime) < ttl {
+			return nil, fmt.Errorf("another run holds lease %s/%s (%s since %s)",
+				namespace, name, lease.Spec.HolderIdentity, lease.Spec.AcquireTime.Format(time.RFC3339))
+		}
+		// replaced conditionally on its resourceVersion, so only one of the runs finding it stale takes it over
+		takeover, err := leaseManifest(namespace, name, ttl, lease.Metadata.ResourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := kubectl.run(takeover, "replace", "-f", "-"); err != nil {
+			if isConflict(err) {
+				debug("another run took over lease %s/%s first", namespace, name)
+				continue
+			}
+			return nil, fmt.Errorf("failed to take over stale lease %s/%s: %w", namespace, name, err)
+		}
+		warn("took over lease %s/%s, left behind by %s at %s", namespace, name, lease.Spec.HolderIdentity, lease.Spec.AcquireTime.Format(time.RFC3339))
+		return release, nil
+	}
+	return nil, fmt.Errorf("failed to take lease %s/%s, another run keeps taking it", namespace, name)
+}
+
+// Returns the manifest of the Lease held by this run, at the resourceVersion to replace if any
+func leaseManifest(namespace, name string, ttl time.Duration, resourceVersion string) ([]byte, error) {
+	metadata := map[string]any{"name": name, "namespace": namespace}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	manifest, err := yaml.Marshal(map[string]any{
+		"apiVersion": "coordination.k8s.io/v1",
+		"kind":       "Lease",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"holderIdentity":       lockIdentity(),
+			"leaseDurationSeconds": int(ttl.Seconds()),
+			"acquireTime":          time.Now().UTC().Format("2006-01-02T15:04:05.000000Z"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lease: %w", err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Writes a lock file held by another run since the time
+func writeTestLock(t *testing.T, path string, acquired time.Time) []byte {
+	t.Helper()
+	data, err := json.Marshal(lockHolder{Identity: "other-host/1", Acquired: acquired})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	release, err := acquireFileLock(path, time.Hour)
+	if err != nil {
+		t.Fatalf("acquireFileLock() error = %v", err)
+	}
+	if _, err := acquireFileLock(path, time.Hour); err == nil {
+		t.Fatalf("acquireFileLock() took a held lock")
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("release() left the lock file behind: %v", err)
+	}
+	release, err = acquireFileLock(path, time.Hour)
+	if err != nil {
+		t.Fatalf("acquireFileLock() error = %v for a released lock", err)
+	}
+	release()
+}
+
+func TestFileLockTakeOver(t *testing.T) {
+	tests := []struct {
+		name     string
+		acquired time.Duration
+		// age of a takeover guard left behind, if any
+		guard    time.Duration
+		wantTake bool
+	}{
+		{name: "held", acquired: time.Minute},
+		{name: "stale", acquired: 2 * time.Hour, wantTake: true},
+		{name: "stale with a takeover in progress", acquired: 2 * time.Hour, guard: time.Second},
+		{name: "stale with a stale guard", acquired: 2 * time.Hour, guard: 2 * time.Hour, wantTake: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "lock")
+			writeTestLock(t, path, time.Now().Add(-tt.acquired))
+			if tt.guard > 0 {
+				guard := path + ".takeover"
+				if err := os.WriteFile(guard, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				modified := time.Now().Add(-tt.guard)
+				if err := os.Chtimes(guard, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+			release, err := acquireFileLock(path, time.Hour)
+			if (err == nil) != tt.wantTake {
+				t.Fatalf("acquireFileLock() error = %v, want the lock taken: %v", err, tt.wantTake)
+			}
+			if err != nil {
+				return
+			}
+			release()
+			if _, err := os.Stat(path + ".takeover"); !os.IsNotExist(err) {
+				t.Errorf("acquireFileLock() left the takeover guard behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileLockConcurrentTakeOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	writeTestLock(t, path, time.Now().Add(-2*time.Hour))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	taken := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := acquireFileLock(path, time.Hour); err == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 1 {
+		t.Errorf("%d runs took over the same stale lock, want 1", taken)
+	}
+}
+
+func TestFileLockReleaseAfterTakeOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	release, err := acquireFileLock(path, time.Hour)
+	if err != nil {
+		t.Fatalf("acquireFileLock() error = %v", err)
+	}
+	// this run outlived the TTL, and another one took the lock over
+	other := writeTestLock(t, path, time.Now())
+	release()
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != string(other) {
+		t.Errorf("release() removed the lock of the run that took it over: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Where all the diagnostics go: debug logs, warnings and explanations. It is never stdout, which only carries the
+// generated resources so it can be piped to kubectl or tctl.
+var logOut io.Writer = os.Stderr
+
+// Prints a debug log. It's a no-op until logging is set up with --debug, so the config file and tctl profile, read
+// before that, don't log regardless of --quiet and --log-file.
+var debug = func(format string, a ...any) {}
+
+func printDebug(format string, a ...any) { fmt.Fprintf(logOut, format+"\n", a...) }
+
+// Prints a warning about something the user should know about the output, like skipped services
+var warn = func(format string, a ...any) { fmt.Fprintf(logOut, format+"\n", a...) }
+
+// Sends the diagnostics to the log file if given, redacting the credentials in them, and disables warnings in quiet
+// mode
+func setupLogging(file string, quiet bool) error {
+	if file != "" {
+		// the file is not buffered, so there is nothing to flush and it's closed on exit
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open --log-file %q: %w", file, err)
+		}
+		logOut = f
+	}
+	logOut = &redactingWriter{w: logOut}
+	if quiet {
+		warn = func(format string, a ...any) {}
+	}
+	return nil
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -21,14 +27,180 @@ import (
 
 const DATE_FORMAT = "2006-01-02"
 
+// Values of --aggregate-by
+const (
+	aggregateByGroup     = "group"
+	aggregateByNamespace = "namespace"
+)
+
+// Hosts every generated Sidecar and TrafficSetting can reach regardless of the observed topology
+var baselineHosts = []string{"istio-system/*", "xcp-multicluster/*"}
+
 type Config struct {
 	username string
 	password string
-	server   string
+	token    string
+	servers  []string
 	org      string
+	autoOrg  string
 	start    time.Time
 	end      time.Time
 	insecure bool
+	caBundle []byte // PEM certificates to trust when calling TSB, besides the system ones
+	// sent with every request to TSB, e.g. for a proxy in front of it
+	headers http.Header
+
+	// with --in-cluster, the file to read the token from for each request and the namespace TSB runs in
+	inCluster    bool
+	tokenFile    string
+	tsbNamespace string
+
+	// with a login endpoint, the credentials are only sent to it and the session cookie it sets to the other requests
+	loginPath string
+
+	// tenant, and workspace in it, to limit the services listed to
+	tenant    string
+	workspace string
+
+	// TSB API version to use, or "auto"
+	apiVersion string
+	// step of the GraphQL durations and timeout of each OAP query
+	step       string
+	oapTimeout time.Duration
+	multiStep  bool
+	// whether decoding TrafficSettings fails on unknown fields
+	strictDecoding bool
+
+	scopeFrom      string
+	namespaceRules string
+	// with --owner, where the owners of the namespaces come from
+	owner          string
+	ownersFile     string
+	ownerLabel     string
+	exclusionsFile string
+	extraEdges     string
+	mappingFile    string
+	graphOut       string
+	graphIn        string
+	nameStyle      string
+	onDuplicateKey string
+
+	suggestMappings   bool
+	autoMapConfidence float64
+
+	cache    bool
+	noCache  bool
+	refresh  []string
+	cacheDir string
+	cacheTTL time.Duration
+
+	policyCheckDir  string
+	policyCheckMode string
+	lint            string
+	maxChanges      int
+	emitters        []string
+	// fewest resources and source namespaces a run may generate for
+	expectMinResources  int
+	expectMinNamespaces int
+
+	excludeErrorOnly bool
+	minSuccessRate   float64
+
+	timeOfDay        bool
+	businessHours    string
+	businessTimezone string
+
+	outputTemplate string
+	jsonPath       string
+	// directory to write the resources to in chunks instead of stdout, with the limits of each chunk
+	outputDir      string
+	maxDocsPerFile int
+	maxFileSize    int
+	sidecarOutput  string
+
+	signKey       string
+	signatureFile string
+
+	push          string
+	pushPlainHTTP bool
+
+	schedule             string
+	windowSinceLastRun   bool
+	reportedWarningsFile string
+	lock                 string
+	lockTTL              time.Duration
+	watchChanges         time.Duration
+
+	summaryFile string
+
+	budgets          map[string]string
+	onBudgetExceeded string
+
+	stateFile       string
+	allowShrink     bool
+	shrinkThreshold float64
+
+	assumeBidirectional     bool
+	bidirectionalNamespaces []string
+	bidirectionalComponents []string
+
+	attributeByDeployment bool
+	clustersFrom          string
+	onUnknownMode         string
+
+	allowCrossTrustDomain bool
+
+	requireCrossTrustDomainOptIn bool
+
+	layers    []string
+	allLayers bool
+	// SkyWalking node types to only consider, or not to consider, nodes of
+	includeNodeTypes []string
+	excludeNodeTypes []string
+
+	explain     []string
+	explainFile string
+	logFile     string
+	eventsFile  string
+	quiet       bool
+
+	dumpDir           string
+	debugJSONMaxBytes int
+
+	createGroups          bool
+	createGroupsTenant    string
+	createGroupsWorkspace string
+
+	destinationOnlySidecars bool
+
+	ambientNamespaces []string
+	detectAmbient     bool
+	ambientMode       string
+	ambientWaypoints  map[string]string
+	sidecarQuirks     map[string]string
+
+	commonLabels      map[string]string
+	commonAnnotations map[string]string
+
+	tlsOrigination map[string]string
+
+	eastWestNamespace string
+	eastWestRemote    bool
+	includeFailover   bool
+
+	aggregateBy     string
+	splitSettingsBy string
+	revision        string
+	// with --effective-settings, only the TrafficSettings adding hosts to the inherited ones are generated
+	effectiveSettings bool
+
+	apply           bool
+	applyMaxRisk    string
+	prune           bool
+	dryRun          string
+	kubectl         string
+	kubeContext     string
+	gitopsNamespace string
 
 	debug   bool
 	verbose bool
@@ -38,12 +210,34 @@ type APIClient interface {
 	// Returns the service topology from skywalking, which needs to be normalized to services in
 	// TSB via the 'aggregated metrics' names in each TSB Service.
 	GetTopology(start, end time.Time) (*TopologyResponse, error)
+	// Returns the topology of the calls from and to the given skywalking services only
+	GetServicesTopology(serviceIDs []string, start, end time.Time) (*TopologyResponse, error)
+	// Returns the success rate (between 0 and 1) of the calls from the source to the target service of the topology,
+	// or false if there is no traffic between them
+	GetCallSuccessRate(source, target string, start, end time.Time) (float64, bool, error)
+	// Returns the calls per minute from the source to the target service of the topology in the window
+	GetCallThroughput(source, target string, start, end time.Time) (int64, error)
+	// Returns the calls per minute from the source to the target service of the topology in each hour of the window
+	GetCallHourlyThroughput(source, target string, start, end time.Time) ([]int64, error)
 	// Calls TSB's ListServices endpoint
 	GetServices() ([]Service, error)
+	// Calls TSB's ListOrganizations endpoint, returning the names of the organizations visible to the credentials
+	ListOrganizations() ([]string, error)
 	// Returns the traffic group that matches the provided service
 	LookupTrafficGroup(service *Service) (*TrafficGroup, error) // TODO: multi-error
+	// Returns the traffic group with the given FQN
+	GetTrafficGroup(groupFQN string) (*trafficv2.Group, error)
 	// Returns the TrafficSetting for the provided group FQN
 	GetTrafficSettings(groupFQN string) (*trafficv2.TrafficSetting, error)
+	// Returns the reachability the group inherits from the default traffic settings of its workspace, tenant or
+	// organization, the closest one setting it, or nil if none does
+	GetDefaultReachability(groupFQN string) (*trafficv2.ReachabilitySettings, error)
+	// Returns whether the workspace with the given FQN exists
+	WorkspaceExists(workspaceFQN string) (bool, error)
+	// Returns the changes to TSB resources made after the given time, from TSB's audit log
+	ListChanges(since time.Time) ([]Change, error)
+	// Sends the requests with the context until the returned function is called, so canceling it stops them
+	BindContext(ctx context.Context) func()
 }
 
 type Runtime struct {
@@ -51,20 +245,161 @@ type Runtime struct {
 	end    time.Time
 	server string
 
+	policyCheckDir  string
+	policyCheckMode string
+	lint            string
+	maxChanges      int
+	emitters        []string
+	// fewest resources and source namespaces a run may generate for
+	expectMinResources  int
+	expectMinNamespaces int
+
+	excludeErrorOnly bool
+	minSuccessRate   float64
+
+	// --time-of-day, with the --business-hours in their time zone
+	timeOfDay        bool
+	businessHours    [2]int
+	businessLocation *time.Location
+
+	outputTemplate string
+	jsonPath       string
+	// directory to write the resources to in chunks instead of stdout, with the limits of each chunk
+	outputDir      string
+	maxDocsPerFile int
+	maxFileSize    int
+	sidecarOutput  string
+
+	signKey       ed25519.PrivateKey
+	signatureFile string
+
+	// OCI reference to push the resources and report to, if any
+	push          *ociReference
+	pushPlainHTTP bool
+
+	// with --schedule, runs as a daemon generating on each tick
+	schedule             *cronSchedule
+	windowSinceLastRun   bool
+	reportedWarningsFile string
+	lock                 string
+	lockTTL              time.Duration
+	// interval to poll TSB's audit log at in daemon mode, with --watch-changes
+	watchChanges time.Duration
+	// in a selective regeneration, the workspaces and groups whose membership changed; nil in full runs
+	changed []string
+
+	summaryFile string
+	// how long the whole run and each of its phases may take, and what to do when one takes longer
+	budgets          map[string]time.Duration
+	onBudgetExceeded string
+	// namespaces and service FQNs the run is scoped to, from --scope-from
+	scope          []string
+	namespaceRules []*NamespaceRule
+	// reason of each namespace of the --exclusions-file
+	exclusions map[string]string
+	// owner the run is scoped to the namespaces of, and the owner of each namespace
+	owner  string
+	owners map[string]string
+	// dependencies declared in --extra-edges, added to the observed ones
+	extraEdges []ExtraEdge
+
+	// whether --tenant limits the services listed, so only their topology needs to be queried
+	tenantScoped bool
+	// files to write the graph built from TSB to, and to read it from instead of TSB
+	graphOut string
+	graphIn  string
+	// how services are named in human-facing output
+	nameStyle string
+	// what to do when services share an aggregation key, see duplicateKeyStrategies
+	onDuplicateKey string
+	// topology node name => service FQN, from --mapping-file
+	serviceMappings map[string]string
+	// propose services for the nodes without any, and accept the proposals at least this confident if non-zero
+	suggestMappings   bool
+	autoMapConfidence float64
+
+	stateFile       string
+	allowShrink     bool
+	shrinkThreshold float64
+
+	assumeBidirectional     bool
+	bidirectionalNamespaces []string
+	bidirectionalComponents []string
+
+	attributeByDeployment bool
+	// where the clusters of the calls come from, the service deployments or the topology nodes
+	clustersFrom string
+	// what to do with the calls from traffic groups in unknown config modes
+	onUnknownMode string
+	// whether to generate for the calls between services with no trust domain in common
+	allowCrossTrustDomain bool
+	// whether those calls are dropped without allowCrossTrustDomain, rather than only annotated
+	requireCrossTrustDomainOptIn bool
+
+	// topology layers to consider nodes of; empty for every layer
+	layers []string
+	// SkyWalking node types to only consider nodes of, if any, and node types not to consider
+	includeNodeTypes []string
+	excludeNodeTypes []string
+
+	createGroups          bool
+	createGroupsTenant    string
+	createGroupsWorkspace string
+
+	destinationOnlySidecars bool
+
+	ambientNamespaces []string
+	ambientMode       string
+	// namespace of the waypoint of each ambient namespace behind one
+	ambientWaypoints map[string]string
+	// --sidecar-quirks of each namespace
+	sidecarQuirks map[string]*SidecarQuirk
+
+	// --common-labels and --common-annotations, templated for each object
+	commonLabels      []commonValue
+	commonAnnotations []commonValue
+
+	// --tls-origination, for the DestinationRules of external destinations
+	tlsOrigination []*TLSOrigination
+
+	eastWestNamespace string
+	eastWestRemote    bool
+	// destinations with locality failover, from --include-failover
+	failoverHosts []failoverHost
+
+	aggregateBy     string
+	splitSettingsBy string
+	revision        string
+	// with --effective-settings, only the TrafficSettings adding hosts to the inherited ones are generated
+	effectiveSettings bool
+
+	apply           bool
+	prune           bool
+	dryRun          string
+	gitopsNamespace string
+	kubectl         *Kubectl
+	// riskiest change --apply applies without approval; every change if empty
+	applyMaxRisk string
+
+	dumpDir           string
+	debugJSONMaxBytes int
+
 	debug   bool
 	verbose bool
 	client  APIClient
 }
 
-var debug = func(format string, a ...any) { fmt.Fprintf(os.Stderr, format+"\n", a...) }
-
 func main() {
 
 	// flags
 	var (
-		startFlag string
-		endFlag   string
-		noverbose bool
+		configFile  string
+		tctlProfile string
+		caCertFile  string
+		headerFlags []string
+		startFlag   string
+		endFlag     string
+		noverbose   bool
 	)
 
 	// static & runtime configs
@@ -73,101 +408,1112 @@ func main() {
 		runtime = &Runtime{}
 	)
 	cmd := &cobra.Command{
-		Use:   "generate-sidecar-tool",
-		Short: "generate-sidecar-tool: a simple tool for creating Istio Sidecar or TSB TrafficSetting reachability based on the service topology",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
+		Use:     "generate-sidecar-tool",
+		Short:   "generate-sidecar-tool: a simple tool for creating Istio Sidecar or TSB TrafficSetting reachability based on the service topology",
+		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// Set up the app based on config+flags
-			if !cfg.debug {
-				debug = func(fmt string, args ...any) {}
+			if configFile != "" {
+				if err := applyConfigFile(configFile, cmd.Root(), cmd.Flags()); err != nil {
+					return err
+				}
+			}
+			if caCertFile != "" {
+				ca, err := os.ReadFile(caCertFile)
+				if err != nil {
+					return fmt.Errorf("failed to read --ca-cert %q: %w", caCertFile, err)
+				}
+				cfg.caBundle = ca
+			}
+			headers, err := parseHeaders(headerFlags)
+			if err != nil {
+				return err
+			}
+			cfg.headers = headers
+			if tctlProfile == "" && isTctlPlugin() {
+				tctlProfile = tctlCurrentProfile
+			}
+			if tctlProfile != "" {
+				if err := applyTctlConfig(tctlProfile, cmd.Flags(), cfg); err != nil {
+					return err
+				}
+			}
+			for _, secret := range []string{cfg.password, cfg.token, inputToken} {
+				addSecret(secret)
+			}
+			addSecretHeaders(cfg.headers)
+			if !redactDebug {
+				unsafeLogCredentials = true
+			}
+			if err := setupLogging(cfg.logFile, cfg.quiet); err != nil {
+				return err
+			}
+			if unsafeLogCredentials {
+				warn("the credentials are logged as is, don't share the logs")
+			}
+			if cfg.debug {
+				debug = printDebug
 			}
 			if noverbose {
 				cfg.verbose = false
 			}
+			if err := setupExplain(cfg.explain, cfg.explainFile); err != nil {
+				return err
+			}
+			if err := setupEvents(cfg.eventsFile); err != nil {
+				return err
+			}
+			if cfg.createGroups && cfg.createGroupsTenant == "" {
+				return fmt.Errorf("--create-groups-tenant can't be empty with --create-groups, need the tenant to create the groups in")
+			}
+			if cfg.allLayers {
+				cfg.layers = nil
+			}
 
-			if cfg.server == "" {
+			if cfg.inCluster {
+				if !inKubernetesPod() {
+					return fmt.Errorf("--in-cluster requires running in a Kubernetes pod")
+				}
+				if len(cfg.servers) == 0 {
+					server, err := inClusterServer(cfg.tsbNamespace)
+					if err != nil {
+						return err
+					}
+					cfg.servers = []string{server}
+				}
+				if cfg.token == "" && cfg.tokenFile == "" {
+					cfg.tokenFile = defaultServiceAccountTokenFile
+				}
+			}
+			if len(cfg.servers) == 0 {
 				return fmt.Errorf("server address (-s or --server) can't be empty, need an address like 'tsb.yourcorp.com' or an IP like '127.0.1.10'")
-			} else {
+			}
+			for i, server := range cfg.servers {
 				// normalize the name; in the client code we prefix every call with `https`, so
 				// strip any prefix on input so that both address with protocol and without work
-				cfg.server = strings.TrimPrefix(cfg.server, "https://")
-				cfg.server = strings.TrimPrefix(cfg.server, "http://")
+				server = strings.TrimPrefix(server, "https://")
+				server = strings.TrimPrefix(server, "http://")
+				cfg.servers[i] = server
+
+				debug("got TSB string %q", server)
+			}
 
-				debug("got TSB string %q", cfg.server)
+			if cfg.loginPath != "" && !strings.HasPrefix(cfg.loginPath, "/") {
+				return fmt.Errorf("invalid --login-path %q, must be an absolute path like /v2/login", cfg.loginPath)
 			}
 
-			if start, err := time.Parse(DATE_FORMAT, startFlag); err != nil {
+			cfg.step = strings.ToUpper(cfg.step)
+			if _, ok := graphQLStepFormats[cfg.step]; !ok {
+				return fmt.Errorf("invalid --step %q, must be one of DAY, HOUR or MINUTE", cfg.step)
+			}
+			if start, err := parseWindowTime(startFlag); err != nil {
 				return fmt.Errorf("failed to parse start time %q: %w", startFlag, err)
 			} else {
 				cfg.start = start
 			}
-			if end, err := time.Parse(DATE_FORMAT, endFlag); err != nil {
-				return fmt.Errorf("failed to parse start time %q: %w", endFlag, err)
+			if end, err := parseWindowTime(endFlag); err != nil {
+				return fmt.Errorf("failed to parse end time %q: %w", endFlag, err)
 			} else {
 				cfg.end = end
 			}
 
-			runtime = &Runtime{
-				start:   cfg.start,
-				end:     cfg.end,
-				server:  cfg.server,
-				debug:   cfg.debug,
-				verbose: cfg.verbose,
-				client:  NewTSBHttpClient(cfg),
+			if cfg.policyCheckMode != policyCheckFail && cfg.policyCheckMode != policyCheckAnnotate {
+				return fmt.Errorf("invalid --policy-check-mode %q, must be one of %q or %q", cfg.policyCheckMode, policyCheckFail, policyCheckAnnotate)
 			}
-			return nil
-		},
-		RunE: func(cmd *cobra.Command, args []string) error {
-			debugLogJSON := func(data interface{}) { debugLogJSON(runtime, data) }
-			// Do the work: get the topology and services
-			top, err := runtime.client.GetTopology(runtime.start, runtime.end)
+
+			if cfg.workspace != "" && cfg.tenant == "" {
+				return fmt.Errorf("--workspace requires --tenant")
+			}
+			client, err := NewTSBHttpClient(cfg)
 			if err != nil {
-				return fmt.Errorf("failed to get server topology: %w", err)
+				return err
+			}
+			if cfg.autoOrg != "" {
+				orgs, err := discoverOrgs(client, cfg.autoOrg)
+				if err != nil {
+					return err
+				}
+				client.orgs = orgs
 			}
-			debugLogJSON(top)
 
-			services, err := runtime.client.GetServices()
+			if cfg.outputTemplate != "" && cfg.jsonPath != "" {
+				return fmt.Errorf("only one of --output-template and --jsonpath can be set")
+			}
+			if cfg.outputDir != "" && (cfg.outputTemplate != "" || cfg.jsonPath != "" || cfg.signKey != "") {
+				return fmt.Errorf("--output-dir can't be used with --output-template, --jsonpath or --sign-key, it writes YAML chunks")
+			}
+			if cfg.sidecarOutput != sidecarOutputTctl && cfg.sidecarOutput != sidecarOutputK8s {
+				return fmt.Errorf("invalid --sidecar-output %q, must be one of %q or %q", cfg.sidecarOutput, sidecarOutputTctl, sidecarOutputK8s)
+			}
+			if cfg.outputDir == "" && (cfg.maxDocsPerFile != 0 || cfg.maxFileSize != 0) {
+				return fmt.Errorf("--max-docs-per-file and --max-file-size require --output-dir")
+			}
+			if cfg.maxDocsPerFile < 0 || cfg.maxFileSize < 0 {
+				return fmt.Errorf("--max-docs-per-file and --max-file-size can't be negative")
+			}
+			if cfg.minSuccessRate < 0 || cfg.minSuccessRate > 1 {
+				return fmt.Errorf("invalid --min-success-rate %v, must be between 0 and 1", cfg.minSuccessRate)
+			}
+			if cfg.dryRun != dryRunNone && cfg.dryRun != dryRunClient && cfg.dryRun != dryRunServer {
+				return fmt.Errorf("invalid --dry-run %q, must be one of %q, %q or %q", cfg.dryRun, dryRunClient, dryRunServer, dryRunNone)
+			}
+			if cfg.applyMaxRisk != "" {
+				if !slices.Contains(riskLevels, cfg.applyMaxRisk) {
+					return fmt.Errorf("invalid --apply-max-risk %q, must be one of %q", cfg.applyMaxRisk, riskLevels)
+				}
+				if !cfg.apply || cfg.stateFile == "" {
+					return fmt.Errorf("--apply-max-risk requires --apply and --state-file, to queue the riskier changes in")
+				}
+			}
+			if cfg.prune && (!cfg.apply || cfg.stateFile == "") {
+				return fmt.Errorf("--prune requires --apply and --state-file")
+			}
+			if cfg.clustersFrom != clustersFromDeployments && cfg.clustersFrom != clustersFromTopology {
+				return fmt.Errorf("invalid --clusters-from %q, must be one of %q or %q", cfg.clustersFrom, clustersFromDeployments, clustersFromTopology)
+			}
+			if !slices.Contains(onUnknownModes, cfg.onUnknownMode) {
+				return fmt.Errorf("invalid --on-unknown-mode %q, must be one of %q", cfg.onUnknownMode, onUnknownModes)
+			}
+			if cfg.aggregateBy != aggregateByGroup && cfg.aggregateBy != aggregateByNamespace {
+				return fmt.Errorf("invalid --aggregate-by %q, must be one of %q or %q", cfg.aggregateBy, aggregateByGroup, aggregateByNamespace)
+			}
+			if cfg.revision != revisionTimestamp && cfg.revision != revisionHash {
+				return fmt.Errorf("invalid --revision %q, must be one of %q or %q", cfg.revision, revisionTimestamp, revisionHash)
+			}
+			if cfg.splitSettingsBy != splitSettingsByGroup && cfg.splitSettingsBy != splitSettingsByNamespace {
+				return fmt.Errorf("invalid --split-settings-by %q, must be one of %q or %q", cfg.splitSettingsBy, splitSettingsByGroup, splitSettingsByNamespace)
+			}
+			if cfg.lint != lintOff && cfg.lint != lintWarn && cfg.lint != lintError {
+				return fmt.Errorf("invalid --lint %q, must be one of %q, %q or %q", cfg.lint, lintOff, lintWarn, lintError)
+			}
+			if cfg.eastWestRemote && cfg.eastWestNamespace == "" {
+				return fmt.Errorf("--east-west-remote requires --east-west-namespace")
+			}
+			if cfg.ambientMode != ambientSkip && cfg.ambientMode != ambientAuthz {
+				return fmt.Errorf("invalid --ambient %q, must be one of %q or %q", cfg.ambientMode, ambientSkip, ambientAuthz)
+			}
+			if cfg.detectAmbient {
+				detected, waypoints, err := detectAmbientNamespaces(NewKubectl(cfg))
+				if err != nil {
+					return err
+				}
+				cfg.ambientNamespaces = mergeSorted(cfg.ambientNamespaces, detected)
+				for ns, wns := range waypoints {
+					// the configured waypoints take precedence
+					if _, ok := cfg.ambientWaypoints[ns]; !ok {
+						if cfg.ambientWaypoints == nil {
+							cfg.ambientWaypoints = make(map[string]string)
+						}
+						cfg.ambientWaypoints[ns] = wns
+					}
+				}
+			}
+
+			quirks := make(map[string]*SidecarQuirk, len(cfg.sidecarQuirks))
+			for ns, value := range cfg.sidecarQuirks {
+				if quirks[ns], err = parseSidecarQuirk(value); err != nil {
+					return err
+				}
+			}
+
+			budgets, err := parseBudgets(cfg.budgets)
 			if err != nil {
-				return fmt.Errorf("failed to get service list: %w", err)
+				return err
+			}
+			if cfg.onBudgetExceeded != onBudgetAbort && cfg.onBudgetExceeded != onBudgetDegrade {
+				return fmt.Errorf("invalid --on-budget-exceeded %q, must be one of %q or %q", cfg.onBudgetExceeded, onBudgetAbort, onBudgetDegrade)
 			}
-			debugLogJSON(services)
 
-			// take the data and build the graph of namespaces; we get back a map of
-			// source namespace to list of destination namespaces
-			callers := buildGraph(runtime, top, services)
+			var tlsOrigination []*TLSOrigination
+			for pattern, value := range cfg.tlsOrigination {
+				tls, err := parseTLSOrigination(pattern, value)
+				if err != nil {
+					return err
+				}
+				tlsOrigination = append(tlsOrigination, tls)
+			}
+
+			commonLabels, err := parseCommonValues("--common-labels", cfg.commonLabels)
+			if err != nil {
+				return err
+			}
+			commonAnnotations, err := parseCommonValues("--common-annotations", cfg.commonAnnotations)
+			if err != nil {
+				return err
+			}
 
-			results, err := generateSettings(runtime.client, callers)
+			businessHours, err := parseBusinessHours(cfg.businessHours)
 			if err != nil {
 				return err
 			}
-			var resp []api.Response
-			for _, r := range results {
-				resp = append(resp, api.ProtoToResponses(r)...)
+			businessLocation, err := time.LoadLocation(cfg.businessTimezone)
+			if err != nil {
+				return fmt.Errorf("invalid --business-hours-timezone %q: %w", cfg.businessTimezone, err)
 			}
 
-			printers.OutputResponse(resp, api.OutputType(api.OutputYAML), cmd.OutOrStdout(), printers.DefaultFormatter{}, "")
+			var scope []string
+			if cfg.scopeFrom != "" {
+				if scope, err = readScope(cfg.scopeFrom); err != nil {
+					return err
+				}
+			}
+
+			var schedule *cronSchedule
+			if cfg.schedule != "" {
+				if schedule, err = parseCron(cfg.schedule); err != nil {
+					return err
+				}
+			}
+			if cfg.windowSinceLastRun && cfg.stateFile == "" {
+				return fmt.Errorf("--window-since-last-run requires --state-file")
+			}
+
+			var push *ociReference
+			if cfg.push != "" {
+				if push, err = parseOCIReference(cfg.push); err != nil {
+					return err
+				}
+			}
+
+			var signKey ed25519.PrivateKey
+			if cfg.signKey != "" {
+				if cfg.signatureFile == "" {
+					return fmt.Errorf("--sign-key requires --signature-file")
+				}
+				if signKey, err = loadSigningKey(cfg.signKey); err != nil {
+					return err
+				}
+			}
+
+			var failoverHosts []failoverHost
+			if cfg.includeFailover {
+				if failoverHosts, err = detectFailoverHosts(NewKubectl(cfg)); err != nil {
+					return err
+				}
+			}
+
+			var rules []*NamespaceRule
+			if cfg.namespaceRules != "" {
+				if rules, err = loadNamespaceRules(cfg.namespaceRules); err != nil {
+					return err
+				}
+			}
+
+			var extraEdges []ExtraEdge
+			if cfg.extraEdges != "" {
+				if extraEdges, err = loadExtraEdges(cfg.extraEdges); err != nil {
+					return err
+				}
+			}
+
+			var exclusions map[string]string
+			if cfg.exclusionsFile != "" {
+				if exclusions, err = loadExclusions(cfg.exclusionsFile); err != nil {
+					return err
+				}
+			}
+
+			var owners map[string]string
+			switch {
+			case cfg.owner == "":
+				if cfg.ownersFile != "" || cfg.ownerLabel != "" {
+					return fmt.Errorf("--owners-file and --owner-label require --owner")
+				}
+			case cfg.ownersFile != "" && cfg.ownerLabel != "":
+				return fmt.Errorf("--owners-file and --owner-label are mutually exclusive")
+			case cfg.ownersFile != "":
+				if owners, err = loadOwners(cfg.ownersFile); err != nil {
+					return err
+				}
+			case cfg.ownerLabel != "":
+				if owners, err = namespaceOwners(NewKubectl(cfg), cfg.ownerLabel, cfg.owner); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("--owner requires --owners-file or --owner-label, to know the namespaces it owns")
+			}
+
+			if cfg.nameStyle != nameStyleFQN && cfg.nameStyle != nameStyleDisplay && cfg.nameStyle != nameStyleCanonical {
+				return fmt.Errorf("invalid --name-style %q, must be one of %q, %q or %q", cfg.nameStyle, nameStyleFQN, nameStyleDisplay, nameStyleCanonical)
+			}
+			if !slices.Contains(duplicateKeyStrategies, cfg.onDuplicateKey) {
+				return fmt.Errorf("invalid --on-duplicate-key %q, must be one of %s", cfg.onDuplicateKey, strings.Join(duplicateKeyStrategies, ", "))
+			}
+			if cfg.graphIn != "" && (cfg.graphOut != "" || cfg.windowSinceLastRun) {
+				return fmt.Errorf("--graph-in can't be used with --graph-out or --window-since-last-run, the window is the one in the file")
+			}
+			if cfg.autoMapConfidence < 0 || cfg.autoMapConfidence > 1 {
+				return fmt.Errorf("invalid --auto-map-confidence %v, must be between 0 and 1", cfg.autoMapConfidence)
+			}
+			var mappings map[string]string
+			if cfg.mappingFile != "" {
+				if mappings, err = loadServiceMappings(cfg.mappingFile); err != nil {
+					return err
+				}
+			}
+
+			// fill in the shared runtime rather than replacing it, so subcommands that captured
+			// the pointer at construction time see the configured values
+			*runtime = Runtime{
+				start:  cfg.start,
+				end:    cfg.end,
+				server: cfg.servers[0],
+
+				policyCheckDir:  cfg.policyCheckDir,
+				policyCheckMode: cfg.policyCheckMode,
+				lint:            cfg.lint,
+				maxChanges:      cfg.maxChanges,
+				emitters:        cfg.emitters,
+
+				expectMinResources:  cfg.expectMinResources,
+				expectMinNamespaces: cfg.expectMinNamespaces,
+
+				excludeErrorOnly: cfg.excludeErrorOnly,
+				minSuccessRate:   cfg.minSuccessRate,
+
+				timeOfDay:        cfg.timeOfDay,
+				businessHours:    businessHours,
+				businessLocation: businessLocation,
+
+				outputTemplate: cfg.outputTemplate,
+				jsonPath:       cfg.jsonPath,
+				outputDir:      cfg.outputDir,
+				maxDocsPerFile: cfg.maxDocsPerFile,
+				maxFileSize:    cfg.maxFileSize,
+				sidecarOutput:  cfg.sidecarOutput,
+
+				signKey:       signKey,
+				signatureFile: cfg.signatureFile,
+
+				push:          push,
+				pushPlainHTTP: cfg.pushPlainHTTP,
+
+				schedule:             schedule,
+				windowSinceLastRun:   cfg.windowSinceLastRun,
+				reportedWarningsFile: cfg.reportedWarningsFile,
+				lock:                 cfg.lock,
+				lockTTL:              cfg.lockTTL,
+				watchChanges:         cfg.watchChanges,
+
+				budgets:          budgets,
+				onBudgetExceeded: cfg.onBudgetExceeded,
+
+				summaryFile:     cfg.summaryFile,
+				scope:           scope,
+				namespaceRules:  rules,
+				exclusions:      exclusions,
+				owner:           cfg.owner,
+				owners:          owners,
+				extraEdges:      extraEdges,
+				tenantScoped:    cfg.tenant != "",
+				serviceMappings: mappings,
+				graphOut:        cfg.graphOut,
+				graphIn:         cfg.graphIn,
+				nameStyle:       cfg.nameStyle,
+				onDuplicateKey:  cfg.onDuplicateKey,
+
+				suggestMappings:   cfg.suggestMappings,
+				autoMapConfidence: cfg.autoMapConfidence,
+
+				stateFile:       cfg.stateFile,
+				allowShrink:     cfg.allowShrink,
+				shrinkThreshold: cfg.shrinkThreshold,
+
+				assumeBidirectional:     cfg.assumeBidirectional,
+				bidirectionalNamespaces: cfg.bidirectionalNamespaces,
+				bidirectionalComponents: cfg.bidirectionalComponents,
+
+				attributeByDeployment: cfg.attributeByDeployment,
+				clustersFrom:          cfg.clustersFrom,
+				onUnknownMode:         cfg.onUnknownMode,
+				allowCrossTrustDomain: cfg.allowCrossTrustDomain,
+				layers:                cfg.layers,
+				includeNodeTypes:      cfg.includeNodeTypes,
+				excludeNodeTypes:      cfg.excludeNodeTypes,
+
+				requireCrossTrustDomainOptIn: cfg.requireCrossTrustDomainOptIn,
+
+				createGroups:          cfg.createGroups,
+				createGroupsTenant:    cfg.createGroupsTenant,
+				createGroupsWorkspace: cfg.createGroupsWorkspace,
+
+				destinationOnlySidecars: cfg.destinationOnlySidecars,
+
+				ambientNamespaces: cfg.ambientNamespaces,
+				ambientMode:       cfg.ambientMode,
+				ambientWaypoints:  cfg.ambientWaypoints,
+				sidecarQuirks:     quirks,
+
+				commonLabels:      commonLabels,
+				commonAnnotations: commonAnnotations,
+
+				tlsOrigination: tlsOrigination,
+
+				eastWestNamespace: cfg.eastWestNamespace,
+				eastWestRemote:    cfg.eastWestRemote,
+				failoverHosts:     failoverHosts,
+
+				aggregateBy:     cfg.aggregateBy,
+				splitSettingsBy: cfg.splitSettingsBy,
+				revision:        cfg.revision,
+
+				effectiveSettings: cfg.effectiveSettings,
+
+				apply:           cfg.apply,
+				applyMaxRisk:    cfg.applyMaxRisk,
+				prune:           cfg.prune,
+				gitopsNamespace: cfg.gitopsNamespace,
+				dryRun:          cfg.dryRun,
+				kubectl:         NewKubectl(cfg),
+
+				dumpDir:           cfg.dumpDir,
+				debugJSONMaxBytes: cfg.debugJSONMaxBytes,
+
+				debug:   cfg.debug,
+				verbose: cfg.verbose,
+				client:  newSharedSettingsClient(client),
+			}
 			return nil
 		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runtime.schedule != nil || runtime.watchChanges > 0 {
+				return daemon(runtime, cmd.OutOrStdout())
+			}
+			return generate(runtime, cmd.OutOrStdout())
+		},
 	}
 
-	cmd.Flags().StringVarP(&cfg.server, "server", "s", "", "Address of the TSB API server, e.g. some.tsb.address.example.com. REQUIRED")
-	cmd.Flags().StringVarP(&cfg.username, "http-auth-user", "u", "", "Username to call TSB with via HTTP Basic Auth. REQUIRED")
-	cmd.Flags().StringVarP(&cfg.password, "http-auth-password", "p", "", "Password to call TSB with via HTTP Basic Auth. REQUIRED")
-	cmd.Flags().StringVar(&cfg.org, "org", "tetrate", "TSB org to query against")
-	cmd.Flags().StringVar(&startFlag, "start", fmt.Sprint(time.Now().Add(-5*24*time.Hour).Format(DATE_FORMAT)),
-		"Start of the time range to query the topology in YYYY-MM-DD format")
-	cmd.Flags().StringVar(&endFlag, "end", fmt.Sprint(time.Now().Format(DATE_FORMAT)),
-		"End of the time range to query the topology in YYYY-MM-DD format")
-	cmd.Flags().BoolVarP(&cfg.insecure, "insecure", "k", false, "Skip certificate verification when calling TSB")
-	cmd.Flags().BoolVar(&cfg.debug, "debug", false, "Enable debug logging")
-	cmd.Flags().BoolVar(&cfg.verbose, "verbose", true, "Enable verbose output, explaining why policy was generated; otherwise only the policy documents are printed.")
-	cmd.Flags().BoolVar(&noverbose, "noverbose", false, "Disable verbose output; overrides --verbose (equivalent to --verbose=false)")
+	cmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML config file setting flags by their long name; flags given in the command line take precedence")
+	cmd.PersistentFlags().StringVar(&inputToken, "input-token", "",
+		"Bearer token to fetch the input files given as http(s) URLs with, unless the URL has credentials")
+	cmd.PersistentFlags().StringVar(&tctlProfile, "use-tctl-config", "",
+		"tctl profile to take the server, TLS settings, credentials and organization from, or its current one if given without a value; flags given in the command line take precedence")
+	cmd.PersistentFlags().Lookup("use-tctl-config").NoOptDefVal = tctlCurrentProfile
+	cmd.PersistentFlags().StringSliceVarP(&cfg.servers, "server", "s", nil,
+		"Address of the TSB API server, e.g. some.tsb.address.example.com. Several front-ends of the same TSB can be given, to fail over between them. REQUIRED")
+	cmd.PersistentFlags().StringVarP(&cfg.username, "http-auth-user", "u", "", "Username to call TSB with via HTTP Basic Auth. REQUIRED")
+	cmd.PersistentFlags().StringVarP(&cfg.password, "http-auth-password", "p", "", "Password to call TSB with via HTTP Basic Auth. REQUIRED")
+	cmd.PersistentFlags().StringVar(&cfg.token, "token", "", "TSB token to call TSB with, like the one of 'tctl login', instead of HTTP Basic Auth")
+	cmd.PersistentFlags().StringVar(&cfg.tokenFile, "token-file", "",
+		"File with the token to call TSB with, read again for every request so rotated tokens are picked up; with --in-cluster, defaults to the pod's service account token")
+	cmd.PersistentFlags().StringVar(&cfg.loginPath, "login-path", "",
+		"Path of a login endpoint to send the credentials to once per server, sending the session cookie it sets with the other requests instead of them")
+	cmd.PersistentFlags().BoolVar(&cfg.inCluster, "in-cluster", false,
+		"Run as a Job or CronJob in the TSB management cluster: call the TSB front envoy in --tsb-namespace unless --server is set, with the pod's service account token")
+	cmd.PersistentFlags().StringVar(&cfg.tsbNamespace, "tsb-namespace", "",
+		"With --in-cluster, namespace TSB runs in; defaults to the namespace of the pod")
+	cmd.PersistentFlags().StringVar(&cfg.apiVersion, "api-version", apiVersionAuto,
+		"TSB API version to use, e.g. v2, or 'auto' to use the newest one the server supports")
+	cmd.PersistentFlags().StringVar(&cfg.scopeFrom, "scope-from", "",
+		"File with the namespaces or service FQNs to limit the run to, one per line, or - to read them from stdin")
+	cmd.PersistentFlags().StringVar(&cfg.namespaceRules, "namespace-rules", "",
+		"YAML file with regex rules replacing destination namespaces or adding others along them, e.g. to allow locality failover namespaces")
+	cmd.PersistentFlags().StringVar(&cfg.exclusionsFile, "exclusions-file", "",
+		"YAML file with the namespaces to intentionally not generate anything for, each with the reason, shown in reports")
+	cmd.PersistentFlags().StringVar(&cfg.owner, "owner", "",
+		"Only generate for the namespaces owned by this owner, e.g. a team, per --owners-file or --owner-label")
+	cmd.PersistentFlags().StringVar(&cfg.ownersFile, "owners-file", "", "With --owner, YAML file mapping each namespace to its owner")
+	cmd.PersistentFlags().StringVar(&cfg.ownerLabel, "owner-label", "",
+		"With --owner, label of the namespaces in the --kube-context cluster whose value is their owner, e.g. team")
+	cmd.PersistentFlags().StringVar(&cfg.extraEdges, "extra-edges", "",
+		"CSV file with known but unobserved dependencies to add to the topology, as source service FQN, target service FQN and reason")
+	cmd.PersistentFlags().StringVar(&cfg.mappingFile, "mapping-file", "",
+		"YAML file mapping topology node names to the FQN of their TSB service, for nodes that match no aggregation key")
+	cmd.PersistentFlags().StringVar(&cfg.graphOut, "graph-out", "",
+		"Write the graph of calls built from the topology and services in TSB to this file, to generate from it later with --graph-in")
+	cmd.PersistentFlags().StringVar(&cfg.graphIn, "graph-in", "",
+		"Generate from the graph of calls in this file, written by --graph-out, instead of querying the topology and services; its window replaces --start and --end")
+	cmd.PersistentFlags().StringVar(&cfg.nameStyle, "name-style", nameStyleDisplay,
+		"How to name services in reports and explanations: 'display' or 'canonical' names, falling back to the FQN, or 'fqn'")
+	cmd.PersistentFlags().StringVar(&cfg.onDuplicateKey, "on-duplicate-key", duplicateKeyFirst,
+		fmt.Sprintf("What to do when services share an aggregation key, so their topology nodes are ambiguous: %s", strings.Join(duplicateKeyStrategies, ", ")))
+	cmd.PersistentFlags().BoolVar(&cfg.suggestMappings, "suggest-mappings", false,
+		"Propose --mapping-file entries for the topology nodes with no TSB service, matching their names with the services' deployments")
+	cmd.PersistentFlags().Float64Var(&cfg.autoMapConfidence, "auto-map-confidence", 0,
+		"Use the proposed mappings at least this confident, between 0 and 1, instead of only proposing them; implies --suggest-mappings")
+	cmd.PersistentFlags().BoolVar(&cfg.cache, "cache", false, "Cache the responses from TSB on disk between runs, for --cache-ttl")
+	cmd.PersistentFlags().BoolVar(&cfg.noCache, "no-cache", false, "Don't cache the responses from TSB between runs")
+	cmd.PersistentFlags().MarkDeprecated("no-cache", "the responses are only cached with --cache")
+	cmd.PersistentFlags().StringSliceVar(&cfg.refresh, "refresh", nil,
+		fmt.Sprintf("Fetch again the data of these kinds instead of using the cached responses: %s", strings.Join(cacheCategories, ", ")))
+	cmd.PersistentFlags().StringVar(&cfg.cacheDir, "cache-dir", "", "Directory to cache the responses from TSB in; defaults to one in the user cache directory")
+	durationVar(cmd.PersistentFlags(), &cfg.cacheTTL, "cache-ttl", time.Hour, "How long the cached responses from TSB are used for")
+	cmd.PersistentFlags().StringVar(&cfg.org, "org", "tetrate", "TSB org to query against")
+	cmd.PersistentFlags().StringVar(&cfg.autoOrg, "auto-org", "",
+		"Discover the org from the ones visible to the credentials instead of using --org: 'single' fails if several are visible, 'all' queries all of them")
+	cmd.PersistentFlags().Lookup("auto-org").NoOptDefVal = autoOrgSingle
+	cmd.PersistentFlags().StringVar(&cfg.tenant, "tenant", "",
+		"Only list the services of this tenant, rather than of the whole org; calls to services outside of it are dropped")
+	cmd.PersistentFlags().StringVar(&cfg.workspace, "workspace", "", "With --tenant, only list the services of this workspace in it")
+	cmd.PersistentFlags().StringVar(&startFlag, "start", fmt.Sprint(time.Now().Add(-5*24*time.Hour).Format(DATE_FORMAT)),
+		"Start of the time range to query the topology in YYYY-MM-DD format, or 'YYYY-MM-DD HH' and 'YYYY-MM-DD HHmm' with a finer --step")
+	cmd.PersistentFlags().StringVar(&endFlag, "end", fmt.Sprint(time.Now().Format(DATE_FORMAT)),
+		"End of the time range to query the topology in YYYY-MM-DD format, or 'YYYY-MM-DD HH' and 'YYYY-MM-DD HHmm' with a finer --step")
+	cmd.PersistentFlags().StringVar(&cfg.step, "step", "DAY",
+		"Step of the telemetry queries, DAY, HOUR or MINUTE; finer steps keep the window precise at the cost of more load on OAP")
+	durationVar(cmd.PersistentFlags(), &cfg.oapTimeout, "oap-timeout", 0, "Timeout of each telemetry query to OAP, none if zero")
+	cmd.PersistentFlags().BoolVar(&cfg.multiStep, "multi-step", false,
+		"Also query the topology at the next finer step than --step and merge both, as coarse steps can miss short-lived calls; doubles the topology queries")
+	cmd.PersistentFlags().BoolVar(&cfg.strictDecoding, "strict-decoding", false,
+		"Fail to decode the TrafficSettings from TSB with fields the tool doesn't know, to catch API changes early; they're ignored otherwise")
+	cmd.PersistentFlags().BoolVarP(&cfg.insecure, "insecure", "k", false, "Skip certificate verification when calling TSB")
+	cmd.PersistentFlags().StringVar(&caCertFile, "ca-cert", "", "PEM file with the CA certificates to trust when calling TSB, besides the system ones")
+	cmd.PersistentFlags().StringArrayVar(&headerFlags, "header", nil,
+		"Header to send with every request to TSB, as key=value, e.g. for an auth proxy in front of it. Can be repeated")
+	cmd.PersistentFlags().BoolVar(&cfg.debug, "debug", false, "Enable debug logging")
+	cmd.PersistentFlags().StringVar(&cfg.dumpDir, "dump-dir", "", "With --debug, write the full JSON payloads from TSB gzipped into files in this directory instead of logging them")
+	cmd.PersistentFlags().IntVar(&cfg.debugJSONMaxBytes, "debug-json-max-bytes", 64*1024,
+		"With --debug, truncate the JSON payloads from TSB logged to this many bytes; 0 logs them whole")
+	cmd.PersistentFlags().BoolVar(&cfg.verbose, "verbose", true, "Enable verbose output, explaining why policy was generated; otherwise only the policy documents are printed.")
+	cmd.PersistentFlags().StringSliceVar(&cfg.explain, "explain", nil,
+		"Explain the generation in these channels: 'graph' (how calls are resolved), 'policy' (why hosts are allowed), 'api' (calls to TSB), or 'all'")
+	cmd.PersistentFlags().StringVar(&cfg.explainFile, "explain-file", "", "Write the --explain output to this file instead of stderr")
+	cmd.PersistentFlags().BoolVar(&unsafeLogCredentials, "unsafe-log-credentials", false,
+		"Don't redact the credentials from the debug logs, explanations and dumps; only to troubleshoot in a lab")
+	cmd.PersistentFlags().BoolVar(&redactDebug, "redact-debug", true,
+		"Redact the credentials from the debug logs, explanations and dumps; false is the same as --unsafe-log-credentials")
+	cmd.PersistentFlags().StringVar(&cfg.logFile, "log-file", "", "Append debug logs, warnings and explanations to this file instead of stderr")
+	cmd.PersistentFlags().StringVar(&cfg.eventsFile, "events-file", "",
+		"Write the progress of the runs as JSON events, one per line, to this file or to an inherited file descriptor given as fd:<n>")
+	cmd.PersistentFlags().BoolVarP(&cfg.quiet, "quiet", "q", false, "Don't print warnings; only the resources (and errors) are printed")
+	cmd.PersistentFlags().BoolVar(&noverbose, "noverbose", false, "Disable verbose output; overrides --verbose (equivalent to --verbose=false)")
+
+	cmd.PersistentFlags().BoolVar(&cfg.assumeBidirectional, "assume-bidirectional", false,
+		"Also allow the reverse direction of observed calls, for protocols where telemetry only shows one direction")
+	cmd.PersistentFlags().StringSliceVar(&cfg.bidirectionalNamespaces, "bidirectional-namespaces", nil,
+		"Only mirror calls from or to these namespaces with --assume-bidirectional; defaults to every namespace")
+	cmd.PersistentFlags().StringSliceVar(&cfg.bidirectionalComponents, "bidirectional-components", nil,
+		"Only mirror calls detected with these components (protocols), e.g. 'tcp', with --assume-bidirectional; defaults to every call")
+	cmd.PersistentFlags().StringSliceVar(&cfg.layers, "layers", []string{"MESH"},
+		"Only consider topology nodes in these SkyWalking layers; include e.g. DATABASE or BROWSER deliberately to reach non-mesh nodes")
+	cmd.PersistentFlags().BoolVar(&cfg.allLayers, "all-layers", false, "Consider topology nodes in every layer; overrides --layers")
+	cmd.PersistentFlags().StringSliceVar(&cfg.includeNodeTypes, "include-node-types", nil,
+		"Only consider topology nodes of these SkyWalking node types, e.g. Database or MQ, besides the nodes with no type")
+	cmd.PersistentFlags().StringSliceVar(&cfg.excludeNodeTypes, "exclude-node-types", nil,
+		"Don't consider topology nodes of these SkyWalking node types, e.g. browser, nor their calls")
+	cmd.PersistentFlags().BoolVar(&cfg.attributeByDeployment, "attribute-by-deployment", false,
+		"For services deployed in several namespaces, only use the namespaces of the deployments the topology node points to, instead of all of them")
+	cmd.PersistentFlags().StringVar(&cfg.onUnknownMode, "on-unknown-mode", onUnknownModeBridged,
+		"What to do with the calls from traffic groups in a config mode other than DIRECT or BRIDGED: 'skip' them, generate for them as 'bridged' or 'direct', or 'fail'")
+	cmd.PersistentFlags().BoolVar(&cfg.allowCrossTrustDomain, "allow-cross-trust-domain", false,
+		"Generate for the calls between services whose SPIFFE IDs have no trust domain in common, i.e. across meshes, with --require-cross-trust-domain-opt-in")
+	cmd.PersistentFlags().BoolVar(&cfg.requireCrossTrustDomainOptIn, "require-cross-trust-domain-opt-in", false,
+		"Drop the calls across trust domains unless --allow-cross-trust-domain is given, rather than only annotating the objects allowing them")
+	cmd.PersistentFlags().StringVar(&cfg.clustersFrom, "clusters-from", clustersFromDeployments,
+		"Where the clusters of the calls come from: every cluster the service is deployed to in their namespaces ('deployments'), or the cluster their topology node is named after where it names one ('topology')")
+	cmd.Flags().StringVar(&cfg.policyCheckDir, "policy-check", "", "Directory of Rego policies to evaluate every generated object against with the opa CLI; objects are denied by the messages in data.main.deny")
+	cmd.Flags().StringVar(&cfg.lint, "lint", lintOff,
+		"Check the generated resources for anti-patterns like duplicate or redundant hosts, Sidecars in istio-system or unknown namespaces: 'off', 'warn' or 'error' to fail the run")
+	cmd.Flags().StringVar(&cfg.policyCheckMode, "policy-check-mode", policyCheckFail,
+		"What to do with objects violating the --policy-check policies: 'fail' the run, or 'annotate' the objects with the violations")
+
+	cmd.Flags().IntVar(&cfg.maxChanges, "max-changes", 0, "Abort without output if the run would create or modify more than this many resources; 0 means no limit")
+	cmd.Flags().IntVar(&cfg.expectMinResources, "expect-min-resources", 0,
+		"Fail without output if the run generates fewer resources, e.g. in CI to catch empty generations from wrong credentials or scoping")
+	cmd.Flags().IntVar(&cfg.expectMinNamespaces, "expect-min-namespaces", 0,
+		"Fail without output if the run generates for fewer source namespaces, e.g. in CI to catch empty generations from wrong credentials or scoping")
+
+	cmd.Flags().StringArrayVar(&cfg.emitters, "emitter", nil,
+		"Executable generating additional resources from the graph: it gets the graph as JSON in stdin and must print a JSON list of resources. Can be repeated")
+	cmd.Flags().BoolVar(&cfg.createGroups, "create-groups", false,
+		"Create a BRIDGED traffic group for each source namespace of services without one, so reachability is generated for them")
+	cmd.Flags().StringVar(&cfg.createGroupsTenant, "create-groups-tenant", "", "Tenant to create the --create-groups groups in")
+	cmd.Flags().StringVar(&cfg.createGroupsWorkspace, "create-groups-workspace", "generated-reachability",
+		"Workspace to create the --create-groups groups in; it is created too if it doesn't exist")
+	cmd.Flags().BoolVar(&cfg.destinationOnlySidecars, "destination-only-sidecars", false,
+		"Generate a Sidecar allowing only the baseline hosts for each namespace in a DIRECT group that is only ever a destination, with no outbound calls observed")
+	cmd.Flags().StringSliceVar(&cfg.ambientNamespaces, "ambient-namespaces", nil, "Namespaces in Istio ambient mode, which Sidecars don't apply to")
+	cmd.Flags().StringToStringVar(&cfg.ambientWaypoints, "ambient-waypoints", nil,
+		"Namespace of the waypoint of each ambient namespace behind one, as namespace=waypoint-namespace; calls to them also allow the waypoint namespace")
+	cmd.Flags().StringToStringVar(&cfg.sidecarQuirks, "sidecar-quirks", nil,
+		"Quirk of each namespace the egress listener of its Sidecar must account for, as namespace=quirk: 'dns-proxy' or 'hostnetwork[:<port>]'")
+	cmd.PersistentFlags().StringToStringVar(&cfg.commonLabels, "common-labels", nil,
+		"Labels to set on every generated object, as key=value; values are templates that can use {{.Organization}}, {{.Tenant}}, {{.Workspace}}, {{.Group}}, {{.Namespace}}, {{.Kind}} and {{.Name}}")
+	cmd.PersistentFlags().StringToStringVar(&cfg.commonAnnotations, "common-annotations", nil,
+		"Annotations to set on every generated object, as key=value; values are templated like --common-labels")
+	cmd.PersistentFlags().StringToStringVar(&cfg.tlsOrigination, "tls-origination", nil,
+		"Generate DestinationRules originating TLS to the external destinations matching each hostname pattern, as pattern=mode with mode 'simple' or 'mutual/<credential>', optionally followed by :<port>")
+	cmd.Flags().BoolVar(&cfg.detectAmbient, "detect-ambient", false,
+		"Detect the namespaces in Istio ambient mode in the cluster with kubectl, and their waypoints, in addition to --ambient-namespaces and --ambient-waypoints")
+	cmd.Flags().StringVar(&cfg.ambientMode, "ambient", ambientSkip,
+		"What to generate for ambient namespaces: 'skip' their Sidecars, or 'authz' to also emit AuthorizationPolicies allowing only their observed callers")
+	cmd.PersistentFlags().StringVar(&cfg.eastWestNamespace, "east-west-namespace", "",
+		"Namespace of the east-west gateways; calls between clusters also allow their source namespaces to reach it")
+	cmd.PersistentFlags().BoolVar(&cfg.eastWestRemote, "east-west-remote", false,
+		"With --east-west-namespace, also allow the destination namespaces of calls between clusters to reach the east-west gateway in their cluster")
+	cmd.PersistentFlags().StringVar(&cfg.aggregateBy, "aggregate-by", aggregateByNamespace,
+		"How the destinations of BRIDGED traffic groups are aggregated: 'group' across all of their namespaces, or 'namespace' per source namespace, shared with DIRECT Sidecars in it")
+	cmd.PersistentFlags().BoolVar(&cfg.effectiveSettings, "effective-settings", false,
+		"Reconcile the TrafficSettings of BRIDGED groups without one against the reachability they inherit from their workspace, tenant or organization, only generating the hosts missing from it")
+	cmd.PersistentFlags().StringVar(&cfg.splitSettingsBy, "split-settings-by", splitSettingsByGroup,
+		"How many TrafficSettings BRIDGED traffic groups get: one per 'group', or one per source 'namespace' in a new group selecting it when its namespaces need different hosts")
+	cmd.PersistentFlags().StringVar(&cfg.revision, "revision", revisionHash,
+		"What the "+revisionLabel+" label of the generated objects holds: a 'hash' of the generated resources, which only changes when they do, or the 'timestamp' of the run")
+	cmd.PersistentFlags().BoolVar(&cfg.includeFailover, "include-failover", false,
+		"Allow every namespace and cluster of the destinations that DestinationRules in the cluster enable locality failover for, even if the window didn't show calls to them")
+	cmd.Flags().BoolVar(&cfg.apply, "apply", false,
+		"Apply the generated resources to the cluster with kubectl, after validating all of them with a server-side dry-run")
+	cmd.Flags().StringVar(&cfg.applyMaxRisk, "apply-max-risk", "",
+		"With --apply, only apply the changes up to this risk: 'none', 'addition' of hosts, 'removal' of hosts or 'new' objects; riskier ones are queued for the approve command")
+	cmd.Flags().BoolVar(&cfg.prune, "prune", false,
+		"With --apply, delete the resources generated by the previous run in --state-file that are no longer generated")
+	cmd.PersistentFlags().StringVar(&cfg.dryRun, "dry-run", dryRunNone,
+		"Don't change the cluster with --apply, --prune and approve, nor push anything, print the changes they would make as a diff instead: 'server' against the live resources, 'client' without talking to the cluster, or 'none'")
+	cmd.PersistentFlags().Lookup("dry-run").NoOptDefVal = dryRunClient
+	cmd.PersistentFlags().StringVar(&cfg.kubectl, "kubectl", "kubectl", "kubectl binary to use to talk to the cluster")
+	cmd.PersistentFlags().StringVar(&cfg.kubeContext, "kube-context", "", "kubeconfig context to use; defaults to the current context")
+	cmd.PersistentFlags().StringVar(&cfg.gitopsNamespace, "gitops-namespace", "default",
+		"Namespace to apply TSB resources (e.g. TrafficSettings) in, for TSB GitOps to pick them up")
+	cmd.PersistentFlags().BoolVar(&cfg.excludeErrorOnly, "exclude-error-only-edges", false,
+		"Don't allow calls whose success rate is at most --min-success-rate, e.g. failed connection attempts or scans")
+	cmd.PersistentFlags().Float64Var(&cfg.minSuccessRate, "min-success-rate", 0,
+		"With --exclude-error-only-edges, success rate (between 0 and 1) at or below which calls are excluded")
+	cmd.PersistentFlags().BoolVar(&cfg.timeOfDay, "time-of-day", false,
+		"Classify the calls as business-hours, nightly-batch or constant from their hourly metrics, in the report and annotations of the generated objects")
+	cmd.PersistentFlags().StringVar(&cfg.businessHours, "business-hours", "9-18", "With --time-of-day, business hours as start-end hours of the day")
+	cmd.PersistentFlags().StringVar(&cfg.businessTimezone, "business-hours-timezone", "UTC", "With --time-of-day, IANA time zone of the --business-hours, e.g. Europe/Madrid")
+	cmd.Flags().StringVar(&cfg.outputTemplate, "output-template", "",
+		"Go template to print the generated resources with instead of YAML; its data is {\"items\": [...]} with the resources as JSON")
+	cmd.Flags().StringVar(&cfg.jsonPath, "jsonpath", "",
+		"JSONPath expression over {\"items\": [...]} with the generated resources as JSON, to print the values it selects instead of YAML")
+	cmd.Flags().StringVar(&cfg.outputDir, "output-dir", "",
+		"Directory to write the generated resources to as YAML files instead of stdout, along an index.yaml listing them")
+	cmd.Flags().IntVar(&cfg.maxDocsPerFile, "max-docs-per-file", 0, "With --output-dir, most documents in each file; 0 means no limit")
+	cmd.Flags().IntVar(&cfg.maxFileSize, "max-file-size", 0, "With --output-dir, most bytes in each file, unless a single document is larger; 0 means no limit")
+	cmd.Flags().StringVar(&cfg.sidecarOutput, "sidecar-output", sidecarOutputTctl,
+		"Form of the Sidecars in the output: 'tctl' wrapped in TSB objects, or 'k8s' as plain networking.istio.io manifests for kubectl")
+	cmd.Flags().StringVar(&cfg.signKey, "sign-key", "",
+		"PEM file with an Ed25519 private key to sign the output with, so it can be checked with verify-bundle before applying it")
+	cmd.Flags().StringVar(&cfg.signatureFile, "signature-file", "", "File to write the detached --sign-key signature of the output to")
+	cmd.Flags().StringVar(&cfg.push, "push", "",
+		"Push the generated resources and report as an OCI artifact to the oci://<registry>/<repository>:<tag> reference, with the docker login credentials")
+	cmd.Flags().BoolVar(&cfg.pushPlainHTTP, "push-plain-http", false, "Talk to the --push registry over HTTP rather than HTTPS")
+	cmd.Flags().StringVar(&cfg.schedule, "schedule", "",
+		"Run as a daemon, generating on the cron schedule, e.g. '0 3 * * 1' for Mondays at 03:00 local time")
+	cmd.Flags().BoolVar(&cfg.windowSinceLastRun, "window-since-last-run", false,
+		"Generate from the window since the end of the window of the last successful run in --state-file, until now, instead of --start and --end")
+	cmd.Flags().StringVar(&cfg.reportedWarningsFile, "reported-warnings-file", "",
+		"With --schedule, file to remember the warnings already reported in across restarts, so they are only reported again once resolved")
+	cmd.Flags().StringVar(&cfg.lock, "lock", "",
+		"Lock to hold during each run, so concurrent runs don't race: file:<path>, or lease:<namespace>/<name> for a Kubernetes Lease in the --kube-context cluster")
+	durationVar(cmd.Flags(), &cfg.lockTTL, "lock-ttl", time.Hour, "Age after which a --lock is considered left behind by a crashed run, and taken over")
+	durationVar(cmd.Flags(), &cfg.watchChanges, "watch-changes", 0,
+		"Run as a daemon polling TSB's audit log at this interval, and regenerate only the groups in the workspaces and groups that changed")
+	cmd.Flags().StringVar(&cfg.summaryFile, "summary-file", "",
+		"File to write a JSON summary of the run to, with the coded diagnostics it emitted")
+	cmd.Flags().StringToStringVar(&cfg.budgets, "budget", nil,
+		"Longest the whole run ('total') or each of its phases ('topology', 'graph', 'generation', 'output') may take, e.g. total=5m,graph=2m")
+	cmd.Flags().StringVar(&cfg.onBudgetExceeded, "on-budget-exceeded", onBudgetAbort,
+		"What a run exceeding its --budget does: 'abort' it, or 'degrade' it, skipping the metrics enrichment left")
+	cmd.PersistentFlags().StringVar(&cfg.stateFile, "state-file", "", "File to persist the graph of each successful run in, to compare the next runs against")
+	cmd.Flags().BoolVar(&cfg.allowShrink, "allow-shrink", false,
+		"Generate even if the topology has no calls, or far fewer edges than the run persisted in --state-file")
+	cmd.Flags().Float64Var(&cfg.shrinkThreshold, "shrink-threshold", 0.5,
+		"Fraction of the edges of the previous run in --state-file below which the topology is considered truncated")
+
+	cmd.AddCommand(newExplainCmd(runtime))
+	cmd.AddCommand(newAuditCmd(runtime))
+	cmd.AddCommand(newCompareCmd(runtime))
+	cmd.AddCommand(newConfigCmd())
+	cmd.AddCommand(newImpactCmd(runtime))
+	cmd.AddCommand(newReportCmd(runtime))
+	cmd.AddCommand(newVerifyBundleCmd())
+	cmd.AddCommand(newSelfUpdateCmd())
+	cmd.AddCommand(newInventoryCmd(runtime))
+	cmd.AddCommand(newStatusCmd(runtime))
+	cmd.AddCommand(newTUICmd(runtime))
+	cmd.AddCommand(newServeCmd(runtime))
+	cmd.AddCommand(newApproveCmd(runtime))
+	cmd.AddCommand(newGraphCmd(runtime))
 
 	if err := cmd.Execute(); err != nil {
 		os.Exit(-1)
 	}
 }
 
-func generateDirectModeSidecars(call *Call, seenNs map[string][]string, sidecars map[string]*network1beta1.Sidecar, annotations map[string]string) {
+// Generates the resources from the topology of the runtime's window, and prints, applies and records them
+func generate(runtime *Runtime, stdout io.Writer) (err error) {
+	resources := 0
+	emitEvent(&Event{Type: eventRunStarted})
+	defer func() {
+		if serr := writeSummary(runtime.summaryFile, resources, err); serr != nil && err == nil {
+			err = serr
+		}
+		completed := &Event{Type: eventRunCompleted, Resources: resources}
+		if err != nil {
+			completed.Error = err.Error()
+		}
+		emitEvent(completed)
+		timing.debugAPICalls()
+		timing = nil
+	}()
+
+	diagnostics, skippedHosts, undecodedGroups, throttling, pendingChanges = nil, make(map[string][]string), nil, nil, nil
+	timing = newRunTiming(runtime.budgets, runtime.onBudgetExceeded)
+	release, err := acquireLock(runtime)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	state, err := loadState(runtime.stateFile)
+	if err != nil {
+		return err
+	}
+	if runtime.windowSinceLastRun && state != nil && !state.Window.End.IsZero() {
+		runtime.start, runtime.end = state.Window.End, time.Now()
+		debug("generating from the window since the last run: %s", Window{Start: runtime.start, End: runtime.end})
+	}
+
+	callers, err := fetchGraph(runtime)
+	if err != nil {
+		return err
+	}
+	timing.begin(phaseGeneration)
+	if runtime.changed != nil {
+		selectChangedGroups(runtime.changed, callers)
+	} else if err := checkShrink(runtime, ownedState(runtime, state), callers); err != nil {
+		return err
+	}
+
+	results, err := generateResources(runtime, callers)
+	if err != nil {
+		return err
+	}
+	resources = len(results)
+	revision, err := checkResults(runtime, callers, results)
+	if err != nil {
+		return err
+	}
+	if err := timing.end(); err != nil {
+		return err
+	}
+
+	timing.begin(phaseOutput)
+	defer func() {
+		if terr := timing.end(); terr != nil && err == nil {
+			err = terr
+		}
+	}()
+	if runtime.changed != nil {
+		// a selective run only generated the changed groups, which would replace the whole output of the full runs
+		debug("regenerated %d resources of the changed groups, only applying them", len(results))
+	} else if err := writeOutput(runtime, stdout, state, callers, results, revision); err != nil {
+		return err
+	}
+	return applyResults(runtime, state, callers, results, revision)
+}
+
+// Refuses the resources generated from the graph when there are more of them than --max-changes, fewer than
+// expected, or they fail the lint or the --policy-check, and labels them with their revision otherwise, returning it
+func checkResults(runtime *Runtime, graph *Graph, results []*typesv2.Object) (string, error) {
+	if runtime.maxChanges > 0 && len(results) > runtime.maxChanges {
+		return "", fmt.Errorf("refusing to output %d resources, more than --max-changes=%d; this is often caused by "+
+			"a telemetry outage producing an empty or exploded topology, check the window and the topology before raising the limit",
+			len(results), runtime.maxChanges)
+	}
+	if err := checkExpectations(runtime, graph, results); err != nil {
+		return "", err
+	}
+	if err := lint(runtime, graph, results); err != nil {
+		return "", err
+	}
+	if runtime.policyCheckDir != "" {
+		if err := policyCheck(runtime, results); err != nil {
+			return "", err
+		}
+	}
+	revision, err := resultsRevision(runtime.revision, time.Now(), results)
+	if err != nil {
+		return "", err
+	}
+	labelRevision(results, revision)
+	return revision, nil
+}
+
+// Applies the resources with --apply, prunes the ones of the previous run left behind and records the run in the
+// state file
+func applyResults(runtime *Runtime, state *State, callers *Graph, results []*typesv2.Object, revision string) error {
+	if runtime.apply {
+		if err := apply(runtime, results); err != nil {
+			return err
+		}
+	}
+	if runtime.changed != nil {
+		// the resources of the groups that didn't change weren't generated, so they can't be pruned, and the state
+		// is only kept for full runs, but for the changes queued for approval
+		return savePending(runtime, state)
+	}
+	if err := prune(runtime, orphanedResources(runtime, ownedState(runtime, state), results)); err != nil {
+		return err
+	}
+	if runtime.dryRun != dryRunNone {
+		// the cluster wasn't changed, so the next run must compare against the previous one still
+		return nil
+	}
+	if runtime.owner != "" {
+		// the resources of the other owners weren't generated, so the state is only kept for full runs, but for the
+		// changes queued for approval
+		return savePending(runtime, state)
+	}
+	return saveState(runtime, state, callers, results, revision)
+}
+
+// Writes the resources of a full run to the output, signing them with --sign-key, and pushes them with --push
+func writeOutput(runtime *Runtime, stdout io.Writer, state *State, callers *Graph, results []*typesv2.Object, revision string) error {
+	// with --sign-key, the output is signed as a whole once it's complete
+	out := stdout
+	var bundle bytes.Buffer
+	if runtime.signKey != nil {
+		out = &bundle
+	}
+	switch {
+	case runtime.outputTemplate != "":
+		if err := outputTemplate(out, runtime.outputTemplate, results, runtime.sidecarOutput); err != nil {
+			return err
+		}
+	case runtime.jsonPath != "":
+		if err := outputJSONPath(out, runtime.jsonPath, results, runtime.sidecarOutput); err != nil {
+			return err
+		}
+	case runtime.outputDir != "":
+		if err := writeChunks(runtime.outputDir, runtime.maxDocsPerFile, runtime.maxFileSize, results, runtime.sidecarOutput); err != nil {
+			return err
+		}
+	default:
+		if err := writeResourcesYAML(out, results, runtime.sidecarOutput); err != nil {
+			return err
+		}
+	}
+	if runtime.signKey != nil {
+		if err := signBundle(runtime.signKey, bundle.Bytes(), runtime.signatureFile); err != nil {
+			return err
+		}
+		if _, err := stdout.Write(bundle.Bytes()); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+
+	if runtime.push != nil {
+		if err := pushBundle(runtime, state, callers, results, revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the resources as a multi-document YAML, the way tctl prints them. Each resource is converted and written
+// before the next one, so the rendered YAML isn't buffered whole, and a slow reader of the output holds up the
+// writing. The resources themselves are all in memory already. Sidecars are written in the --sidecar-output form.
+func writeResourcesYAML(out io.Writer, results []*typesv2.Object, sidecarOutput string) error {
+	var doc bytes.Buffer
+	for _, r := range results {
+		doc.Reset()
+		data, err := resourceYAML(&doc, r, sidecarOutput)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	return nil
+}
+
+// Renders the resource into the buffer as a YAML document the way tctl prints it, starting with its separator, or
+// as a plain Kubernetes manifest for a Sidecar with --sidecar-output=k8s
+func resourceYAML(buf *bytes.Buffer, r *typesv2.Object, sidecarOutput string) ([]byte, error) {
+	buf.WriteString("---\n")
+	if sidecarOutput == sidecarOutputK8s && r.GetKind() == api.IstioSidecarKind {
+		manifest, err := kubernetesYAML(r, "")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(manifest)
+		return buf.Bytes(), nil
+	}
+	printers.OutputResponse(api.ProtoToResponses(r), api.OutputType(api.OutputYAML), buf, printers.DefaultFormatter{}, "")
+	data := buf.Bytes()
+	// tctl may start the document with a separator of its own
+	if bytes.HasPrefix(data[4:], []byte("---\n")) {
+		data = data[4:]
+	}
+	return data, nil
+}
+
+// Generates the resources for the graph: the groups --create-groups and --split-settings-by add, and what the
+// emitters generate, adjusted for ambient namespaces
+func generateResources(runtime *Runtime, callers *Graph) ([]*typesv2.Object, error) {
+	var created []*typesv2.Object
+	var err error
+	if runtime.createGroups {
+		if created, err = createGroups(runtime, callers); err != nil {
+			return nil, err
+		}
+		emitGenerated(created...)
+	}
+	if runtime.splitSettingsBy == splitSettingsByNamespace {
+		split, err := splitSettings(runtime.client, callers)
+		if err != nil {
+			return nil, err
+		}
+		emitGenerated(split...)
+		created = append(created, split...)
+	}
+	results, err := emit(runtime, callers)
+	if err != nil {
+		return nil, err
+	}
+	results = append(created, results...)
+	// the calls of the groups that didn't change aren't in the graph of selective runs, so their namespaces would look
+	// destination-only
+	if runtime.destinationOnlySidecars && runtime.changed == nil {
+		sidecars, err := destinationOnlySidecars(runtime, callers)
+		if err != nil {
+			return nil, err
+		}
+		emitGenerated(sidecars...)
+		results = append(results, sidecars...)
+	}
+	if len(runtime.tlsOrigination) > 0 {
+		rules, err := tlsOriginationRules(runtime.tlsOrigination, callers)
+		if err != nil {
+			return nil, err
+		}
+		emitGenerated(rules...)
+		results = append(results, rules...)
+	}
+	if results, err = applySidecarQuirks(runtime.sidecarQuirks, callers, results); err != nil {
+		return nil, err
+	}
+	if results, err = handleAmbient(runtime, callers, results); err != nil {
+		return nil, err
+	}
+	annotateManualEdges(callers, results)
+	annotateCrossTrustDomain(callers, results)
+	annotateTimeOfDay(callers, results)
+	if err := applyCommonMetadata(runtime.commonLabels, runtime.commonAnnotations, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Does the work shared by every command: get the topology and services, and build the graph of calls. With
+// --graph-in, the graph is read from the file instead, and the window becomes the one it was built for.
+func fetchGraph(runtime *Runtime) (*Graph, error) {
+	var graph *Graph
+	var err error
+	if runtime.graphIn != "" {
+		timing.begin(phaseGraph)
+		var window Window
+		if graph, window, err = readGraph(runtime.graphIn); err != nil {
+			return nil, err
+		}
+		runtime.start, runtime.end = window.Start, window.End
+	} else {
+		if graph, err = fetchTopologyGraph(runtime, runtime.start, runtime.end); err != nil {
+			return nil, err
+		}
+		if graph != nil && runtime.graphOut != "" {
+			if err := writeGraph(runtime.graphOut, Window{Start: runtime.start, End: runtime.end}, graph); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := refineGraph(runtime, graph); err != nil {
+		return nil, err
+	}
+	// the graph phase began in fetchTopologyGraph, once the topology was fetched
+	if err := timing.end(); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// Like fetchGraph, but for the topology observed between start and end instead of the configured window
+func fetchGraphForWindow(runtime *Runtime, start, end time.Time) (*Graph, error) {
+	graph, err := fetchTopologyGraph(runtime, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if err := refineGraph(runtime, graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// Builds the graph of the calls observed between start and end from TSB, the expensive part of fetching it
+func fetchTopologyGraph(runtime *Runtime, start, end time.Time) (*Graph, error) {
+	timing.begin(phaseTopology)
+	services, err := runtime.client.GetServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service list: %w", err)
+	}
+	debugLogJSON(runtime, "services", services)
+
+	top, err := fetchTopology(runtime, services, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server topology: %w", err)
+	}
+	debugLogJSON(runtime, "topology", top)
+	if err := timing.end(); err != nil {
+		return nil, err
+	}
+
+	timing.begin(phaseGraph)
+	if runtime.excludeErrorOnly {
+		if err := excludeErrorOnlyCalls(runtime, top, start, end); err != nil {
+			return nil, err
+		}
+	}
+
+	// take the data and build the graph of namespaces; we get back a map of
+	// source namespace to list of destination namespaces
+	servicesByTopKey, err := servicesByAggregationKey(runtime.onDuplicateKey, services)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := buildGraph(runtime, top, services, servicesByTopKey)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.timeOfDay {
+		if err := classifyCalls(runtime, graph, start, end); err != nil {
+			return nil, err
+		}
+	}
+	return graph, nil
+}
+
+// Adds the --extra-edges to the graph built from TSB, handles the groups in unknown config modes and the calls across
+// trust domains, and applies the --scope-from, --owner, --namespace-rules, --ambient-waypoints and --exclusions-file
+// to it
+func refineGraph(runtime *Runtime, graph *Graph) error {
+	if graph == nil {
+		return nil
+	}
+	if err := addExtraEdges(runtime, graph); err != nil {
+		return err
+	}
+	graph.ObservedSources = make(map[string]bool)
+	for _, call := range graph.Calls {
+		for _, ns := range call.SourceNamespaces {
+			graph.ObservedSources[ns] = true
+		}
+	}
+	if err := resolveConfigModes(runtime, graph); err != nil {
+		return err
+	}
+	checkTrustDomains(runtime, graph)
+	scopeGraph(runtime.scope, graph)
+	scopeToOwner(runtime, graph)
+	applyNamespaceRules(runtime.namespaceRules, graph)
+	addWaypointNamespaces(runtime.ambientWaypoints, graph)
+	applyExclusions(runtime.exclusions, graph)
+	return nil
+}
+
+func generateDirectModeSidecars(call *Call, seenNs map[string][]string, sidecars map[string]*network1beta1.Sidecar, annotations map[string]string, origins hostOrigins) {
 	for _, ns := range call.SourceNamespaces {
 		if _, ok := seenNs[ns]; !ok {
 			seenNs[ns] = make([]string, 0)
@@ -184,7 +1530,7 @@ func generateDirectModeSidecars(call *Call, seenNs map[string][]string, sidecars
 				Spec: v1beta1.Sidecar{
 					Egress: []*v1beta1.IstioEgressListener{
 						{
-							Hosts: []string{"istio-system/*", "xcp-multicluster/*"},
+							Hosts: slices.Clone(baselineHosts),
 						},
 					},
 				},
@@ -193,6 +1539,7 @@ func generateDirectModeSidecars(call *Call, seenNs map[string][]string, sidecars
 		}
 
 		for _, destNs := range call.TargetNamespaces {
+			origins.addCall(ns, destNs+"/*", call)
 			if slices.Contains(seenNs[ns], destNs) {
 				debug("dest %q already exists for ns %q", destNs, ns)
 				continue
@@ -200,59 +1547,106 @@ func generateDirectModeSidecars(call *Call, seenNs map[string][]string, sidecars
 			seenNs[ns] = append(seenNs[ns], destNs)
 			debug("fist time found ns %q for src %q", destNs, ns)
 			sidecars[ns].Spec.Egress[0].Hosts = append(sidecars[ns].Spec.Egress[0].Hosts, destNs+"/*")
+			explainf(explainPolicy, "Sidecar %s/%s: allowing %s/* because of call %s from %s to %s",
+				ns, sidecars[ns].Name, destNs, call.ID, call.SourceService.FQN, call.TargetService.FQN)
 		}
 	}
 }
 
-func generateBridgedModeTrafficSettings(client APIClient, call *Call, seenNs map[string][]string, trafficSettings map[string]*trafficv2.TrafficSetting, meta *typesv2.ObjectMeta) error {
+func generateBridgedModeTrafficSettings(client APIClient, call *Call, seenNs map[string][]string, trafficSettings map[string]*trafficv2.TrafficSetting, meta *typesv2.ObjectMeta, origins hostOrigins) error {
 	for _, ns := range call.SourceNamespaces {
 		if _, ok := seenNs[ns]; !ok {
 			seenNs[ns] = make([]string, 0)
 		}
 
 		debug("source namespace: %s", ns)
-		if _, ok := trafficSettings[call.SourceTrafficGroup.FQN]; !ok {
-			settings, err := client.GetTrafficSettings(call.SourceTrafficGroup.FQN)
-			if err != nil {
-				return err
-			}
-			if settings == nil {
-				// No traffic setting for the traffic group
-				settings = &trafficv2.TrafficSetting{
-					Reachability: &trafficv2.ReachabilitySettings{
-						Hosts: []string{"istio-system/*", "xcp-multicluster/*"},
-					},
-					Fqn: fqn.Tctl{}.FromMeta(api.TrafficAPI, api.TrafficSettingKind, meta),
-				}
-			}
-			trafficSettings[call.SourceTrafficGroup.FQN] = settings
-			debug("got settings for namespace %q: %+v", ns, settings)
+		if err := addTrafficSettingsHosts(client, call, seenNs, ns, trafficSettings, meta, origins); err != nil {
+			return err
 		}
+	}
 
-		for _, destNs := range call.TargetNamespaces {
-			if slices.Contains(seenNs[ns], destNs) {
-				debug("dest %q already exists for ns %q", destNs, ns)
-				continue
-			}
-			seenNs[ns] = append(seenNs[ns], destNs)
-			debug("fist time found ns %q for src %q", destNs, ns)
-			reach := trafficSettings[call.SourceTrafficGroup.FQN].GetReachability()
-			if reach != nil {
-				reachMode := reach.GetMode()
-				if reachMode != trafficv2.ReachabilitySettings_CUSTOM {
-					debug("can't create sidecar setting for traffic group %q, as its settings have rachability mode different than CUSTOM")
-				}
-			}
-			if !slices.Contains(trafficSettings[call.SourceTrafficGroup.FQN].GetReachability().GetHosts(), destNs+"/*") {
-				trafficSettings[call.SourceTrafficGroup.FQN].Reachability.Hosts = append(trafficSettings[call.SourceTrafficGroup.FQN].GetReachability().GetHosts(), destNs+"/*")
-			}
+	return nil
+}
+
+// Gets the existing TrafficSetting of the group into trafficSettings, or a new one with the baseline hosts if it
+// has none, unless it is there already
+func fetchTrafficSettings(client APIClient, groupFQN string, trafficSettings map[string]*trafficv2.TrafficSetting, meta *typesv2.ObjectMeta) error {
+	if _, ok := trafficSettings[groupFQN]; ok {
+		return nil
+	}
+	settings, err := client.GetTrafficSettings(groupFQN)
+	if err != nil {
+		return err
+	}
+	if settings == nil {
+		// No traffic setting for the traffic group
+		settings = &trafficv2.TrafficSetting{
+			Reachability: &trafficv2.ReachabilitySettings{
+				Hosts: slices.Clone(baselineHosts),
+			},
+			Fqn: fqn.Tctl{}.FromMeta(api.TrafficAPI, api.TrafficSettingKind, meta),
 		}
 	}
+	trafficSettings[groupFQN] = settings
+	debug("got settings for group %q: %+v", groupFQN, settings)
+	return nil
+}
+
+// Like generateBridgedModeTrafficSettings, but aggregating the destinations per group rather than per source
+// namespace, so a group spanning several namespaces gets each destination once
+func generateGroupTrafficSettings(client APIClient, call *Call, seenGroups map[string][]string, trafficSettings map[string]*trafficv2.TrafficSetting, meta *typesv2.ObjectMeta, origins hostOrigins) error {
+	return addTrafficSettingsHosts(client, call, seenGroups, call.SourceTrafficGroup.FQN, trafficSettings, meta, origins)
+}
 
+// Adds the destination namespaces of the call to the TrafficSetting of its group, each once per key of seen: the
+// source namespace or the group the destinations are aggregated by
+func addTrafficSettingsHosts(client APIClient, call *Call, seen map[string][]string, key string, trafficSettings map[string]*trafficv2.TrafficSetting, meta *typesv2.ObjectMeta, origins hostOrigins) error {
+	groupFQN := call.SourceTrafficGroup.FQN
+	if err := fetchTrafficSettings(client, groupFQN, trafficSettings, meta); err != nil {
+		return err
+	}
+
+	for _, destNs := range call.TargetNamespaces {
+		origins.addCall(groupFQN, destNs+"/*", call)
+		if slices.Contains(seen[key], destNs) {
+			debug("dest %q already exists for %q", destNs, key)
+			continue
+		}
+		seen[key] = append(seen[key], destNs)
+		debug("fist time found ns %q for %q", destNs, key)
+		addReachabilityHost(trafficSettings[groupFQN], call, destNs)
+	}
 	return nil
 }
 
-func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error) {
+// Adds the hosts of the destination namespace to the reachability of the TrafficSetting of the call's group.
+// Existing settings with another reachability mode than CUSTOM ignore the hosts, so rather than changing them the
+// host is recorded in skippedHosts for the operators to allow by hand.
+func addReachabilityHost(settings *trafficv2.TrafficSetting, call *Call, destNs string) {
+	groupFQN, host := call.SourceTrafficGroup.FQN, destNs+"/*"
+	if settings.Reachability == nil {
+		settings.Reachability = &trafficv2.ReachabilitySettings{}
+	}
+	if mode := settings.Reachability.GetMode(); mode != trafficv2.ReachabilitySettings_CUSTOM && mode != trafficv2.ReachabilitySettings_UNSET {
+		if !slices.Contains(skippedHosts[groupFQN], host) {
+			skippedHosts[groupFQN] = append(skippedHosts[groupFQN], host)
+		}
+		explainf(explainPolicy, "TrafficSetting of %s: not allowing %s because of call %s, its reachability mode is %s",
+			groupFQN, host, call.ID, mode)
+		return
+	}
+	if slices.Contains(settings.Reachability.Hosts, host) {
+		return
+	}
+	settings.Reachability.Hosts = append(settings.Reachability.Hosts, host)
+	explainf(explainPolicy, "TrafficSetting of %s: allowing %s because of call %s from %s to %s",
+		groupFQN, host, call.ID, call.SourceService.FQN, call.TargetService.FQN)
+}
+
+// Generates the Sidecars and TrafficSettings for the calls in the graph. With aggregateBy "group", the destinations
+// of a BRIDGED group are aggregated across all of its source namespaces; with "namespace", they are deduplicated per
+// source namespace, shared with the DIRECT Sidecars in it.
+func generateSettings(client APIClient, graph *Graph, aggregateBy string) ([]*typesv2.Object, error) {
 	sidecars := make(map[string]*network1beta1.Sidecar)
 	// map[group FQN]*trafficv2.TrafficSetting
 	trafficSettings := make(map[string]*trafficv2.TrafficSetting)
@@ -262,6 +1656,11 @@ func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error)
 
 	// sourceNS => list of seen dest namespaces
 	seenNs := make(map[string][]string)
+	// group FQN => list of seen dest namespaces, with --aggregate-by=group
+	seenGroups := make(map[string][]string)
+	// group FQNs that can't be parsed, or whose TrafficSetting can't be decoded, already warned about
+	invalid := make(map[string]bool)
+	origins := make(hostOrigins)
 
 	for _, call := range graph.Calls {
 		debug("processing call: %+v", call)
@@ -269,19 +1668,50 @@ func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error)
 		if call.SourceTrafficGroup == nil {
 			continue
 		}
+		groupFQN := call.SourceTrafficGroup.FQN
+		if invalid[groupFQN] {
+			continue
+		}
+		// the groups in other modes were handled per --on-unknown-mode when building the graph
 		switch call.SourceTrafficGroup.ConfigMode {
-		case "DIRECT":
-			annotations := directModeAnnotations(call.SourceTrafficGroup.FQN)
-			generateDirectModeSidecars(call, seenNs, sidecars, annotations)
+		case configModeDirect:
+			annotations, err := directModeAnnotations(groupFQN)
+			if err != nil {
+				invalid[groupFQN] = true
+				diagnose(diagInvalidGroupFQN, call.SourceService.FQN, err)
+				continue
+			}
+			generateDirectModeSidecars(call, seenNs, sidecars, annotations, origins)
 		default:
-			meta := bridgedModeMeta(call.SourceTrafficGroup.FQN)
-			trafficMeta[call.SourceTrafficGroup.FQN] = meta
-			if err := generateBridgedModeTrafficSettings(client, call, seenNs, trafficSettings, meta); err != nil {
+			meta, err := bridgedModeMeta(groupFQN)
+			if err != nil {
+				invalid[groupFQN] = true
+				diagnose(diagInvalidGroupFQN, call.SourceService.FQN, err)
+				continue
+			}
+			trafficMeta[groupFQN] = meta
+			if aggregateBy == aggregateByGroup {
+				err = generateGroupTrafficSettings(client, call, seenGroups, trafficSettings, meta, origins)
+			} else {
+				err = generateBridgedModeTrafficSettings(client, call, seenNs, trafficSettings, meta, origins)
+			}
+			var decodeErr *settingsDecodeError
+			if errors.As(err, &decodeErr) {
+				// the group is skipped as a whole, rather than generated from the calls before the failing one
+				invalid[groupFQN] = true
+				delete(trafficSettings, groupFQN)
+				delete(trafficMeta, groupFQN)
+				undecodedGroups = append(undecodedGroups, groupFQN)
+				diagnose(diagSettingsDecode, groupFQN, decodeErr.err)
+				continue
+			}
+			if err != nil {
 				return nil, err
 			}
 		}
 
 	}
+	reportSkippedHosts(trafficSettings)
 
 	results := make([]*typesv2.Object, 0, len(sidecars)+len(trafficSettings))
 	for _, s := range sidecars {
@@ -293,7 +1723,7 @@ func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error)
 		}
 		newSidecar := &typesv2.Object{
 			Metadata: &typesv2.ObjectMeta{
-				Annotations: s.GetAnnotations(),
+				Annotations: origins.annotate(s.GetAnnotations(), s.GetNamespace(), s.Spec.Egress[0].Hosts),
 				Labels:      s.GetLabels(),
 				Namespace:   s.GetNamespace(),
 				Name:        s.GetName(),
@@ -305,14 +1735,19 @@ func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error)
 
 		results = append(results, newSidecar)
 	}
-	for _, t := range trafficSettings {
+	for groupFQN, t := range trafficSettings {
 		debug("process trafficsettings: %+v", t)
+		if t.GetFqn() == "" {
+			diagnose(diagEmptySettingsFQN, groupFQN)
+		}
 		any, err := anypb.New(t)
 		if err != nil {
 			return nil, fmt.Errorf("creating anypb: %w", err)
 		}
+		meta := trafficMeta[groupFQN]
+		meta.Annotations = origins.annotate(meta.Annotations, groupFQN, t.GetReachability().GetHosts())
 		newSidecar := &typesv2.Object{
-			Metadata:   trafficMeta[t.GetFqn()],
+			Metadata:   meta,
 			ApiVersion: api.TrafficAPI,
 			Kind:       api.TrafficSettingKind,
 			Spec:       any,
@@ -325,91 +1760,175 @@ func generateSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error)
 	return results, nil
 }
 
-func bridgedModeMeta(fqn string) *typesv2.ObjectMeta {
+// Parses a TSB FQN made of <kind>/<name> pairs, like organizations/o/tenants/t/workspaces/w/trafficgroups/g, into
+// the metadata of its hierarchy. Fails on odd segment counts, empty names, unknown kinds or a missing organization
+// rather than building partial metadata.
+func parseFQN(fqn string) (*typesv2.ObjectMeta, error) {
 	meta := &typesv2.ObjectMeta{}
 
 	fqnParts := strings.Split(fqn, "/")
+	if len(fqnParts)%2 != 0 {
+		return nil, fmt.Errorf("FQN %q has an odd number of segments", fqn)
+	}
 	for i := 0; i < len(fqnParts); i += 2 {
-		key := fqnParts[i]
+		key, value := fqnParts[i], fqnParts[i+1]
+		if value == "" {
+			return nil, fmt.Errorf("FQN %q has an empty name for %q", fqn, key)
+		}
 		switch key {
 		case "organizations":
-			meta.Organization = fqnParts[i+1]
+			meta.Organization = value
 		case "tenants":
-			meta.Tenant = fqnParts[i+1]
+			meta.Tenant = value
 		case "workspaces":
-			meta.Workspace = fqnParts[i+1]
+			meta.Workspace = value
 		case "trafficgroups":
-			meta.Group = fqnParts[i+1]
+			meta.Group = value
+		default:
+			return nil, fmt.Errorf("FQN %q has an unknown segment %q", fqn, key)
 		}
 	}
+	if meta.Organization == "" {
+		return nil, fmt.Errorf("FQN %q has no organization", fqn)
+	}
+	return meta, nil
+}
+
+func bridgedModeMeta(fqn string) (*typesv2.ObjectMeta, error) {
+	meta, err := parseFQN(fqn)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Tenant == "" || meta.Workspace == "" || meta.Group == "" {
+		return nil, fmt.Errorf("FQN %q is not the FQN of a traffic group", fqn)
+	}
 	debug("metadata for service %q: %+v", fqn, meta)
-	return meta
+	return meta, nil
 }
 
-func directModeAnnotations(fqn string) map[string]string {
-	annotations := make(map[string]string)
+func directModeAnnotations(fqn string) (map[string]string, error) {
+	meta, err := bridgedModeMeta(fqn)
+	if err != nil {
+		return nil, err
+	}
 
-	fqnParts := strings.Split(fqn, "/")
-	for i := 0; i < len(fqnParts); i += 2 {
-		key := fqnParts[i]
-		switch key {
-		case "organizations":
-			annotations["tsb.tetrate.io/organization"] = fqnParts[i+1]
-		case "tenants":
-			annotations["tsb.tetrate.io/tenant"] = fqnParts[i+1]
-		case "workspaces":
-			annotations["tsb.tetrate.io/workspace"] = fqnParts[i+1]
-		case "trafficgroups":
-			annotations["tsb.tetrate.io/trafficGroup"] = fqnParts[i+1]
-		}
+	annotations := make(map[string]string)
+	for _, a := range tsbHierarchyAnnotations {
+		annotations[a.key] = a.value(meta)
 	}
 	debug("annotations for service %q: %+v", fqn, annotations)
-	return annotations
+	return annotations, nil
 }
 
 type Graph struct {
-	Calls []*Call
+	Calls []*Call `json:"calls"`
+	// Every service in TSB, not only the ones in calls
+	Services []Service `json:"-"`
+	// Calls from intentionally excluded namespaces only, nothing is generated for (see --exclusions-file)
+	ExcludedCalls []*Call `json:"-"`
+	// Namespaces calls were observed from, before any was dropped or scoped out of the run
+	ObservedSources map[string]bool `json:"-"`
 }
 
 type Call struct {
-	SourceService      *Service
-	SourceNamespaces   []string
-	SourceTrafficGroup *TrafficGroup
+	// ID of the topology call this was built from
+	ID string `json:"id"`
+
+	SourceService      *Service      `json:"sourceService"`
+	SourceNamespaces   []string      `json:"sourceNamespaces"`
+	SourceTrafficGroup *TrafficGroup `json:"sourceTrafficGroup,omitempty"`
+
+	TargetService    *Service `json:"targetService"`
+	TargetNamespaces []string `json:"targetNamespaces"`
+
+	// Names of the topology nodes of the source and target, for their metrics
+	SourceNode string `json:"sourceNode,omitempty"`
+	TargetNode string `json:"targetNode,omitempty"`
+
+	// Clusters the source and target are deployed to in their namespaces
+	SourceClusters []string `json:"sourceClusters,omitempty"`
+	TargetClusters []string `json:"targetClusters,omitempty"`
 
-	TargetService    *Service
-	TargetNamespaces []string
+	// Components (protocols) the call was detected with, e.g. "http" or "tcp", on either side
+	Components []string `json:"components,omitempty"`
+	// Components the source and target sides of the call were detected with
+	SourceComponents []string `json:"sourceComponents,omitempty"`
+	TargetComponents []string `json:"targetComponents,omitempty"`
+	// Where the call was detected: CLIENT, SERVER or PROXY
+	DetectPoints []string `json:"detectPoints,omitempty"`
+	// SkyWalking layers of the source and target nodes, e.g. MESH
+	SourceLayers []string `json:"sourceLayers,omitempty"`
+	TargetLayers []string `json:"targetLayers,omitempty"`
+	// Whether the call was not observed, but is the reverse of an observed one (see --assume-bidirectional)
+	Mirrored bool `json:"mirrored,omitempty"`
+	// Whether the call was not observed, but reaches the east-west gateway for a cross-cluster call (see --east-west-namespace)
+	EastWest bool `json:"eastWest,omitempty"`
+	// Whether the target fails over to its deployments in other localities, so the call reaches all of them (see --include-failover)
+	Failover bool `json:"failover,omitempty"`
+	// Whether the call was not observed, but declared in --extra-edges, and why
+	Manual       bool   `json:"manual,omitempty"`
+	ManualReason string `json:"manualReason,omitempty"`
+	// When the traffic of the call happens: business-hours, nightly-batch or constant (see --time-of-day)
+	TimeOfDay string `json:"timeOfDay,omitempty"`
+	// Source namespaces intentionally excluded, removed from SourceNamespaces (see --exclusions-file)
+	ExcludedNamespaces []string `json:"-"`
 }
 
 // Normalizes the topology response and service list into a Graph of source namespace to set of target namespace
-func buildGraph(runtime *Runtime, top *TopologyResponse, services []Service) *Graph {
+func buildGraph(runtime *Runtime, top *TopologyResponse, services []Service, servicesByTopKey map[string]*Service) (*Graph, error) {
 	graph := &Graph{
-		Calls: make([]*Call, 0),
+		Calls:    make([]*Call, 0),
+		Services: services,
 	}
 
-	servicesByTopKey := make(map[string]*Service)
-	for _, svc := range services {
-		local := svc
-		for _, metric := range svc.Metrics {
-			debug("service %q has FQN %q", metric.AggregationKey, local.FQN)
-			servicesByTopKey[metric.AggregationKey] = &local
-		}
+	servicesByFQN := make(map[string]*Service)
+	for i := range services {
+		servicesByFQN[services[i].FQN] = &services[i]
 	}
 
 	idToTopKey := make(map[string]string)
+	idToLayers := make(map[string][]string)
 	for _, node := range top.Nodes {
+		if !inLayers(runtime.layers, node.Layers) {
+			debug("node ID %q (%q) is in layers %v, skipping", node.ID, node.AggregationKey, node.Layers)
+			explainf(explainGraph, "node %q: skipped, its layers %v are not in --layers", node.AggregationKey, node.Layers)
+			continue
+		}
+		if !nodeTypeAllowed(runtime.includeNodeTypes, runtime.excludeNodeTypes, node.Type) {
+			debug("node ID %q (%q) is of type %q, skipping", node.ID, node.AggregationKey, node.Type)
+			explainf(explainGraph, "node %q: skipped, its type %q is excluded by --include-node-types or --exclude-node-types", node.AggregationKey, node.Type)
+			continue
+		}
 		debug("node ID %q belongs to %q", node.ID, node.AggregationKey)
 		idToTopKey[node.ID] = node.AggregationKey
+		idToLayers[node.ID] = node.Layers
 	}
 
 	servicesByID := make(map[string]*Service)
+	proposals := make(map[string]*mappingProposal)
 	for id, key := range idToTopKey {
 		if svc, ok := servicesByTopKey[key]; ok {
 			servicesByID[id] = svc
 			debug("id %q maps to service %q", id, svc.FQN)
+		} else if svc, ok := mappedService(runtime, servicesByFQN, key); ok {
+			servicesByID[id] = svc
+			debug("id %q is mapped to service %q by --mapping-file", id, svc.FQN)
+		} else if p := proposeMapping(runtime, servicesByFQN, key); p != nil {
+			if runtime.autoMapConfidence > 0 && p.Confidence >= runtime.autoMapConfidence {
+				servicesByID[id] = p.Service
+				diagnose(diagHeuristicMapping, key, p.Service.FQN, p.Confidence)
+			} else {
+				proposals[key] = p
+			}
 		} else {
 			debug("no service for key %q", key)
 		}
 	}
+	reportMappingProposals(proposals)
+
+	// nodes in the layers that calls were dropped for, as they have no service
+	unresolved := make(map[string]bool)
+	defer reportUnresolvedNodes(unresolved)
 
 	for _, traffic := range top.Calls {
 		debug("processing call %s", traffic.ID)
@@ -417,39 +1936,109 @@ func buildGraph(runtime *Runtime, top *TopologyResponse, services []Service) *Gr
 		source, ok := servicesByID[traffic.Source]
 		if !ok {
 			debug("no service for key %s", traffic.Source)
+			if key, inLayers := idToTopKey[traffic.Source]; inLayers {
+				unresolved[key] = true
+			}
+			explainf(explainGraph, "call %s: skipped, no TSB service for source node %q", traffic.ID, idToTopKey[traffic.Source])
 			continue
 		}
 		target, ok := servicesByID[traffic.Target]
 		if !ok {
 			debug("no service for key %s", traffic.Target)
+			if key, inLayers := idToTopKey[traffic.Target]; inLayers {
+				unresolved[key] = true
+			}
+			explainf(explainGraph, "call %s: skipped, no TSB service for target node %q", traffic.ID, idToTopKey[traffic.Target])
 			continue
 		}
 		debug("computed source => target: %s => %s", source.FQN, target.FQN)
 
-		call := &Call{
-			SourceService: source,
-			TargetService: target,
+		call, err := newCall(runtime, traffic.ID, source, target, idToTopKey[traffic.Source], idToTopKey[traffic.Target])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the traffic group of %s: %w", source.FQN, err)
 		}
+		call.Components = append(slices.Clone(traffic.SourceComponents), traffic.TargetComponents...)
+		call.SourceComponents, call.TargetComponents = traffic.SourceComponents, traffic.TargetComponents
+		call.DetectPoints = traffic.DetectPoints
+		call.SourceLayers, call.TargetLayers = idToLayers[traffic.Source], idToLayers[traffic.Target]
+		graph.Calls = append(graph.Calls, call)
+		explainf(explainGraph, "call %s: %s (namespaces %v) => %s (namespaces %v)",
+			call.ID, serviceName(runtime.nameStyle, source), call.SourceNamespaces, serviceName(runtime.nameStyle, target), call.TargetNamespaces)
 
-		srcNamespaces := parseNamespace(source)
-		call.SourceNamespaces = srcNamespaces
-		targetNamespaces := parseNamespace(target)
-		call.TargetNamespaces = targetNamespaces
-
-		tg, err := runtime.client.LookupTrafficGroup(source)
+		eastWest, err := eastWestCalls(runtime, call, idToTopKey[traffic.Source], idToTopKey[traffic.Target])
 		if err != nil {
-			debug("error getting traffic group for %s: %w", source.FQN, err)
-			return nil
+			return nil, fmt.Errorf("failed to get the east-west calls of %s: %w", call.ID, err)
 		}
-		if tg == nil {
-			fmt.Fprintf(os.Stderr, "no trafficgroup found for source service %q, skipping...\n", source.FQN)
-		}
-		call.SourceTrafficGroup = tg
+		graph.Calls = append(graph.Calls, eastWest...)
 
-		graph.Calls = append(graph.Calls, call)
+		if shouldMirror(runtime, call) {
+			debug("mirroring call %s as %s => %s", call.ID, target.FQN, source.FQN)
+			mirrored, err := newCall(runtime, call.ID, target, source, idToTopKey[traffic.Target], idToTopKey[traffic.Source])
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the traffic group of %s: %w", target.FQN, err)
+			}
+			mirrored.Components = call.Components
+			mirrored.SourceComponents, mirrored.TargetComponents = call.TargetComponents, call.SourceComponents
+			mirrored.DetectPoints = call.DetectPoints
+			mirrored.SourceLayers, mirrored.TargetLayers = call.TargetLayers, call.SourceLayers
+			mirrored.Mirrored = true
+			graph.Calls = append(graph.Calls, mirrored)
+			explainf(explainGraph, "call %s: mirrored as %s => %s", call.ID, serviceName(runtime.nameStyle, target), serviceName(runtime.nameStyle, source))
+		}
 	}
 	debug("graph built")
-	return graph
+	return graph, nil
+}
+
+// Builds the call from source to target, looking up the traffic group of the source. The topology keys of
+// the source and target nodes are used to narrow down their namespaces with --attribute-by-deployment.
+func newCall(runtime *Runtime, id string, source, target *Service, sourceKey, targetKey string) (*Call, error) {
+	call := &Call{
+		ID:               id,
+		SourceService:    source,
+		SourceNamespaces: parseNamespace(source),
+		TargetService:    target,
+		TargetNamespaces: parseNamespace(target),
+		SourceNode:       sourceKey,
+		TargetNode:       targetKey,
+	}
+	if runtime.attributeByDeployment {
+		call.SourceNamespaces = attributeNamespaces(source, sourceKey)
+		call.TargetNamespaces = attributeNamespaces(target, targetKey)
+	}
+	call.SourceClusters = callClusters(runtime, source, sourceKey, call.SourceNamespaces)
+	call.TargetClusters = callClusters(runtime, target, targetKey, call.TargetNamespaces)
+	includeFailover(runtime, call)
+
+	tg, err := runtime.client.LookupTrafficGroup(source)
+	if err != nil {
+		return nil, err
+	}
+	if tg == nil && !runtime.createGroups {
+		diagnose(diagNoTrafficGroup, source.FQN)
+	}
+	call.SourceTrafficGroup = tg
+	return call, nil
+}
+
+// Returns whether a node in the given topology layers should be considered. Nodes without layer information
+// (from telemetry that doesn't report it) are always considered, as there is nothing to filter them on.
+func inLayers(allowed, layers []string) bool {
+	return len(allowed) == 0 || len(layers) == 0 || containsAny(allowed, layers)
+}
+
+// Returns whether a node of the given type should be considered: it isn't one of the excluded types, and is one of
+// the included ones if there are any. Types are compared regardless of case, as SkyWalking's vary. Nodes without a
+// type are always considered, like the ones without layers.
+func nodeTypeAllowed(include, exclude []string, nodeType string) bool {
+	if nodeType == "" {
+		return true
+	}
+	matches := func(t string) bool { return strings.EqualFold(t, nodeType) }
+	if slices.ContainsFunc(exclude, matches) {
+		return false
+	}
+	return len(include) == 0 || slices.ContainsFunc(include, matches)
 }
 
 func parseNamespace(service *Service) []string {
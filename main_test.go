@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseFQN(t *testing.T) {
+	tests := []struct {
+		name    string
+		fqn     string
+		want    [4]string // organization, tenant, workspace, group
+		wantErr bool
+	}{
+		{name: "group", fqn: "organizations/o/tenants/t/workspaces/w/trafficgroups/g", want: [4]string{"o", "t", "w", "g"}},
+		{name: "workspace", fqn: "organizations/o/tenants/t/workspaces/w", want: [4]string{"o", "t", "w", ""}},
+		{name: "organization only", fqn: "organizations/o", want: [4]string{"o", "", "", ""}},
+		{name: "odd segments", fqn: "organizations/o/tenants", wantErr: true},
+		{name: "empty name", fqn: "organizations/o/tenants/", wantErr: true},
+		{name: "unknown kind", fqn: "organizations/o/clusters/c", wantErr: true},
+		{name: "no organization", fqn: "tenants/t/workspaces/w", wantErr: true},
+		{name: "empty", fqn: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := parseFQN(tt.fqn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFQN(%q) error = %v, wantErr %v", tt.fqn, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := [4]string{meta.Organization, meta.Tenant, meta.Workspace, meta.Group}
+			if got != tt.want {
+				t.Errorf("parseFQN(%q) = %v, want %v", tt.fqn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeTypeAllowed(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		nodeType         string
+		want             bool
+	}{
+		{name: "no filters", nodeType: "Envoy", want: true},
+		{name: "excluded", exclude: []string{"USER"}, nodeType: "USER", want: false},
+		{name: "excluded regardless of case", exclude: []string{"user"}, nodeType: "USER", want: false},
+		{name: "not excluded", exclude: []string{"USER"}, nodeType: "Envoy", want: true},
+		{name: "included", include: []string{"envoy", "http"}, nodeType: "Envoy", want: true},
+		{name: "not included", include: []string{"http"}, nodeType: "Envoy", want: false},
+		{name: "included and excluded", include: []string{"Envoy"}, exclude: []string{"Envoy"}, nodeType: "Envoy", want: false},
+		{name: "no type", include: []string{"http"}, exclude: []string{""}, nodeType: "", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeTypeAllowed(tt.include, tt.exclude, tt.nodeType); got != tt.want {
+				t.Errorf("nodeTypeAllowed(%v, %v, %q) = %v, want %v", tt.include, tt.exclude, tt.nodeType, got, tt.want)
+			}
+		})
+	}
+}
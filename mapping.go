@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// Reads the --mapping-file, a YAML map of topology node names to the FQN of the TSB service they are, for the nodes
+// whose name matches no aggregation key, e.g. after a service is renamed:
+//
+//	payments|payments-v2|cluster-1|-: organizations/tetrate/services/payments
+func loadServiceMappings(path string) (map[string]string, error) {
+	data, err := readInput("--mapping-file", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --mapping-file %q: %w", inputName(path), err)
+	}
+	var mappings map[string]string
+	if err := yaml.UnmarshalStrict(data, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse --mapping-file %q: %w", inputName(path), err)
+	}
+	return mappings, nil
+}
+
+// Returns the service the --mapping-file maps the topology node name to, if any
+func mappedService(runtime *Runtime, servicesByFQN map[string]*Service, key string) (*Service, bool) {
+	fqn, ok := runtime.serviceMappings[key]
+	if !ok {
+		return nil, false
+	}
+	svc, ok := servicesByFQN[fqn]
+	if !ok {
+		debug("--mapping-file maps node %q to unknown service %q", key, fqn)
+	}
+	return svc, ok
+}
+
+// Reports the topology nodes that took part in calls but resolved to no service, so their calls were dropped
+func reportUnresolvedNodes(unresolved map[string]bool) {
+	if len(unresolved) == 0 {
+		return
+	}
+	keys := maps.Keys(unresolved)
+	slices.Sort(keys)
+	diagnose(diagUnresolvedNodes, strings.Join(keys, "\n  "))
+}
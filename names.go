@@ -0,0 +1,25 @@
+package main
+
+// Values of --name-style
+const (
+	nameStyleFQN       = "fqn"
+	nameStyleDisplay   = "display"
+	nameStyleCanonical = "canonical"
+)
+
+// Returns the name of the service to show people in the given --name-style, falling back to the canonical name
+// and then the FQN for services without the preferred one. Machine output keeps using the FQN.
+func serviceName(style string, svc *Service) string {
+	switch style {
+	case nameStyleDisplay:
+		if svc.DisplayName != "" {
+			return svc.DisplayName
+		}
+		fallthrough
+	case nameStyleCanonical:
+		if svc.CanonicalName != "" {
+			return svc.CanonicalName
+		}
+	}
+	return svc.FQN
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ociScheme            = "oci://"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// the media types of Flux OCI artifacts, which Flux OCIRepository sources and Argo CD understand
+	ociConfigMediaType  = "application/vnd.cncf.flux.config.v1+json"
+	ociContentMediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+
+	// files of the bundle
+	ociResourcesFile = "resources.yaml"
+	ociReportFile    = "report.csv"
+)
+
+// client of the registries, so an unresponsive one fails the push instead of hanging the run
+var ociClient = &http.Client{Timeout: 5 * time.Minute}
+
+// A --push reference, oci://<registry>/<repository>:<tag>
+type ociReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+func (r *ociReference) String() string {
+	return fmt.Sprintf("%s%s/%s:%s", ociScheme, r.Registry, r.Repository, r.Tag)
+}
+
+// Parses a --push reference. It must have a tag, so that every bundle is versioned.
+func parseOCIReference(ref string) (*ociReference, error) {
+	rest, ok := strings.CutPrefix(ref, ociScheme)
+	if !ok {
+		return nil, fmt.Errorf("invalid --push %q, must be %s<registry>/<repository>:<tag>", ref, ociScheme)
+	}
+	registry, repoTag, ok := strings.Cut(rest, "/")
+	if !ok || registry == "" {
+		return nil, fmt.Errorf("invalid --push %q, it has no registry", ref)
+	}
+	i := strings.LastIndex(repoTag, ":")
+	if i <= 0 || i == len(repoTag)-1 {
+		return nil, fmt.Errorf("invalid --push %q, it has no tag", ref)
+	}
+	return &ociReference{Registry: registry, Repository: repoTag[:i], Tag: repoTag[i+1:]}, nil
+}
+
+// An OCI content descriptor
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func newOCIDescriptor(mediaType string, data []byte) ociDescriptor {
+	sum := sha256.Sum256(data)
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: len(data)}
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Packages the resources and the report of the run as an OCI artifact and pushes it to the --push reference. The
+// artifact has a single layer, a tar.gz with the resources YAML and the report CSV, in the format of Flux artifacts.
+func pushBundle(runtime *Runtime, previous *State, graph *Graph, results []*typesv2.Object, revision string) error {
+	var resources, report bytes.Buffer
+	if err := writeResourcesYAML(&resources, results, runtime.sidecarOutput); err != nil {
+		return err
+	}
+	if err := writeReportCSV(&report, reportRows(runtime, previous, graph, nameStyleFQN, nil), false, runtime.timeOfDay); err != nil {
+		return err
+	}
+	content, err := tarGzip(map[string][]byte{ociResourcesFile: resources.Bytes(), ociReportFile: report.Bytes()})
+	if err != nil {
+		return fmt.Errorf("failed to package bundle: %w", err)
+	}
+
+	config := []byte("{}")
+	manifest := &ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Config:        newOCIDescriptor(ociConfigMediaType, config),
+		Layers:        []ociDescriptor{newOCIDescriptor(ociContentMediaType, content)},
+		Annotations: map[string]string{
+			"org.opencontainers.image.created":  time.Now().UTC().Format(time.RFC3339),
+			"org.opencontainers.image.revision": revision,
+			"org.opencontainers.image.source":   "generate-sidecar-tool",
+		},
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if runtime.dryRun != dryRunNone {
+		fmt.Fprintf(logOut, "would push %s@%s (dry run)\n", runtime.push, newOCIDescriptor(ociManifestMediaType, data).Digest)
+		return nil
+	}
+
+	registry, err := newOCIRegistry(runtime.push, runtime.pushPlainHTTP)
+	if err != nil {
+		return err
+	}
+	for _, blob := range []struct {
+		desc ociDescriptor
+		data []byte
+	}{{manifest.Config, config}, {manifest.Layers[0], content}} {
+		if err := registry.pushBlob(blob.desc, blob.data); err != nil {
+			return fmt.Errorf("failed to push %s: %w", runtime.push, err)
+		}
+	}
+	digest, err := registry.pushManifest(data)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", runtime.push, err)
+	}
+	fmt.Fprintf(logOut, "pushed %s@%s\n", runtime.push, digest)
+	return nil
+}
+
+// Returns a tar.gz with the files, sorted by name. The files have no timestamps, so the same content always gives the
+// same digest.
+func tarGzip(files map[string][]byte) ([]byte, error) {
+	names := maps.Keys(files)
+	slices.Sort(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: time.Unix(0, 0), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A client of the OCI distribution API of a registry, for one repository
+type ociRegistry struct {
+	ref    *ociReference
+	scheme string
+	// credentials of the registry in the docker config, if any
+	auth string
+	// bearer token of the repository, once the registry challenged for one
+	token string
+}
+
+func newOCIRegistry(ref *ociReference, plainHTTP bool) (*ociRegistry, error) {
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+	auth, err := dockerAuth(ref.Registry)
+	if err != nil {
+		return nil, err
+	}
+	addSecret(auth)
+	return &ociRegistry{ref: ref, scheme: scheme, auth: auth}, nil
+}
+
+// Returns the base64 user:password of the registry in the docker config ($DOCKER_CONFIG/config.json or
+// ~/.docker/config.json), as `docker login` stores it, or "" if there is none. The credential helper of the registry
+// (credHelpers) or the default one (credsStore) is asked first, like docker does.
+func dockerAuth(registry string) (string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", nil
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		warn("ignoring invalid docker config: %v", err)
+		return "", nil
+	}
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	helper := config.CredsStore
+	for _, key := range keys {
+		if h, ok := config.CredHelpers[key]; ok {
+			helper = h
+			break
+		}
+	}
+	if helper != "" {
+		return helperAuth(helper, registry)
+	}
+	for _, key := range keys {
+		if a, ok := config.Auths[key]; ok {
+			return a.Auth, nil
+		}
+	}
+	return "", nil
+}
+
+// Returns the base64 user:password the docker credential helper has for the registry, or "" if it has none
+func helperAuth(helper, registry string) (string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// helpers report the registries they have no credentials for on stdout
+		if strings.Contains(string(out), "credentials not found") {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get the credentials of %s from docker-credential-%s: %w: %s", registry, helper, err, stderr.String())
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", fmt.Errorf("failed to parse the credentials of docker-credential-%s: %w", helper, err)
+	}
+	addSecret(creds.Secret)
+	return base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Secret)), nil
+}
+
+func (r *ociRegistry) url(path string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s", r.scheme, r.ref.Registry, r.ref.Repository, path)
+}
+
+// Uploads the blob, unless the registry has it already
+func (r *ociRegistry) pushBlob(desc ociDescriptor, data []byte) error {
+	resp, err := r.do(http.MethodHead, r.url("blobs/"+desc.Digest), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		debug("blob %s exists already", desc.Digest)
+		return nil
+	}
+
+	resp, err = r.do(http.MethodPost, r.url("blobs/uploads/"), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start the upload of blob %s: unexpected status %d", desc.Digest, resp.StatusCode)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location %q: %w", resp.Header.Get("Location"), err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = r.do(http.MethodPut, location.String(), "application/octet-stream", data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload blob %s: unexpected status %d", desc.Digest, resp.StatusCode)
+	}
+	debug("uploaded blob %s", desc.Digest)
+	return nil
+}
+
+// Uploads the manifest with the tag of the reference, and returns its digest
+func (r *ociRegistry) pushManifest(data []byte) (string, error) {
+	resp, err := r.do(http.MethodPut, r.url("manifests/"+r.ref.Tag), ociManifestMediaType, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to upload manifest: unexpected status %d: %s", resp.StatusCode, body)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return newOCIDescriptor(ociManifestMediaType, data).Digest, nil
+}
+
+// Sends the request, authenticating as the registry challenges for: with the docker config credentials for Basic,
+// or with a token from the realm of a Bearer challenge, kept for the next requests
+func (r *ociRegistry) do(method, url, contentType string, body []byte) (*http.Response, error) {
+	resp, err := r.send(method, url, contentType, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.auth == "" {
+			return nil, fmt.Errorf("the registry requires credentials, log in to %s with docker login", r.ref.Registry)
+		}
+	case "bearer":
+		if r.token, err = r.fetchToken(parseChallenge(params)); err != nil {
+			return nil, err
+		}
+		addSecret(r.token)
+	default:
+		return nil, fmt.Errorf("unsupported registry authentication challenge %q", challenge)
+	}
+	return r.send(method, url, contentType, body)
+}
+
+func (r *ociRegistry) send(method, url, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.auth != "":
+		req.Header.Set("Authorization", "Basic "+r.auth)
+	}
+	resp, err := ociClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	return resp, nil
+}
+
+// Gets a token with push access to the repository from the realm of a Bearer challenge
+func (r *ociRegistry) fetchToken(challenge map[string]string) (string, error) {
+	realm, err := url.Parse(challenge["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry token realm %q", challenge["realm"])
+	}
+	query := realm.Query()
+	if service := challenge["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull,push", r.ref.Repository))
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if r.auth != "" {
+		req.Header.Set("Authorization", "Basic "+r.auth)
+	}
+	resp, err := ociClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get registry token: unexpected status %d", resp.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse registry token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// Parses the key="value" parameters of a WWW-Authenticate challenge
+func parseChallenge(params string) map[string]string {
+	out := make(map[string]string)
+	for len(params) > 0 {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(key), ","))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		out[key] = value
+		params = rest
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    ociReference
+		wantErr bool
+	}{
+		{ref: "oci://ghcr.io/acme/reachability:v1", want: ociReference{Registry: "ghcr.io", Repository: "acme/reachability", Tag: "v1"}},
+		{ref: "oci://localhost:5000/reachability:20240101", want: ociReference{Registry: "localhost:5000", Repository: "reachability", Tag: "20240101"}},
+		{ref: "ghcr.io/acme/reachability:v1", wantErr: true},
+		{ref: "oci://ghcr.io", wantErr: true},
+		{ref: "oci:///acme/reachability:v1", wantErr: true},
+		{ref: "oci://ghcr.io/acme/reachability", wantErr: true},
+		{ref: "oci://ghcr.io/acme/reachability:", wantErr: true},
+		{ref: "oci://ghcr.io/:v1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := parseOCIReference(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOCIReference(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if *got != tt.want {
+				t.Errorf("parseOCIReference(%q) = %+v, want %+v", tt.ref, *got, tt.want)
+			}
+			if got.String() != tt.ref {
+				t.Errorf("parseOCIReference(%q).String() = %q", tt.ref, got.String())
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		want   map[string]string
+	}{
+		{
+			name:   "quoted",
+			params: `realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:acme/reachability:pull,push"`,
+			want: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:acme/reachability:pull,push",
+			},
+		},
+		{name: "unquoted", params: `realm=https://ghcr.io/token, service=ghcr.io`, want: map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io"}},
+		{name: "empty", params: "", want: map[string]string{}},
+		{name: "unterminated quote", params: `realm="https://ghcr.io/token`, want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseChallenge(tt.params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChallenge(%q) = %v, want %v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+// An in-memory registry for one repository, requiring a bearer token like most public registries
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	requests  int
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.requests++
+	if r.URL.Path == "/token" {
+		if r.URL.Query().Get("scope") != "repository:acme/reachability:pull,push" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "push-token"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer push-token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/v2/acme/reachability/"
+	path, ok := strings.CutPrefix(r.URL.Path, prefix)
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodHead && strings.HasPrefix(path, "blobs/sha256:"):
+		if _, ok := reg.blobs[strings.TrimPrefix(path, "blobs/")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPost && path == "blobs/uploads/":
+		w.Header().Set("Location", prefix+"blobs/uploads/1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && path == "blobs/uploads/1":
+		sum := sha256.Sum256(body)
+		digest := r.URL.Query().Get("digest")
+		if digest != "sha256:"+hex.EncodeToString(sum[:]) || r.URL.Query().Get("state") != "x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blobs[digest] = body
+		reg.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "manifests/"):
+		if r.Header.Get("Content-Type") != ociManifestMediaType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.manifests[strings.TrimPrefix(path, "manifests/")] = body
+		sum := sha256.Sum256(body)
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns the files of the tar.gz layer
+func untarGzip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+}
+
+func TestPushBundle(t *testing.T) {
+	config := []byte("{}")
+	tests := []struct {
+		name         string
+		dryRun       string
+		configExists bool
+		wantUploads  int
+	}{
+		{name: "push", dryRun: dryRunNone, wantUploads: 2},
+		{name: "existing blob", dryRun: dryRunNone, configExists: true, wantUploads: 1},
+		{name: "dry run", dryRun: dryRunClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no credentials in the docker config
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+			reg := &testRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+			if tt.configExists {
+				reg.blobs[newOCIDescriptor(ociConfigMediaType, config).Digest] = config
+			}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+
+			ref := &ociReference{Registry: strings.TrimPrefix(server.URL, "http://"), Repository: "acme/reachability", Tag: "v1"}
+			runtime := &Runtime{push: ref, pushPlainHTTP: true, dryRun: tt.dryRun, sidecarOutput: sidecarOutputK8s}
+			var err error
+			log := captureLog(t, func() {
+				err = pushBundle(runtime, nil, &Graph{}, []*typesv2.Object{testSidecar(t, "a", "istio-system/*")}, "abc123")
+			})
+			if err != nil {
+				t.Fatalf("pushBundle() error = %v", err)
+			}
+			if reg.uploads != tt.wantUploads {
+				t.Errorf("uploaded %d blobs, want %d", reg.uploads, tt.wantUploads)
+			}
+			if tt.dryRun != dryRunNone {
+				if reg.requests != 0 || !strings.Contains(log, "(dry run)") {
+					t.Errorf("dry run sent %d requests and logged %q, want none and the digest it would push", reg.requests, log)
+				}
+				return
+			}
+
+			manifest := &ociManifest{}
+			if err := json.Unmarshal(reg.manifests["v1"], manifest); err != nil {
+				t.Fatalf("failed to parse the pushed manifest: %v", err)
+			}
+			if manifest.Annotations["org.opencontainers.image.revision"] != "abc123" {
+				t.Errorf("manifest annotations = %v, want the revision", manifest.Annotations)
+			}
+			layer, ok := reg.blobs[manifest.Layers[0].Digest]
+			if !ok {
+				t.Fatalf("the layer %s of the manifest wasn't pushed", manifest.Layers[0].Digest)
+			}
+			files := untarGzip(t, layer)
+			if !strings.Contains(files[ociResourcesFile], "istio-system/*") {
+				t.Errorf("%s = %q, want the resources", ociResourcesFile, files[ociResourcesFile])
+			}
+			if _, ok := files[ociReportFile]; !ok {
+				t.Errorf("the layer has no %s", ociReportFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	autoOrgSingle = "single"
+	autoOrgAll    = "all"
+)
+
+// Returns the organizations to query according to the --auto-org mode
+func discoverOrgs(client APIClient, mode string) ([]string, error) {
+	if mode != autoOrgSingle && mode != autoOrgAll {
+		return nil, fmt.Errorf("invalid --auto-org %q, must be one of %q or %q", mode, autoOrgSingle, autoOrgAll)
+	}
+
+	orgs, err := client.ListOrganizations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover organizations: %w", err)
+	}
+	debug("organizations visible to the credentials: %v", orgs)
+
+	switch {
+	case len(orgs) == 0:
+		return nil, fmt.Errorf("the credentials can't see any organization; check the user has access to TSB")
+	case len(orgs) > 1 && mode == autoOrgSingle:
+		return nil, fmt.Errorf("the credentials can see %d organizations (%s); pick one with --org, or use --auto-org=all to query all of them",
+			len(orgs), strings.Join(orgs, ", "))
+	}
+	return orgs, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"istio.io/api/networking/v1beta1"
+)
+
+// Origins of the hosts of the generated Sidecars and TrafficSettings, from the highest priority to the lowest: a
+// host allowed for several reasons gets the first of them
+const (
+	// the baselineHosts every object allows
+	originBaseline = "baseline"
+	// allowed by a call of the --extra-edges, or added by hand to the live object
+	originManual = "manual"
+	// allowed by an observed call, or one derived from them
+	originObserved = "observed"
+	// in the existing TrafficSetting of the group, but needed by no call
+	originPreExisting = "pre-existing"
+)
+
+var originPriority = []string{originBaseline, originManual, originObserved, originPreExisting}
+
+// Annotation of the generated Sidecars and TrafficSettings with the origin of each of their hosts, as a JSON object
+const hostOriginsAnnotation = "generate-sidecar-tool/host-origins"
+
+// Origins of the hosts the calls allow, by namespace for Sidecars and group FQN for TrafficSettings, then by host.
+// The baseline and pre-existing hosts aren't recorded: they're told apart when annotating the objects.
+type hostOrigins map[string]map[string]string
+
+// Records that the call allows the host in the object of the key, unless the host has an origin of a higher priority
+func (o hostOrigins) addCall(key, host string, call *Call) {
+	origin := originObserved
+	if call.Manual {
+		origin = originManual
+	}
+	if o[key] == nil {
+		o[key] = make(map[string]string)
+	}
+	if current, ok := o[key][host]; !ok || slices.Index(originPriority, origin) < slices.Index(originPriority, current) {
+		o[key][host] = origin
+	}
+}
+
+// Returns a copy of the annotations with the origins of the hosts of the object of the key
+func (o hostOrigins) annotate(annotations map[string]string, key string, hosts []string) map[string]string {
+	origins := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		switch {
+		case slices.Contains(baselineHosts, host):
+			origins[host] = originBaseline
+		case o[key][host] != "":
+			origins[host] = o[key][host]
+		default:
+			origins[host] = originPreExisting
+		}
+	}
+	out := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		out[k] = v
+	}
+	data, _ := json.Marshal(origins)
+	out[hostOriginsAnnotation] = string(data)
+	return out
+}
+
+// Adds to the generated Sidecar or TrafficSetting the hosts of the live one that applying it would prune but must be
+// kept: the ones the live object records as baseline or manual, and the ones added to it by hand since it was
+// generated, which have no origin recorded. Only the hosts that were observed or pre-existing are pruned. The hosts of
+// live objects generated before their origins were recorded are pruned as they always were. Returns whether the
+// object changed.
+func keepProtectedHosts(obj *typesv2.Object, live *hostsObject) (bool, error) {
+	value, ok := obj.GetMetadata().GetAnnotations()[hostOriginsAnnotation]
+	liveValue, liveOk := live.Metadata.Annotations[hostOriginsAnnotation]
+	if !ok || !liveOk {
+		return false, nil
+	}
+	origins, liveOrigins := make(map[string]string), make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &origins); err != nil {
+		return false, fmt.Errorf("failed to parse the %s annotation of %s %s: %w", hostOriginsAnnotation, obj.GetKind(), objectName(obj), err)
+	}
+	if err := json.Unmarshal([]byte(liveValue), &liveOrigins); err != nil {
+		return false, fmt.Errorf("failed to parse the %s annotation of the live %s %s: %w", hostOriginsAnnotation, obj.GetKind(), objectName(obj), err)
+	}
+
+	var msg proto.Message
+	var hosts *[]string
+	switch obj.GetKind() {
+	case api.IstioSidecarKind:
+		sidecar := &v1beta1.Sidecar{}
+		if err := obj.GetSpec().UnmarshalTo(sidecar); err != nil {
+			return false, fmt.Errorf("failed to unmarshal Sidecar %s: %w", objectName(obj), err)
+		}
+		if len(sidecar.Egress) == 0 {
+			return false, nil
+		}
+		msg, hosts = sidecar, &sidecar.Egress[0].Hosts
+	case api.TrafficSettingKind:
+		settings := &trafficv2.TrafficSetting{}
+		if err := obj.GetSpec().UnmarshalTo(settings); err != nil {
+			return false, fmt.Errorf("failed to unmarshal TrafficSetting %s: %w", objectName(obj), err)
+		}
+		if settings.Reachability == nil {
+			return false, nil
+		}
+		msg, hosts = settings, &settings.Reachability.Hosts
+	default:
+		return false, nil
+	}
+
+	kept := false
+	for _, host := range live.hosts() {
+		if slices.Contains(*hosts, host) {
+			continue
+		}
+		origin, ok := liveOrigins[host]
+		if !ok {
+			origin = originManual
+		}
+		if origin != originBaseline && origin != originManual {
+			debug("%s %s: pruning %s, its origin is %s", obj.GetKind(), objectName(obj), host, origin)
+			continue
+		}
+		*hosts = append(*hosts, host)
+		origins[host] = origin
+		kept = true
+		explainf(explainPolicy, "%s %s: keeping %s, no longer needed but its origin is %s", obj.GetKind(), objectName(obj), host, origin)
+	}
+	if !kept {
+		return false, nil
+	}
+
+	spec, err := anypb.New(msg)
+	if err != nil {
+		return false, fmt.Errorf("creating anypb: %w", err)
+	}
+	obj.Spec = spec
+	data, _ := json.Marshal(origins)
+	obj.Metadata.Annotations[hostOriginsAnnotation] = string(data)
+	return true, nil
+}
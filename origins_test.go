@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"istio.io/api/networking/v1beta1"
+)
+
+func TestKeepProtectedHosts(t *testing.T) {
+	tests := []struct {
+		name        string
+		liveOrigins string
+		liveHosts   string
+		wantChanged bool
+		wantHosts   []string
+	}{
+		{
+			name:        "pruned observed host",
+			liveOrigins: `{"istio-system/*":"baseline","b/*":"observed","c/*":"observed"}`,
+			liveHosts:   "[istio-system/*, b/*, c/*]",
+			wantHosts:   []string{"istio-system/*", "b/*"},
+		},
+		{
+			name:        "pruned pre-existing host",
+			liveOrigins: `{"istio-system/*":"baseline","b/*":"observed","c/*":"pre-existing"}`,
+			liveHosts:   "[istio-system/*, b/*, c/*]",
+			wantHosts:   []string{"istio-system/*", "b/*"},
+		},
+		{
+			name:        "kept manual host",
+			liveOrigins: `{"istio-system/*":"baseline","b/*":"observed","c/*":"manual"}`,
+			liveHosts:   "[istio-system/*, b/*, c/*]",
+			wantChanged: true,
+			wantHosts:   []string{"istio-system/*", "b/*", "c/*"},
+		},
+		{
+			name:        "kept host added by hand",
+			liveOrigins: `{"istio-system/*":"baseline","b/*":"observed"}`,
+			liveHosts:   "[istio-system/*, b/*, d/*]",
+			wantChanged: true,
+			wantHosts:   []string{"istio-system/*", "b/*", "d/*"},
+		},
+		{
+			name:      "live object without origins",
+			liveHosts: "[istio-system/*, b/*, d/*]",
+			wantHosts: []string{"istio-system/*", "b/*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testSidecar(t, "a", "istio-system/*", "b/*")
+			obj.Metadata.Annotations = map[string]string{hostOriginsAnnotation: `{"istio-system/*":"baseline","b/*":"observed"}`}
+			manifest := "spec: {egress: [{hosts: " + tt.liveHosts + "}]}\n"
+			if tt.liveOrigins != "" {
+				manifest += fmt.Sprintf("metadata: {annotations: {%s: '%s'}}\n", hostOriginsAnnotation, tt.liveOrigins)
+			}
+			changed, err := keepProtectedHosts(obj, testLiveObject(t, manifest))
+			if err != nil {
+				t.Fatalf("keepProtectedHosts() error = %v", err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("keepProtectedHosts() = %v, want %v", changed, tt.wantChanged)
+			}
+			sidecar := &v1beta1.Sidecar{}
+			if err := obj.GetSpec().UnmarshalTo(sidecar); err != nil {
+				t.Fatal(err)
+			}
+			if got := sidecar.Egress[0].Hosts; !reflect.DeepEqual(got, tt.wantHosts) {
+				t.Errorf("keepProtectedHosts() hosts = %v, want %v", got, tt.wantHosts)
+			}
+			origins := make(map[string]string)
+			if err := json.Unmarshal([]byte(obj.Metadata.Annotations[hostOriginsAnnotation]), &origins); err != nil {
+				t.Fatal(err)
+			}
+			if len(origins) != len(tt.wantHosts) {
+				t.Errorf("keepProtectedHosts() origins = %v, want one for each of %v", origins, tt.wantHosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// Reads the owner of each namespace from the --owners-file, a YAML map of namespace to owner, e.g.:
+//
+//	payments: payments-team
+//	checkout: payments-team
+func loadOwners(path string) (map[string]string, error) {
+	data, err := readInput("--owners-file", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --owners-file %q: %w", inputName(path), err)
+	}
+	owners := make(map[string]string)
+	if err := yaml.UnmarshalStrict(data, &owners); err != nil {
+		return nil, fmt.Errorf("failed to parse --owners-file %q: %w", inputName(path), err)
+	}
+	for ns, owner := range owners {
+		if owner == "" {
+			return nil, fmt.Errorf("namespace %q in %q has no owner", ns, inputName(path))
+		}
+	}
+	debug("loaded the owners of %d namespaces from %q", len(owners), inputName(path))
+	return owners, nil
+}
+
+// Returns the namespaces of the cluster whose --owner-label is the owner, mapped to it; the other namespaces aren't
+// listed, as the run only needs to tell the owner's ones apart
+func namespaceOwners(kubectl *Kubectl, label, owner string) (map[string]string, error) {
+	out, err := kubectl.run(nil, "get", "namespaces", "-l", label+"="+owner, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the namespaces of %q: %w", owner, err)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse the namespaces of %q: %w", owner, err)
+	}
+	owners := make(map[string]string, len(list.Items))
+	for _, ns := range list.Items {
+		owners[ns.Metadata.Name] = owner
+	}
+	debug("namespaces of %q in the cluster: %v", owner, maps.Keys(owners))
+	return owners, nil
+}
+
+// Returns the services with namespaces of the --owner, the only ones whose topology an owner run needs
+func ownedServices(runtime *Runtime, services []Service) []Service {
+	var owned []Service
+	for i := range services {
+		for _, ns := range parseNamespace(&services[i]) {
+			if runtime.owners[ns] == runtime.owner {
+				owned = append(owned, services[i])
+				break
+			}
+		}
+	}
+	return owned
+}
+
+// Returns the part of the previous state in the namespaces of the --owner, which an owner run compares against: its
+// edges from them, and its resources in them. The TSB resources in the GitOps namespace can't be told apart by owner,
+// so they are left out and never pruned by an owner run.
+func ownedState(runtime *Runtime, previous *State) *State {
+	if runtime.owner == "" || previous == nil {
+		return previous
+	}
+	owned := *previous
+	owned.Edges, owned.Resources = nil, nil
+	for _, e := range previous.Edges {
+		for _, ns := range e.SourceNamespaces {
+			if runtime.owners[ns] == runtime.owner {
+				owned.Edges = append(owned.Edges, e)
+				break
+			}
+		}
+	}
+	for _, r := range previous.Resources {
+		if runtime.owners[r.Namespace] == runtime.owner {
+			owned.Resources = append(owned.Resources, r)
+		}
+	}
+	return &owned
+}
+
+// Keeps only the calls from the namespaces of the --owner, so a team regenerates just its own resources. A BRIDGED
+// group has a single TrafficSetting for all its namespaces, so the calls of the groups with namespaces of other owners
+// are dropped too, and each of those groups the owner has namespaces in is reported once.
+func scopeToOwner(runtime *Runtime, graph *Graph) {
+	if runtime.owner == "" {
+		return
+	}
+	// group FQN => namespaces of other owners, and whether the owner has any in it
+	others := make(map[string][]string)
+	owned := make(map[string]bool)
+	for _, call := range graph.Calls {
+		group := call.SourceTrafficGroup
+		if group == nil || group.ConfigMode == configModeDirect {
+			continue
+		}
+		for _, ns := range call.SourceNamespaces {
+			if runtime.owners[ns] == runtime.owner {
+				owned[group.FQN] = true
+			} else {
+				others[group.FQN] = mergeSorted(others[group.FQN], []string{ns})
+			}
+		}
+	}
+	shared := maps.Keys(others)
+	slices.Sort(shared)
+	for _, fqn := range shared {
+		if owned[fqn] {
+			diagnose(diagSharedGroup, fqn, runtime.owner, others[fqn])
+		}
+	}
+
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		if group := call.SourceTrafficGroup; group != nil && len(others[group.FQN]) > 0 {
+			explainf(explainGraph, "call %s: skipped, traffic group %s has namespaces of owners other than %q", call.ID, group.FQN, runtime.owner)
+			continue
+		}
+		var sources []string
+		for _, ns := range call.SourceNamespaces {
+			if runtime.owners[ns] == runtime.owner {
+				sources = append(sources, ns)
+			}
+		}
+		if len(sources) == 0 {
+			explainf(explainGraph, "call %s: skipped, source namespaces %v aren't owned by %q", call.ID, call.SourceNamespaces, runtime.owner)
+			continue
+		}
+		call.SourceNamespaces = sources
+		calls = append(calls, call)
+	}
+	graph.Calls = calls
+}
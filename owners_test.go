@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScopeToOwner(t *testing.T) {
+	owners := map[string]string{"pay": "payments-team", "shop": "shop-team"}
+	tests := []struct {
+		owner string
+		// call ID => its source namespaces
+		want            map[string][]string
+		wantDiagnostics int
+	}{
+		{
+			owner: "",
+			want: map[string][]string{
+				"own": {"pay"}, "mixed": {"pay", "shop"}, "other": {"shop"}, "direct": {"pay", "shop"},
+				"shared-pay": {"pay"}, "shared-shop": {"shop"}, "bridged": {"pay"},
+			},
+		},
+		{
+			owner:           "payments-team",
+			want:            map[string][]string{"own": {"pay"}, "mixed": {"pay"}, "direct": {"pay"}, "bridged": {"pay"}},
+			wantDiagnostics: 1,
+		},
+		{
+			owner:           "shop-team",
+			want:            map[string][]string{"mixed": {"shop"}, "other": {"shop"}, "direct": {"shop"}},
+			wantDiagnostics: 1,
+		},
+		{owner: "nobody", want: map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			diagnostics = nil
+			defer func() { diagnostics = nil }()
+			shared := &TrafficGroup{FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/shared"}
+			graph := &Graph{Calls: []*Call{
+				{ID: "own", SourceNamespaces: []string{"pay"}},
+				{ID: "mixed", SourceNamespaces: []string{"pay", "shop"}},
+				{ID: "other", SourceNamespaces: []string{"shop"}},
+				{ID: "direct", SourceNamespaces: []string{"pay", "shop"}, SourceTrafficGroup: &TrafficGroup{FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/direct", ConfigMode: configModeDirect}},
+				{ID: "shared-pay", SourceNamespaces: []string{"pay"}, SourceTrafficGroup: shared},
+				{ID: "shared-shop", SourceNamespaces: []string{"shop"}, SourceTrafficGroup: shared},
+				{ID: "bridged", SourceNamespaces: []string{"pay"}, SourceTrafficGroup: &TrafficGroup{FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/bridged"}},
+			}}
+			scopeToOwner(&Runtime{owner: tt.owner, owners: owners}, graph)
+			got := make(map[string][]string)
+			for _, call := range graph.Calls {
+				got[call.ID] = call.SourceNamespaces
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopeToOwner() calls = %v, want %v", got, tt.want)
+			}
+			if len(diagnostics) != tt.wantDiagnostics {
+				t.Errorf("scopeToOwner() diagnostics = %v, want %d", diagnostics, tt.wantDiagnostics)
+			}
+		})
+	}
+}
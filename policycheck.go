@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	policyCheckFail     = "fail"
+	policyCheckAnnotate = "annotate"
+
+	policyViolationsAnnotation = "generate-sidecar-tool/policy-violations"
+
+	// Rule the user provided policies must define, following the conftest convention
+	policyCheckQuery = "data.main.deny"
+)
+
+// Evaluates every generated object against the Rego policies in the configured directory. Depending on the
+// mode, violations either fail the run or are recorded in an annotation on the violating object.
+func policyCheck(runtime *Runtime, results []*typesv2.Object) error {
+	var failures []string
+	for _, obj := range results {
+		violations, err := evalRego(runtime.policyCheckDir, obj)
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			continue
+		}
+		debug("%s %s violates policies: %v", obj.GetKind(), objectName(obj), violations)
+
+		switch runtime.policyCheckMode {
+		case policyCheckAnnotate:
+			if obj.Metadata == nil {
+				obj.Metadata = &typesv2.ObjectMeta{}
+			}
+			if obj.Metadata.Annotations == nil {
+				obj.Metadata.Annotations = make(map[string]string)
+			}
+			obj.Metadata.Annotations[policyViolationsAnnotation] = strings.Join(violations, "; ")
+			diagnose(diagPolicyViolation, obj.GetKind(), objectName(obj), strings.Join(violations, "; "))
+		default:
+			for _, v := range violations {
+				failures = append(failures, fmt.Sprintf("%s %s: %s", obj.GetKind(), objectName(obj), v))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("generated objects violate policies in %q:\n  %s", runtime.policyCheckDir, strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// Returns the deny messages produced by evaluating the policies in dir with the object as input
+func evalRego(dir string, obj *typesv2.Object) ([]string, error) {
+	input, err := protojson.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s for policy check: %w", obj.GetKind(), objectName(obj), err)
+	}
+
+	cmd := exec.Command("opa", "eval", "--format", "json", "--data", dir, "--stdin-input", policyCheckQuery)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate policies in %q (is opa installed?): %w: %s", dir, err, stderr.String())
+	}
+
+	type evalResult struct {
+		Result []struct {
+			Expressions []struct {
+				Value []any `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	res := &evalResult{}
+	if err := json.Unmarshal(out, res); err != nil {
+		return nil, fmt.Errorf("failed to parse opa output: %w", err)
+	}
+
+	var violations []string
+	for _, r := range res.Result {
+		for _, e := range r.Expressions {
+			for _, v := range e.Value {
+				violations = append(violations, fmt.Sprint(v))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// Returns a human readable name for a generated object
+func objectName(obj *typesv2.Object) string {
+	meta := obj.GetMetadata()
+	if meta.GetNamespace() != "" {
+		return meta.GetNamespace() + "/" + meta.GetName()
+	}
+	if meta.GetGroup() != "" {
+		return fmt.Sprintf("%s/%s/%s", meta.GetTenant(), meta.GetWorkspace(), meta.GetGroup())
+	}
+	return meta.GetName()
+}
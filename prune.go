@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// A resource generated by a run, identified as in Kubernetes so that it can be deleted later
+type GeneratedResource struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+}
+
+func (r GeneratedResource) String() string {
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// Returns the generated objects as they are identified in Kubernetes, with the TSB resources in the GitOps namespace
+func generatedResources(runtime *Runtime, results []*typesv2.Object) []GeneratedResource {
+	resources := make([]GeneratedResource, 0, len(results))
+	for _, obj := range results {
+		r := GeneratedResource{
+			ApiVersion: obj.GetApiVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetMetadata().GetNamespace(),
+			Name:       obj.GetMetadata().GetName(),
+		}
+		if r.Namespace == "" {
+			r.Namespace = runtime.gitopsNamespace
+		}
+		if r.Name == "" {
+			r.Name = "default"
+		}
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+// Returns the resources generated by the previous run but not by this one, which are left behind when the namespaces
+// or groups they were generated for go away.
+func orphanedResources(runtime *Runtime, previous *State, results []*typesv2.Object) []GeneratedResource {
+	if previous == nil {
+		return nil
+	}
+	current := make(map[GeneratedResource]bool)
+	for _, r := range generatedResources(runtime, results) {
+		current[r] = true
+	}
+
+	var orphaned []GeneratedResource
+	for _, r := range previous.Resources {
+		if !current[r] {
+			orphaned = append(orphaned, r)
+		}
+	}
+	return orphaned
+}
+
+// Lists the orphaned resources and, with --prune, deletes them from the cluster
+func prune(runtime *Runtime, orphaned []GeneratedResource) error {
+	if len(orphaned) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(orphaned))
+	for _, r := range orphaned {
+		names = append(names, r.String())
+	}
+	diagnose(diagPrune, strings.Join(names, "\n  "))
+	if !runtime.prune {
+		return nil
+	}
+
+	for i, r := range orphaned {
+		manifest, err := yaml.Marshal(map[string]any{
+			"apiVersion": r.ApiVersion,
+			"kind":       r.Kind,
+			"metadata":   map[string]any{"name": r.Name, "namespace": r.Namespace},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", r, err)
+		}
+		switch runtime.dryRun {
+		case dryRunClient:
+			printDiff(r.String(), manifest, nil)
+			continue
+		case dryRunServer:
+			live, err := runtime.kubectl.run(manifest, "get", "--ignore-not-found", "-o", "yaml", "-f", "-")
+			if err != nil {
+				return fmt.Errorf("failed to get %s: %w", r, err)
+			}
+			if _, err := runtime.kubectl.run(manifest, "delete", "--dry-run=server", "--ignore-not-found", "-f", "-"); err != nil {
+				return fmt.Errorf("server-side dry-run rejected pruning %s: %w", r, err)
+			}
+			if len(live) > 0 {
+				printDiff(r.String(), live, nil)
+			}
+			continue
+		}
+		out, err := runtime.kubectl.run(manifest, "delete", "--ignore-not-found", "-f", "-")
+		if err != nil {
+			return fmt.Errorf("failed to prune %s, after pruning %d of %d resources: %w", r, i, len(orphaned), err)
+		}
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			warn("%s", msg)
+		}
+	}
+	return nil
+}
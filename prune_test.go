@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	orphaned := []GeneratedResource{{ApiVersion: "networking.istio.io/v1beta1", Kind: "Sidecar", Namespace: "gone", Name: "reachability-sidecar"}}
+	tests := []struct {
+		name      string
+		prune     bool
+		dryRun    string
+		wantCalls []string
+		wantLog   string
+	}{
+		{name: "without --prune", dryRun: dryRunNone},
+		{name: "prune", prune: true, dryRun: dryRunNone, wantCalls: []string{"delete --ignore-not-found -f -"}, wantLog: "deleted"},
+		{name: "client dry-run", prune: true, dryRun: dryRunClient, wantLog: "--- a/Sidecar gone/reachability-sidecar\n+++ /dev/null\n"},
+		{
+			name:      "server dry-run",
+			prune:     true,
+			dryRun:    dryRunServer,
+			wantCalls: []string{"get --ignore-not-found -o yaml -f -", "delete --dry-run=server --ignore-not-found -f -"},
+			wantLog:   "-metadata: {resourceVersion: \"42\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics = nil
+			defer func() { diagnostics = nil }()
+			kubectl := fakeKubectl(t, "case \"$*\" in get*) echo 'metadata: {resourceVersion: \"42\"}';; \"delete --ignore-not-found -f -\") echo deleted;; esac\n")
+			runtime := &Runtime{kubectl: kubectl, prune: tt.prune, dryRun: tt.dryRun}
+			var err error
+			log := captureLog(t, func() { err = prune(runtime, orphaned) })
+			if err != nil {
+				t.Fatalf("prune() error = %v", err)
+			}
+			if calls := kubectlCalls(t, kubectl); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("kubectl calls = %q, want %q", calls, tt.wantCalls)
+			}
+			if !strings.Contains(log, tt.wantLog) {
+				t.Errorf("logged %q, want it to contain %q", log, tt.wantLog)
+			}
+			if len(diagnostics) != 1 || diagnostics[0].Code != diagPrune {
+				t.Errorf("diagnostics = %+v, want the orphaned resources listed", diagnostics)
+			}
+		})
+	}
+}
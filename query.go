@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Returns the generated objects as generic JSON data, in a list like kubectl's: {"items": [...]}
+func resultsData(results []*typesv2.Object, sidecarOutput string) (map[string]any, error) {
+	items := make([]any, 0, len(results))
+	for _, obj := range results {
+		if sidecarOutput == sidecarOutputK8s && obj.GetKind() == api.IstioSidecarKind {
+			manifest, err := toKubernetesManifest(obj, "")
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, manifest)
+			continue
+		}
+		js, err := protojson.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s %s: %w", obj.GetKind(), objectName(obj), err)
+		}
+		var item map[string]any
+		if err := json.Unmarshal(js, &item); err != nil {
+			return nil, fmt.Errorf("failed to convert %s %s: %w", obj.GetKind(), objectName(obj), err)
+		}
+		items = append(items, item)
+	}
+	return map[string]any{"items": items}, nil
+}
+
+// Prints the results through the Go template
+func outputTemplate(out io.Writer, text string, results []*typesv2.Object, sidecarOutput string) error {
+	tmpl, err := template.New("output").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse --output-template: %w", err)
+	}
+	data, err := resultsData(results, sidecarOutput)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(out, data); err != nil {
+		return fmt.Errorf("failed to execute --output-template: %w", err)
+	}
+	return nil
+}
+
+// Prints the values the JSONPath expression selects from the results, one per line. Only the kubectl subset
+// of child (.name), index ([n]) and wildcard ([*] or .*) steps is supported, with or without the braces.
+func outputJSONPath(out io.Writer, expr string, results []*typesv2.Object, sidecarOutput string) error {
+	steps, err := parseJSONPath(expr)
+	if err != nil {
+		return err
+	}
+	data, err := resultsData(results, sidecarOutput)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range evalJSONPath([]any{data}, steps) {
+		switch v.(type) {
+		case map[string]any, []any:
+			js, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("failed to marshal --jsonpath value: %w", err)
+			}
+			fmt.Fprintln(out, string(js))
+		default:
+			fmt.Fprintln(out, v)
+		}
+	}
+	return nil
+}
+
+// Splits a JSONPath expression like {.items[*].metadata.name} into its steps: field names, indexes and "*"
+func parseJSONPath(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimSuffix(strings.TrimPrefix(expr, "{"), "}")
+	expr = strings.TrimPrefix(expr, "$")
+
+	var steps []string
+	for expr != "" {
+		switch expr[0] {
+		case '.':
+			expr = expr[1:]
+			end := strings.IndexAny(expr, ".[")
+			if end < 0 {
+				end = len(expr)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid --jsonpath: empty field name")
+			}
+			steps = append(steps, expr[:end])
+			expr = expr[end:]
+		case '[':
+			end := strings.Index(expr, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid --jsonpath: unterminated [")
+			}
+			index := expr[1:end]
+			if _, err := strconv.Atoi(index); err != nil && index != "*" {
+				return nil, fmt.Errorf("invalid --jsonpath index %q: only numbers and * are supported", index)
+			}
+			steps = append(steps, "["+index+"]")
+			expr = expr[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid --jsonpath: unexpected %q", expr)
+		}
+	}
+	return steps, nil
+}
+
+// Applies the steps to each of the values, returning every value they select
+func evalJSONPath(values []any, steps []string) []any {
+	for _, step := range steps {
+		var next []any
+		for _, v := range values {
+			switch {
+			case step == "*" || step == "[*]":
+				switch t := v.(type) {
+				case []any:
+					next = append(next, t...)
+				case map[string]any:
+					for _, e := range t {
+						next = append(next, e)
+					}
+				}
+			case strings.HasPrefix(step, "["):
+				list, ok := v.([]any)
+				i, _ := strconv.Atoi(strings.Trim(step, "[]"))
+				if i < 0 {
+					i += len(list)
+				}
+				if ok && i >= 0 && i < len(list) {
+					next = append(next, list[i])
+				}
+			default:
+				if m, ok := v.(map[string]any); ok {
+					if e, ok := m[step]; ok {
+						next = append(next, e)
+					}
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Replaces namespaces, services and the other names of reports with pseudonyms, so they can be shared outside the
+// platform team. Pseudonyms are derived from the name with the seed, so the same name gets the same one in every
+// report redacted with the same seed and they can still be correlated. A nil redactor keeps the names.
+type redactor struct {
+	seed []byte
+}
+
+func newRedactor(seed string) *redactor {
+	return &redactor{seed: []byte(seed)}
+}
+
+// Returns the pseudonym of the name of the given kind, like namespace-1a2b3c4d
+func (r *redactor) pseudonym(kind, name string) string {
+	mac := hmac.New(sha256.New, r.seed)
+	mac.Write([]byte(kind + "/" + name))
+	return kind + "-" + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+func (r *redactor) namespace(ns string) string {
+	if r == nil || ns == "" {
+		return ns
+	}
+	return r.pseudonym("namespace", ns)
+}
+
+func (r *redactor) tenant(name string) string {
+	if r == nil || name == "" {
+		return name
+	}
+	return r.pseudonym("tenant", name)
+}
+
+// Returns the pseudonym of the service, prefixed by the one of its namespace if it has one, whatever the name style
+// it would be named in
+func (r *redactor) service(style string, svc *Service) string {
+	if r == nil {
+		return serviceName(style, svc)
+	}
+	name := r.pseudonym("service", svc.FQN)
+	if ns := parseNamespace(svc); len(ns) > 0 {
+		name = r.namespace(ns[0]) + "/" + name
+	}
+	return name
+}
+
+// Redacts the names of a TSB FQN made of <kind>/<name> pairs, keeping the kinds
+func (r *redactor) fqn(fqn string) string {
+	if r == nil {
+		return fqn
+	}
+	parts := strings.Split(fqn, "/")
+	for i := 0; i+1 < len(parts); i += 2 {
+		if parts[i] == "namespaces" {
+			parts[i+1] = r.namespace(parts[i+1])
+			continue
+		}
+		parts[i+1] = r.pseudonym(strings.TrimSuffix(parts[i], "s"), parts[i+1])
+	}
+	return strings.Join(parts, "/")
+}
+
+// Redacts the call ID, which skywalking derives from the names of the services
+func (r *redactor) call(id string) string {
+	if r == nil {
+		return id
+	}
+	return r.pseudonym("call", id)
+}
+
+// Redacts free text given by users, like the reasons of exclusions and manual edges, as it may name anything
+func (r *redactor) text(s string) string {
+	if r == nil {
+		return s
+	}
+	return "redacted"
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	reportText = "text"
+	reportCSV  = "csv"
+)
+
+var reportColumns = []string{"source_namespace", "destination_host", "evidence", "first_seen", "window", "policy"}
+
+// Columns added with --by-throughput
+var throughputColumns = []string{"calls_per_minute", "long_tail"}
+
+// Column added with --time-of-day
+const timeOfDayColumn = "time_of_day"
+
+// A host allowed to a source namespace, with the evidence for it
+type ReportRow struct {
+	SourceNamespace string `json:"sourceNamespace"`
+	DestinationHost string `json:"destinationHost"`
+	// the call the host is allowed because of
+	Evidence  string    `json:"evidence"`
+	FirstSeen time.Time `json:"firstSeen"`
+	Window    Window    `json:"window"`
+	// the generated object allowing the host
+	Policy string `json:"policy"`
+	// calls per minute of the call in the window, with --by-throughput
+	Throughput int64 `json:"throughput,omitempty"`
+	// whether the throughput is under --long-tail-cpm
+	LongTail bool `json:"longTail,omitempty"`
+	// when the traffic of the call happens, with --time-of-day
+	TimeOfDay string `json:"timeOfDay,omitempty"`
+
+	// the call the row comes from
+	callID string
+}
+
+func (r *ReportRow) columns(byThroughput, timeOfDay bool) []string {
+	firstSeen := ""
+	if !r.FirstSeen.IsZero() {
+		firstSeen = r.FirstSeen.Format(DATE_FORMAT)
+	}
+	columns := []string{r.SourceNamespace, r.DestinationHost, r.Evidence, firstSeen, r.Window.String(), r.Policy}
+	if byThroughput {
+		longTail := ""
+		if r.LongTail {
+			longTail = "yes"
+		}
+		columns = append(columns, fmt.Sprint(r.Throughput), longTail)
+	}
+	if timeOfDay {
+		columns = append(columns, r.TimeOfDay)
+	}
+	return columns
+}
+
+// Returns the header of the report
+func reportHeader(byThroughput, timeOfDay bool) []string {
+	header := slices.Clone(reportColumns)
+	if byThroughput {
+		header = append(header, throughputColumns...)
+	}
+	if timeOfDay {
+		header = append(header, timeOfDayColumn)
+	}
+	return header
+}
+
+func newReportCmd(runtime *Runtime) *cobra.Command {
+	var format string
+	var byThroughput bool
+	var longTailCPM int64
+	var redact bool
+	var redactSeed string
+	var changeSummary bool
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report every host the generated policies allow with the call it is allowed because of, e.g. as compliance evidence",
+		Long: `Report every host the generated policies allow with the call it is allowed because of, e.g. as compliance evidence.
+
+Each row has the source namespace, the allowed destination host, the call justifying it, when the call was first
+seen (going back as far as --state-file does, or the start of the window otherwise), the window and the generated
+policy object allowing it. The namespaces of the --exclusions-file are listed too, as intentionally excluded with
+their reason, along the hosts their calls need.
+
+With --by-throughput, the calls per minute of each call are fetched too, and the rows sorted by them so the
+highest-traffic entries are validated first; rows under --long-tail-cpm are flagged as long tail. With --time-of-day,
+each row has the class of its call too: business-hours, nightly-batch or constant.
+
+The text report ends with the namespaces only ever seen as destinations, with no outbound calls observed: they're
+candidates for the strictest Sidecars, which --destination-only-sidecars generates.
+
+With --redact, namespaces, services, traffic groups and call IDs are replaced with pseudonyms derived from them with
+--redact-seed, and the reasons of exclusions and manual edges are dropped, so the report can be shared outside the
+platform team. The same seed gives the same pseudonyms in every report, so they can still be correlated.
+
+With --change-summary, the report is instead a summary of the changes since the run of the --state-file, in plain
+words for change advisory boards: for each tenant, the destination namespaces each source namespace is newly allowed
+to reach, and the ones it no longer is.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != reportText && format != reportCSV {
+				return fmt.Errorf("invalid --format %q, must be one of %q or %q", format, reportText, reportCSV)
+			}
+			var names *redactor
+			if redact {
+				if redactSeed == "" {
+					return fmt.Errorf("--redact requires --redact-seed, to redact names the same way in every report")
+				}
+				names = newRedactor(redactSeed)
+			}
+			if changeSummary && format != reportText {
+				return fmt.Errorf("--change-summary is only available in the %q format", reportText)
+			}
+			graph, err := fetchGraph(runtime)
+			if err != nil {
+				return err
+			}
+			state, err := loadState(runtime.stateFile)
+			if err != nil {
+				return err
+			}
+			if changeSummary {
+				if state == nil {
+					return fmt.Errorf("--change-summary requires a --state-file with a previous run to compare with")
+				}
+				writeChangeSummary(cmd.OutOrStdout(), state, graph, names)
+				return nil
+			}
+			nameStyle := runtime.nameStyle
+			if format == reportCSV {
+				nameStyle = nameStyleFQN
+			}
+			rows := reportRows(runtime, state, graph, nameStyle, names)
+			if byThroughput {
+				if err := weighReportRows(runtime, graph, rows, longTailCPM); err != nil {
+					return err
+				}
+			}
+			if format == reportCSV {
+				return writeReportCSV(cmd.OutOrStdout(), rows, byThroughput, runtime.timeOfDay)
+			}
+			writeReportText(cmd.OutOrStdout(), rows, byThroughput, runtime.timeOfDay)
+			namespaces, _ := destinationOnlyNamespaces(runtime, graph)
+			for i, ns := range namespaces {
+				namespaces[i] = names.namespace(ns)
+			}
+			writeDestinationOnly(cmd.OutOrStdout(), namespaces)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", reportText, "Format of the report: 'text' or 'csv'")
+	cmd.Flags().BoolVar(&byThroughput, "by-throughput", false, "Add the calls per minute of each call, and sort the rows by them, highest first")
+	cmd.Flags().Int64Var(&longTailCPM, "long-tail-cpm", 1, "With --by-throughput, flag the rows of calls with fewer calls per minute as long tail")
+	cmd.Flags().BoolVar(&redact, "redact", false, "Replace namespaces, services and other names with pseudonyms, to share the report outside the platform team")
+	cmd.Flags().StringVar(&redactSeed, "redact-seed", "", "Secret the --redact pseudonyms are derived from; the same seed gives the same pseudonyms")
+	cmd.Flags().BoolVar(&changeSummary, "change-summary", false, "Summarize the destinations allowed and removed since the run of the --state-file, by tenant, e.g. for change advisory boards")
+	return cmd
+}
+
+// Returns a row for each host allowed to each source namespace by each call, sorted by namespace and host. The
+// services of the evidence are named in the given --name-style. The hosts the calls of intentionally excluded
+// namespaces need have rows too, with the reason of the exclusion as policy, and so do the excluded namespaces with
+// no calls. Names are redacted with the redactor, if not nil.
+func reportRows(runtime *Runtime, previous *State, graph *Graph, nameStyle string, redact *redactor) []*ReportRow {
+	edges := graphEdges(graph)
+	setFirstSeen(runtime, previous, edges)
+	window := Window{Start: runtime.start, End: runtime.end}
+
+	var rows []*ReportRow
+	withCalls := make(map[string]bool)
+	calls := append(slices.Clone(graph.Calls), graph.ExcludedCalls...)
+	for _, call := range calls {
+		evidence := fmt.Sprintf("call %s: %s => %s", redact.call(call.ID), redact.service(nameStyle, call.SourceService), redact.service(nameStyle, call.TargetService))
+		switch {
+		case call.Mirrored:
+			evidence += " (reverse direction)"
+		case call.EastWest:
+			evidence += " (through the east-west gateway)"
+		case call.Manual:
+			evidence += fmt.Sprintf(" (manual: %s)", redact.text(call.ManualReason))
+		}
+		edge := &Edge{SourceID: serviceStableID(call.SourceService), TargetID: serviceStableID(call.TargetService)}
+		// the edges of the calls of excluded namespaces only aren't tracked
+		firstSeen := runtime.start
+		if e, ok := edges[edge.Key()]; ok {
+			firstSeen = e.FirstSeen
+		}
+
+		for _, ns := range call.ExcludedNamespaces {
+			withCalls[ns] = true
+			for _, dest := range call.TargetNamespaces {
+				rows = append(rows, &ReportRow{
+					SourceNamespace: redact.namespace(ns),
+					DestinationHost: redact.namespace(dest) + "/*",
+					Evidence:        evidence,
+					FirstSeen:       firstSeen,
+					Window:          window,
+					Policy:          exclusionPolicy(redact.text(runtime.exclusions[ns])),
+					TimeOfDay:       call.TimeOfDay,
+					callID:          call.ID,
+				})
+			}
+		}
+		if call.SourceTrafficGroup == nil {
+			continue
+		}
+		for _, ns := range call.SourceNamespaces {
+			policy := "TrafficSetting " + redact.fqn(call.SourceTrafficGroup.FQN)
+			if call.SourceTrafficGroup.ConfigMode == "DIRECT" {
+				policy = fmt.Sprintf("Sidecar %s/reachability-sidecar", redact.namespace(ns))
+			}
+			for _, dest := range call.TargetNamespaces {
+				rows = append(rows, &ReportRow{
+					SourceNamespace: redact.namespace(ns),
+					DestinationHost: redact.namespace(dest) + "/*",
+					Evidence:        evidence,
+					FirstSeen:       firstSeen,
+					Window:          window,
+					Policy:          policy,
+					TimeOfDay:       call.TimeOfDay,
+					callID:          call.ID,
+				})
+			}
+		}
+	}
+
+	for ns, reason := range runtime.exclusions {
+		if !withCalls[ns] {
+			rows = append(rows, &ReportRow{SourceNamespace: redact.namespace(ns), Window: window, Policy: exclusionPolicy(redact.text(reason))})
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b *ReportRow) int {
+		if a.SourceNamespace != b.SourceNamespace {
+			return strings.Compare(a.SourceNamespace, b.SourceNamespace)
+		}
+		return strings.Compare(a.DestinationHost, b.DestinationHost)
+	})
+	return rows
+}
+
+// Sets the throughput of the rows from the metrics of the observed call each comes from, and sorts them by it,
+// highest first. Rows of the same throughput keep their order.
+func weighReportRows(runtime *Runtime, graph *Graph, rows []*ReportRow, longTailCPM int64) error {
+	throughput := make(map[string]int64)
+	for _, call := range graph.Calls {
+		// reversed and east-west calls weren't observed as such; they take the throughput of the observed call
+		if call.Mirrored || call.EastWest {
+			continue
+		}
+		// and manual ones weren't observed at all
+		if call.Manual {
+			throughput[call.ID] = 0
+			continue
+		}
+		if _, ok := throughput[call.ID]; ok {
+			continue
+		}
+		cpm, err := runtime.client.GetCallThroughput(call.SourceNode, call.TargetNode, runtime.start, runtime.end)
+		if err != nil {
+			return fmt.Errorf("failed to get the throughput of call %s: %w", call.ID, err)
+		}
+		throughput[call.ID] = cpm
+	}
+
+	for _, r := range rows {
+		r.Throughput = throughput[r.callID]
+		r.LongTail = r.Throughput < longTailCPM
+	}
+	slices.SortStableFunc(rows, func(a, b *ReportRow) int {
+		switch {
+		case a.Throughput > b.Throughput:
+			return -1
+		case a.Throughput < b.Throughput:
+			return 1
+		}
+		return 0
+	})
+	return nil
+}
+
+func writeReportCSV(out io.Writer, rows []*ReportRow, byThroughput, timeOfDay bool) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(reportHeader(byThroughput, timeOfDay)); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	for _, r := range rows {
+		if err := w.Write(r.columns(byThroughput, timeOfDay)); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func writeReportText(out io.Writer, rows []*ReportRow, byThroughput, timeOfDay bool) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(reportHeader(byThroughput, timeOfDay), "\t")))
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r.columns(byThroughput, timeOfDay), "\t"))
+	}
+	w.Flush()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The diagnostics already reported by previous runs in daemon mode, so that the same warning (like the same unmapped
+// node every run) is only reported when it first appears, and again when it's resolved
+type reportedDiagnostics struct {
+	// file to persist them in across restarts, from --reported-warnings-file; only kept in memory if empty
+	path string
+	// "CODE: message" => when it was first reported
+	Reported map[string]time.Time `json:"reported"`
+}
+
+// nil outside daemon mode, where every diagnostic is reported
+var reported *reportedDiagnostics
+
+func (d Diagnostic) key() string {
+	return d.Code + ": " + d.Message
+}
+
+// Loads the diagnostics reported before from the file, if any
+func loadReportedDiagnostics(path string) (*reportedDiagnostics, error) {
+	r := &reportedDiagnostics{path: path, Reported: make(map[string]time.Time)}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --reported-warnings-file %q: %w", path, err)
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse --reported-warnings-file %q: %w", path, err)
+	}
+	return r, nil
+}
+
+// Returns whether the diagnostic was reported by a previous run already
+func (r *reportedDiagnostics) has(d Diagnostic) bool {
+	_, ok := r.Reported[d.key()]
+	return ok
+}
+
+// Records the diagnostics of a successful run: the ones previous runs reported that it didn't emit are reported as
+// resolved, and forgotten
+func (r *reportedDiagnostics) update(current []Diagnostic) error {
+	seen := make(map[string]bool, len(current))
+	now := time.Now()
+	for _, d := range current {
+		seen[d.key()] = true
+		if _, ok := r.Reported[d.key()]; !ok {
+			r.Reported[d.key()] = now
+		}
+	}
+	for key, since := range r.Reported {
+		if !seen[key] {
+			warn("resolved, reported since %s: %s", since.Format(time.RFC3339), key)
+			delete(r.Reported, key)
+		}
+	}
+
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal reported warnings: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write --reported-warnings-file %q: %w", r.path, err)
+	}
+	return nil
+}
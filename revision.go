@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Label of every generated object with the revision of the run that generated it
+	revisionLabel = "generate-sidecar-tool/revision"
+
+	revisionTimestamp = "timestamp"
+	revisionHash      = "hash"
+	// format of the timestamp revisions, which sort as the times they stand for
+	revisionTimeFormat = "20060102T150405Z"
+)
+
+// Kinds of the generated resources, as kubectl gets them, to look for generated objects in the clusters
+var revisionKinds = []string{
+	"sidecars.networking.istio.io",
+	"authorizationpolicies.security.istio.io",
+	"destinationrules.networking.istio.io",
+	"trafficsettings.traffic.tsb.tetrate.io",
+	"groups.traffic.tsb.tetrate.io",
+	"workspaces.tsb.tetrate.io",
+}
+
+// Returns the revision of the run generating the results at the given time: a hash of the results, which only changes
+// when they do, or with --revision=timestamp the time itself
+func resultsRevision(kind string, now time.Time, results []*typesv2.Object) (string, error) {
+	if kind == revisionTimestamp {
+		return now.UTC().Format(revisionTimeFormat), nil
+	}
+	// the order of the results isn't stable, so hash each of them and then their sorted hashes
+	hashes := make([]string, 0, len(results))
+	for _, obj := range results {
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %s %s: %w", obj.GetKind(), objectName(obj), err)
+		}
+		sum := sha256.Sum256(data)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	slices.Sort(hashes)
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	return hex.EncodeToString(sum[:])[:12], nil
+}
+
+// Labels every object with the revision
+func labelRevision(results []*typesv2.Object, revision string) {
+	for _, obj := range results {
+		if obj.Metadata == nil {
+			obj.Metadata = &typesv2.ObjectMeta{}
+		}
+		if obj.Metadata.Labels == nil {
+			obj.Metadata.Labels = make(map[string]string)
+		}
+		obj.Metadata.Labels[revisionLabel] = revision
+	}
+}
+
+// A generated object found in a cluster
+type liveRevision struct {
+	Context   string
+	Kind      string
+	Namespace string
+	Name      string
+	Revision  string
+	Stale     bool
+}
+
+func newStatusCmd(runtime *Runtime) *cobra.Command {
+	var contexts []string
+	var staleOnly bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the generated objects in the clusters and whether they are from an older revision than the last run",
+		Long: `List the generated objects in the clusters and whether they are from an older revision than the last run.
+
+Every generated object is labeled with the revision of the run that generated it, in ` + revisionLabel + `. The
+objects with the label are looked for in each of the --contexts, or the --kube-context, including the TSB resources
+applied through GitOps. An object is stale when its revision isn't the one of the last run in --state-file, or
+without one, when it's older than the newest timestamp revision found.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state, err := loadState(runtime.stateFile)
+			if err != nil {
+				return err
+			}
+			if len(contexts) == 0 {
+				contexts = []string{runtime.kubectl.context}
+			}
+			var live []*liveRevision
+			for _, context := range contexts {
+				objects, err := liveRevisions(&Kubectl{path: runtime.kubectl.path, context: context})
+				if err != nil {
+					return err
+				}
+				live = append(live, objects...)
+			}
+
+			current := ""
+			if state != nil {
+				current = state.Revision
+			}
+			markStale(live, current)
+			writeStatus(cmd.OutOrStdout(), live, staleOnly)
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&contexts, "contexts", nil, "kubeconfig contexts of the clusters to look in; defaults to --kube-context")
+	cmd.Flags().BoolVar(&staleOnly, "stale-only", false, "Only list the stale objects")
+	return cmd
+}
+
+// Gets the generated objects in the cluster, with their revision
+func liveRevisions(kubectl *Kubectl) ([]*liveRevision, error) {
+	out, err := kubectl.run(nil, "get", strings.Join(revisionKinds, ","), "--all-namespaces", "--ignore-not-found", "-l", revisionLabel, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the generated objects: %w", err)
+	}
+	var list struct {
+		Items []struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Namespace string            `json:"namespace"`
+				Name      string            `json:"name"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &list); err != nil {
+			return nil, fmt.Errorf("failed to parse the generated objects: %w", err)
+		}
+	}
+	objects := make([]*liveRevision, 0, len(list.Items))
+	for _, item := range list.Items {
+		objects = append(objects, &liveRevision{
+			Context:   kubectl.context,
+			Kind:      item.Kind,
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+			Revision:  item.Metadata.Labels[revisionLabel],
+		})
+	}
+	return objects, nil
+}
+
+// Flags the objects not of the current revision as stale. Without one, the current revision is the newest
+// timestamp revision of the objects; hash revisions can't be ordered, so they're never stale then.
+func markStale(objects []*liveRevision, current string) {
+	if current == "" {
+		for _, obj := range objects {
+			if _, err := time.Parse(revisionTimeFormat, obj.Revision); err == nil && obj.Revision > current {
+				current = obj.Revision
+			}
+		}
+		for _, obj := range objects {
+			_, err := time.Parse(revisionTimeFormat, obj.Revision)
+			obj.Stale = err == nil && obj.Revision < current
+		}
+		return
+	}
+	for _, obj := range objects {
+		obj.Stale = obj.Revision != current
+	}
+}
+
+func writeStatus(out io.Writer, objects []*liveRevision, staleOnly bool) {
+	slices.SortStableFunc(objects, func(a, b *liveRevision) int {
+		if a.Context != b.Context {
+			return strings.Compare(a.Context, b.Context)
+		}
+		if a.Namespace != b.Namespace {
+			return strings.Compare(a.Namespace, b.Namespace)
+		}
+		if a.Kind != b.Kind {
+			return strings.Compare(a.Kind, b.Kind)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tNAMESPACE\tKIND\tNAME\tREVISION\tSTALE")
+	stale := 0
+	for _, obj := range objects {
+		if obj.Stale {
+			stale++
+		} else if staleOnly {
+			continue
+		}
+		context := obj.Context
+		if context == "" {
+			context = "(current)"
+		}
+		staleColumn := ""
+		if obj.Stale {
+			staleColumn = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", context, obj.Namespace, obj.Kind, obj.Name, obj.Revision, staleColumn)
+	}
+	w.Flush()
+	if stale > 0 {
+		warn("%d of %d generated objects are from an older revision", stale, len(objects))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+// A rule transforming the destination namespaces that match it, from the --namespace-rules file, e.g. to model the
+// locality failover destinations the window may not show:
+//
+//   - match: "^payments-(eu|us)$"
+//     add: ["payments-eu", "payments-us"]
+type NamespaceRule struct {
+	// Regular expression the destination namespace must match
+	Match string `json:"match"`
+	// If set, replaces the namespace; it can reference the groups of the match, like $1
+	Replace string `json:"replace,omitempty"`
+	// Namespaces added along the matching one; they can reference the groups of the match too
+	Add []string `json:"add,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Reads the namespace rules from the YAML file
+func loadNamespaceRules(path string) ([]*NamespaceRule, error) {
+	data, err := readInput("--namespace-rules", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --namespace-rules %q: %w", inputName(path), err)
+	}
+	var rules []*NamespaceRule
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse --namespace-rules %q: %w", inputName(path), err)
+	}
+	for i, r := range rules {
+		if r.re, err = regexp.Compile(r.Match); err != nil {
+			return nil, fmt.Errorf("invalid match of rule %d in %q: %w", i, inputName(path), err)
+		}
+		if r.Replace == "" && len(r.Add) == 0 {
+			return nil, fmt.Errorf("rule %d in %q has neither replace nor add", i, inputName(path))
+		}
+	}
+	debug("loaded %d namespace rules from %q", len(rules), inputName(path))
+	return rules, nil
+}
+
+// Returns the namespaces the destination namespace becomes after applying the rules in order. Every rule sees the
+// namespaces the previous ones produced.
+func transformNamespace(rules []*NamespaceRule, ns string) []string {
+	namespaces := []string{ns}
+	for _, r := range rules {
+		var next []string
+		for _, n := range namespaces {
+			m := r.re.FindStringSubmatchIndex(n)
+			if m == nil {
+				next = append(next, n)
+				continue
+			}
+			if r.Replace != "" {
+				next = append(next, string(r.re.ExpandString(nil, r.Replace, n, m)))
+			} else {
+				next = append(next, n)
+			}
+			for _, add := range r.Add {
+				next = append(next, string(r.re.ExpandString(nil, add, n, m)))
+			}
+		}
+		namespaces = next
+	}
+	return mergeSorted(nil, namespaces)
+}
+
+// Applies the rules to the destination namespaces of every call in the graph
+func applyNamespaceRules(rules []*NamespaceRule, graph *Graph) {
+	if len(rules) == 0 {
+		return
+	}
+	for _, call := range graph.Calls {
+		var targets []string
+		for _, ns := range call.TargetNamespaces {
+			transformed := transformNamespace(rules, ns)
+			if len(transformed) != 1 || transformed[0] != ns {
+				explainf(explainGraph, "call %s: destination namespace %q becomes %v by --namespace-rules", call.ID, ns, transformed)
+			}
+			targets = mergeSorted(targets, transformed)
+		}
+		call.TargetNamespaces = targets
+	}
+}
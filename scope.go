@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Reads the namespaces and service FQNs to scope the run to, one per line, from the file, URL or stdin for "-". Empty
+// lines and comments are skipped, and the "namespace/" prefix of `kubectl get ns -o name` is dropped.
+func readScope(path string) ([]string, error) {
+	data, err := readInput("--scope-from", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --scope-from %q: %w", inputName(path), err)
+	}
+
+	var scope []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		scope = append(scope, strings.TrimPrefix(line, "namespace/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --scope-from %q: %w", inputName(path), err)
+	}
+	debug("scoping the run to %v", scope)
+	return scope, nil
+}
+
+// Keeps only the calls from the services or namespaces in the scope, if there is one
+func scopeGraph(scope []string, graph *Graph) {
+	if len(scope) == 0 {
+		return
+	}
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		if slices.Contains(scope, call.SourceService.FQN) || containsAny(scope, call.SourceNamespaces) {
+			calls = append(calls, call)
+			continue
+		}
+		explainf(explainGraph, "call %s: skipped, source %s is not in --scope-from", call.ID, call.SourceService.FQN)
+	}
+	graph.Calls = calls
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+)
+
+// Version of the binary, set at build time by the Makefile
+var version = "dev"
+
+// Base64 Ed25519 public key the release checksums are signed with, pinned at build time by the Makefile
+var releaseKey = ""
+
+const (
+	defaultReleaseURL = "https://api.github.com/repos/chirauki/generate-sidecar-tool/releases/latest"
+	checksumsAsset    = "checksums.txt"
+	// detached signature of the checksums, in the format of --signature-file
+	checksumsSigAsset = "checksums.txt.sig"
+)
+
+// client of the release downloads, so an unresponsive server fails the update instead of hanging it
+var releaseClient = &http.Client{Timeout: 5 * time.Minute}
+
+// What git describe appends to the tag in the version of the builds of later commits, or of a dirty tree
+var describeSuffix = regexp.MustCompile(`(-[0-9]+-g[0-9a-f]+)?(-dirty)?$`)
+
+// The parts of a GitHub release we use
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// Returns the download URL of the asset of the release with the given name
+func (r *release) assetURL(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no asset %q", r.TagName, name)
+}
+
+// Returns the name of the release asset with the binary for this platform, as built by `make release`
+func platformAsset() string {
+	name := fmt.Sprintf("generate-sidecar-tool_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func newSelfUpdateCmd() *cobra.Command {
+	var releaseURL, keyFile string
+	var check bool
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace the binary with the one of the latest release, after verifying its signature",
+		Long: `Replace the binary with the one of the latest release, after verifying its signature.
+
+The binary for this platform is downloaded from the latest release, and its SHA-256 checksum checked against the
+release's checksums.txt, whose signature is verified with the Ed25519 public key pinned in the binary when it was
+built. The signature covers the release tag too, and only a release newer than this build is installed, so an older
+signed release can't be passed off as the latest. Builds without a pinned key, like the ones of go install, need the
+key given with --key.`,
+		Args: cobra.NoArgs,
+		// updating doesn't talk to TSB, so it needs none of the root command setup
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+			rel, err := latestRelease(releaseURL)
+			if err != nil {
+				return err
+			}
+			newer, err := newerRelease(rel.TagName, version)
+			if err != nil {
+				return err
+			}
+			if !newer {
+				fmt.Fprintf(out, "already at the latest release %s, this is %s\n", rel.TagName, version)
+				return nil
+			}
+			if check {
+				fmt.Fprintf(out, "release %s is available, this is %s\n", rel.TagName, version)
+				return nil
+			}
+
+			key, err := selfUpdateKey(keyFile)
+			if err != nil {
+				return err
+			}
+			checksums, err := downloadAsset(rel, checksumsAsset)
+			if err != nil {
+				return err
+			}
+			if err := verifyChecksums(rel, checksums, key); err != nil {
+				return err
+			}
+			binary, err := downloadAsset(rel, platformAsset())
+			if err != nil {
+				return err
+			}
+			if err := verifyChecksum(checksums, platformAsset(), binary); err != nil {
+				return err
+			}
+			if err := replaceExecutable(binary); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "updated from %s to %s\n", version, rel.TagName)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&releaseURL, "release-url", defaultReleaseURL, "URL of the GitHub API document of the release to update to")
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM file with the Ed25519 public key to verify the signature of the release checksums with, for builds without a pinned one")
+	cmd.Flags().BoolVar(&check, "check", false, "Only report whether a newer release is available")
+	return cmd
+}
+
+func latestRelease(url string) (*release, error) {
+	resp, err := releaseClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get release %q: %s", url, resp.Status)
+	}
+	rel := &release{}
+	if err := json.NewDecoder(resp.Body).Decode(rel); err != nil {
+		return nil, fmt.Errorf("failed to parse release %q: %w", url, err)
+	}
+	return rel, nil
+}
+
+func downloadAsset(rel *release, name string) ([]byte, error) {
+	url, err := rel.assetURL(name)
+	if err != nil {
+		return nil, err
+	}
+	debug("downloading %s", url)
+	resp, err := releaseClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %q: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %q: %s", name, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %q: %w", name, err)
+	}
+	return data, nil
+}
+
+// Returns the key to verify the releases with: the pinned one, or the one in the file for builds without one
+func selfUpdateKey(keyFile string) (ed25519.PublicKey, error) {
+	if releaseKey == "" {
+		if keyFile == "" {
+			return nil, fmt.Errorf("this build has no pinned release key to verify the update with, give it with --key")
+		}
+		return loadVerifyingKey(keyFile)
+	}
+	if keyFile != "" {
+		return nil, fmt.Errorf("this build verifies the releases with its pinned key, --key can't replace it")
+	}
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("the pinned release key of this build isn't a base64 Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Returns whether the release is newer than the build of the version. Releases are tagged with semantic versions; a
+// build of a later commit is as new as the tag it's described from, and a build without a semantic version, like a
+// dev build, can be updated to any release.
+func newerRelease(tag, current string) (bool, error) {
+	rel, err := utilversion.ParseSemantic(tag)
+	if err != nil {
+		return false, fmt.Errorf("release %q isn't tagged with a semantic version: %w", tag, err)
+	}
+	cur, err := utilversion.ParseSemantic(describeSuffix.ReplaceAllString(current, ""))
+	if err != nil {
+		debug("this build's version %q isn't a semantic version, any release is newer", current)
+		return true, nil
+	}
+	return cur.LessThan(rel), nil
+}
+
+// Returns what the signature of the checksums of a release signs: the tag, then the checksums, as make release signs
+// them, so the signed checksums of a release can't be passed off as another's
+func signedChecksums(tag string, checksums []byte) []byte {
+	return append([]byte("generate-sidecar-tool "+tag+"\n"), checksums...)
+}
+
+// Verifies the signature of the release checksums and tag with the public key
+func verifyChecksums(rel *release, checksums []byte, key ed25519.PublicKey) error {
+	encoded, err := downloadAsset(rel, checksumsSigAsset)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return fmt.Errorf("failed to decode %q: %w", checksumsSigAsset, err)
+	}
+	if !ed25519.Verify(key, signedChecksums(rel.TagName, checksums), sig) {
+		return fmt.Errorf("the signature of the checksums of release %s doesn't match the release key", rel.TagName)
+	}
+	return nil
+}
+
+// Verifies the data against its entry in the checksums, in the `sha256sum` format
+func verifyChecksum(checksums []byte, name string, data []byte) error {
+	sum := sha256.Sum256(data)
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if fields[0] != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("checksum of %q doesn't match the release checksums", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("the release checksums have no entry for %q", name)
+}
+
+// Replaces the running executable with the binary. The running one is moved aside first, as Windows doesn't allow
+// overwriting it.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+
+	next, old := exe+".new", exe+".old"
+	if err := os.WriteFile(next, binary, 0o755); err != nil {
+		return fmt.Errorf("failed to write %q: %w", next, err)
+	}
+	_ = os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		os.Remove(next)
+		return fmt.Errorf("failed to move %q aside: %w", exe, err)
+	}
+	if err := os.Rename(next, exe); err != nil {
+		_ = os.Rename(old, exe)
+		return fmt.Errorf("failed to replace %q: %w", exe, err)
+	}
+	// a running executable can't be removed on Windows; the next update removes it
+	if runtime.GOOS != "windows" {
+		_ = os.Remove(old)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewerRelease(t *testing.T) {
+	tests := []struct {
+		tag, current string
+		want         bool
+		wantErr      bool
+	}{
+		{tag: "v0.5.0", current: "v0.4.0", want: true},
+		{tag: "v0.4.0", current: "v0.5.0", want: false},
+		{tag: "v0.5.0", current: "v0.5.0", want: false},
+		{tag: "v0.5.0", current: "v0.5.0-rc.1", want: true},
+		{tag: "v0.4.0", current: "v0.4.0-3-gabc1234", want: false},
+		{tag: "v0.4.0", current: "v0.4.0-3-gabc1234-dirty", want: false},
+		{tag: "v0.4.1", current: "v0.4.0-3-gabc1234", want: true},
+		{tag: "v0.4.0", current: "dev", want: true},
+		{tag: "latest", current: "v0.4.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := newerRelease(tt.tag, tt.current)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("newerRelease(%q, %q) = %v, %v, want %v, wantErr %v", tt.tag, tt.current, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	binary := []byte("the new binary")
+	sum := sha256.Sum256(binary)
+	checksums := []byte(fmt.Sprintf("%s  generate-sidecar-tool_linux_amd64\n%s *generate-sidecar-tool_windows_amd64.exe\n",
+		hex.EncodeToString(sum[:]), hex.EncodeToString(sum[:])))
+	tests := []struct {
+		name    string
+		asset   string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "matching", asset: "generate-sidecar-tool_linux_amd64", data: binary},
+		{name: "binary mode entry", asset: "generate-sidecar-tool_windows_amd64.exe", data: binary},
+		{name: "tampered", asset: "generate-sidecar-tool_linux_amd64", data: []byte("another binary"), wantErr: true},
+		{name: "no entry", asset: "generate-sidecar-tool_darwin_arm64", data: binary, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyChecksum(checksums, tt.asset, tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("verifyChecksum(%q) error = %v, wantErr %v", tt.asset, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums := []byte("0123  generate-sidecar-tool_linux_amd64\n")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(private, signedChecksums("v0.4.0", checksums)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sig + "\n"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		tag       string
+		checksums []byte
+		key       ed25519.PublicKey
+		wantErr   bool
+	}{
+		{name: "signed", tag: "v0.4.0", checksums: checksums, key: public},
+		{name: "replayed as another release", tag: "v0.9.0", checksums: checksums, key: public, wantErr: true},
+		{name: "tampered checksums", tag: "v0.4.0", checksums: []byte("4567  generate-sidecar-tool_linux_amd64\n"), key: public, wantErr: true},
+		{name: "other key", tag: "v0.4.0", checksums: checksums, key: other, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel := &release{TagName: tt.tag}
+			rel.Assets = append(rel.Assets, struct {
+				Name string `json:"name"`
+				URL  string `json:"browser_download_url"`
+			}{Name: checksumsSigAsset, URL: server.URL})
+			if err := verifyChecksums(rel, tt.checksums, tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("verifyChecksums() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The body of POST /generate. Empty fields keep the configured window and scope.
+type GenerateRequest struct {
+	// Start and end of the window, in any of the --start and --end formats
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Namespaces and service FQNs to limit the generation to, like --scope-from
+	Scope []string `json:"scope,omitempty"`
+}
+
+// Wrapped by the errors of requests that are invalid, rather than failing to generate
+var errInvalidRequest = errors.New("invalid request")
+
+// The response of POST /generate
+type GenerateResponse struct {
+	Window Window `json:"window"`
+	// The generated resources, as the --output-template gets them
+	Resources   []any        `json:"resources"`
+	Report      []*ReportRow `json:"report"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+func newServeCmd(runtime *Runtime) *cobra.Command {
+	var listen, tokenFile string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the generation as a JSON API, for portals to generate on demand",
+		Long: `Serve the generation as a JSON API, for portals to generate on demand.
+
+POST /generate takes the window and scope in a JSON body, like {"start": "2024-01-01", "end": "2024-01-02",
+"scope": ["bookinfo"]}, and returns the generated resources, the report rows and the diagnostics as JSON. Nothing is
+applied. Requests are served one at a time, as generating is heavy on TSB. Requests to /generate must send the token
+of --auth-token-file as bearer token, as they use the TSB credentials of the server. GET /healthz returns 200 when
+serving.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if tokenFile == "" {
+				return fmt.Errorf("--auth-token-file is required, as /generate uses the TSB credentials of the server")
+			}
+			data, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return fmt.Errorf("failed to read --auth-token-file %q: %w", tokenFile, err)
+			}
+			token := strings.TrimSpace(string(data))
+			if token == "" {
+				return fmt.Errorf("--auth-token-file %q is empty", tokenFile)
+			}
+			addSecret(token)
+			server := &http.Server{
+				Addr:              listen,
+				Handler:           newServeMux(runtime, token),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			fmt.Fprintf(logOut, "serving on %s\n", listen)
+			return server.ListenAndServe()
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen on")
+	cmd.Flags().StringVar(&tokenFile, "auth-token-file", "", "File with the token the requests to /generate must send as bearer token")
+	return cmd
+}
+
+// Returns whether the request sends the token as bearer token
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func newServeMux(runtime *Runtime, token string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := serveGenerate(r.Context(), runtime, &req)
+		if errors.Is(err, errInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			warn("POST /generate failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			warn("failed to write the response: %v", err)
+		}
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// Generates for the window and scope of the request, in process
+func serveGenerate(ctx context.Context, runtime *Runtime, req *GenerateRequest) (*GenerateResponse, error) {
+	opts := GenerateOptions{Runtime: runtime, Scope: req.Scope}
+	var err error
+	if req.Start != "" {
+		if opts.Start, err = parseWindowTime(req.Start); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse start time %q: %v", errInvalidRequest, req.Start, err)
+		}
+	}
+	if req.End != "" {
+		if opts.End, err = parseWindowTime(req.End); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse end time %q: %v", errInvalidRequest, req.End, err)
+		}
+	}
+	if start, end := withDefault(opts.Start, runtime.start), withDefault(opts.End, runtime.end); !start.Before(end) {
+		return nil, fmt.Errorf("%w: the start of the window %s isn't before its end", errInvalidRequest, Window{Start: start, End: end})
+	}
+
+	result, err := Generate(ctx, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := resultsData(result.Resources, runtime.sidecarOutput)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadState(runtime.stateFile)
+	if err != nil {
+		return nil, err
+	}
+	// the report rows are of the window generated from
+	windowed := *runtime
+	windowed.start, windowed.end = result.Window.Start, result.Window.End
+
+	resp := &GenerateResponse{
+		Window:      result.Window,
+		Resources:   data["items"].([]any),
+		Report:      reportRows(&windowed, state, result.Graph, nameStyleFQN, nil),
+		Diagnostics: result.Diagnostics,
+	}
+	if resp.Diagnostics == nil {
+		resp.Diagnostics = []Diagnostic{}
+	}
+	return resp, nil
+}
+
+// Returns the time, or the default one if it's zero
+func withDefault(t, def time.Time) time.Time {
+	if t.IsZero() {
+		return def
+	}
+	return t
+}
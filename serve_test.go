@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "bearer token", header: "Bearer s3cret", want: true},
+		{name: "no header", header: "", want: false},
+		{name: "wrong token", header: "Bearer other", want: false},
+		{name: "token prefix", header: "Bearer s3cre", want: false},
+		{name: "basic auth", header: "Basic czNjcmV0", want: false},
+		{name: "no scheme", header: "s3cret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/generate", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := authorized(r, "s3cret"); got != tt.want {
+				t.Errorf("authorized(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRejectsUnauthorized(t *testing.T) {
+	mux := newServeMux(&Runtime{}, "s3cret")
+	tests := []struct {
+		name   string
+		method string
+		header string
+		want   int
+	}{
+		{name: "no token", method: http.MethodPost, want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, header: "Bearer other", want: http.StatusUnauthorized},
+		{name: "unauthorized GET", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "authorized GET", method: http.MethodGet, header: "Bearer s3cret", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/generate", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s /generate = %d, want %d", tt.method, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeGenerateRejectsInvalidWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runtime := &Runtime{start: start, end: start.Add(time.Hour)}
+	tests := []struct {
+		name string
+		req  GenerateRequest
+	}{
+		{name: "invalid start", req: GenerateRequest{Start: "yesterday"}},
+		{name: "invalid end", req: GenerateRequest{End: "tomorrow"}},
+		{name: "start after end", req: GenerateRequest{Start: "2024-01-01T02:00:00Z", End: "2024-01-01T01:00:00Z"}},
+		// the end of the runtime applies when the request has none
+		{name: "start after the default end", req: GenerateRequest{Start: "2024-01-01T02:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := serveGenerate(context.Background(), runtime, &tt.req); !errors.Is(err, errInvalidRequest) {
+				t.Errorf("serveGenerate() error = %v, want %v", err, errInvalidRequest)
+			}
+		})
+	}
+}
+
+func TestGenerateChecksBeforeFetching(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runtime := &Runtime{start: start, end: start.Add(time.Hour)}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Generate(canceled, GenerateOptions{Runtime: runtime}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Generate() error = %v for a canceled context, want %v", err, context.Canceled)
+	}
+	if _, err := Generate(context.Background(), GenerateOptions{Runtime: runtime, Start: start.Add(2 * time.Hour)}, nil); err == nil {
+		t.Errorf("Generate() didn't fail for a start after the end")
+	}
+	if runtime.start != start {
+		t.Errorf("Generate() changed the start of the runtime to %s", runtime.start)
+	}
+}
+
+func TestWithDefault(t *testing.T) {
+	def := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	other := def.Add(time.Hour)
+	if got := withDefault(time.Time{}, def); !got.Equal(def) {
+		t.Errorf("withDefault(zero) = %s, want %s", got, def)
+	}
+	if got := withDefault(other, def); !got.Equal(other) {
+		t.Errorf("withDefault(%s) = %s, want %s", other, got, other)
+	}
+}
+
+// An APIClient failing to list the services
+type failingClient struct{ APIClient }
+
+func (failingClient) GetServices() ([]Service, error)    { return nil, errors.New("TSB is down") }
+func (failingClient) BindContext(context.Context) func() { return func() {} }
+
+func TestGenerateWithoutRuntime(t *testing.T) {
+	if _, err := Generate(context.Background(), GenerateOptions{}, nil); err == nil {
+		t.Errorf("Generate() didn't fail without a runtime")
+	}
+}
+
+func TestGenerateCompletesOnError(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runtime := &Runtime{start: start, end: start.Add(time.Hour), client: failingClient{}}
+	var events []*Event
+	if _, err := Generate(context.Background(), GenerateOptions{Runtime: runtime}, func(e *Event) { events = append(events, e) }); err == nil {
+		t.Fatalf("Generate() didn't fail when listing the services did")
+	}
+	if len(events) < 2 || events[0].Type != eventRunStarted || events[len(events)-1].Type != eventRunCompleted {
+		t.Fatalf("Generate() sent %d events, want %s first and %s last", len(events), eventRunStarted, eventRunCompleted)
+	}
+	if completed := events[len(events)-1]; !strings.Contains(completed.Error, "TSB is down") {
+		t.Errorf("Generate() completed with error %q, want the one of the run", completed.Error)
+	}
+}
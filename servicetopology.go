@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Returns the topology the graph is built from. When the run is scoped, with --tenant, --scope-from or --owner, or
+// only regenerates the changed groups, only the topology of the services in scope is queried rather than the global
+// one, as it's much cheaper on large meshes and the calls of the other services would be dropped anyway.
+func fetchTopology(runtime *Runtime, services []Service, start, end time.Time) (*TopologyResponse, error) {
+	if !runtime.tenantScoped && len(runtime.scope) == 0 && runtime.owner == "" && runtime.changed == nil {
+		return runtime.client.GetTopology(start, end)
+	}
+	if runtime.owner != "" {
+		services = ownedServices(runtime, services)
+	}
+	if runtime.changed != nil {
+		var err error
+		if services, err = changedServices(runtime, services); err != nil {
+			return nil, err
+		}
+	}
+	ids := scopedServiceIDs(runtime.scope, services)
+	if len(ids) == 0 {
+		debug("no services in scope, skipping the topology query")
+		return &TopologyResponse{}, nil
+	}
+	debug("querying the topology of the %d services in scope", len(ids))
+	return runtime.client.GetServicesTopology(ids, start, end)
+}
+
+// Returns the skywalking IDs of the services, of the ones in scope if there is one
+func scopedServiceIDs(scope []string, services []Service) []string {
+	ids := []string{}
+	for i := range services {
+		svc := &services[i]
+		if len(scope) > 0 && !slices.Contains(scope, svc.FQN) && !containsAny(scope, parseNamespace(svc)) {
+			continue
+		}
+		for _, metric := range svc.Metrics {
+			ids = append(ids, skywalkingServiceID(metric.AggregationKey))
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// Returns the ID skywalking gives to the normal (real) service with the name, which is the aggregation key of a TSB
+// service: its base64 encoding followed by ".1"
+func skywalkingServiceID(name string) string {
+	return base64.StdEncoding.EncodeToString([]byte(name)) + ".1"
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Sessions with the TSB servers, with --login-path: the credentials are only sent to log in, once per server, and the
+// session cookie the login sets is sent instead of them from then on. It saves validating the credentials on every
+// request, which some IAM setups throttle.
+type sessions struct {
+	// path of the login endpoint, sessions aren't used if empty
+	loginPath string
+
+	mu sync.Mutex
+	// servers logged in to
+	active map[string]bool
+}
+
+// Returns a copy of the client that keeps the cookies the servers set, for the sessions
+func withCookieJar(client *http.Client) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
+	}
+	return &http.Client{Transport: client.Transport, Jar: jar, Timeout: client.Timeout}, nil
+}
+
+// Sets the credentials the requests to TSB are authenticated with: the token, or the username and password
+func (c *TSBHttpClient) authenticate(req *http.Request) error {
+	token := c.token
+	if c.tokenFile != "" {
+		var err error
+		if token, err = readTokenFile(c.tokenFile); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("x-tetrate-token", token)
+	} else {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return nil
+}
+
+// Returns a hash of the credentials the requests are sent with: the username and password or the token, as read from
+// the --token-file now, and the --header values, which may carry the credentials of a proxy in front of TSB. Sessions
+// are logged in to with those same credentials. The cached responses are keyed by it, as different credentials may
+// see different data.
+func (c *TSBHttpClient) credentialKey() (string, error) {
+	token := c.token
+	if c.tokenFile != "" {
+		var err error
+		if token, err = readTokenFile(c.tokenFile); err != nil {
+			return "", err
+		}
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", c.username, c.password, token, c.sessions.loginPath)
+	names := maps.Keys(c.headers)
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %q\n", name, c.headers[name])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Logs in to the server of the URL with the credentials, unless already logged in to it
+func (c *TSBHttpClient) login(u *url.URL) error {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	if c.sessions.active[u.Host] {
+		return nil
+	}
+
+	loginURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: c.sessions.loginPath}
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodPost, loginURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := c.authenticate(req); err != nil {
+		return err
+	}
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
+	debug("logging in to %q", loginURL.String())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to log in to %q: %w", u.Host, err)
+	}
+	// drained, so the connection is kept alive for the requests of the session
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to log in to %q: unexpected status %d", u.Host, resp.StatusCode)
+	}
+	cookies := c.client.Jar.Cookies(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"})
+	if len(cookies) == 0 {
+		return fmt.Errorf("failed to log in to %q: the response to %s set no session cookie", u.Host, c.sessions.loginPath)
+	}
+	for _, cookie := range cookies {
+		addSecret(cookie.Value)
+	}
+
+	if c.sessions.active == nil {
+		c.sessions.active = make(map[string]bool)
+	}
+	c.sessions.active[u.Host] = true
+	debug("logged in to %q, sending the session cookie from now on", u.Host)
+	return nil
+}
+
+// Forgets the session with the server, e.g. when it expired, so the next request logs in again
+func (c *TSBHttpClient) expireSession(host string) {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	delete(c.sessions.active, host)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Reads the PEM encoded (PKCS #8) Ed25519 private key to sign the bundles with
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %q: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %q is a %T, only Ed25519 keys are supported", path, key)
+	}
+	return edKey, nil
+}
+
+// Reads the PEM encoded (PKIX) Ed25519 public key to verify the bundles with
+func loadVerifyingKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %q: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %q is a %T, only Ed25519 keys are supported", path, key)
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %q: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in key %q", path)
+	}
+	return block, nil
+}
+
+// Writes the detached signature of the bundle to the file, base64 encoded. Ed25519 signs the bundle itself, so
+// the signature can also be checked with e.g. `openssl pkeyutl -verify -rawin`.
+func signBundle(key ed25519.PrivateKey, bundle []byte, path string) error {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, bundle))
+	if err := os.WriteFile(path, []byte(sig+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write signature %q: %w", path, err)
+	}
+	debug("signed bundle of %d bytes into %q", len(bundle), path)
+	return nil
+}
+
+func newVerifyBundleCmd() *cobra.Command {
+	var keyFile, sigFile string
+	cmd := &cobra.Command{
+		Use:   "verify-bundle <bundle>",
+		Short: "Verify that a bundle of generated resources matches its --sign-key signature",
+		Args:  cobra.ExactArgs(1),
+		// verifying doesn't talk to TSB, so it needs none of the root command setup
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" || sigFile == "" {
+				return fmt.Errorf("--key and --signature are required")
+			}
+			key, err := loadVerifyingKey(keyFile)
+			if err != nil {
+				return err
+			}
+			bundle, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read bundle %q: %w", args[0], err)
+			}
+			encoded, err := os.ReadFile(sigFile)
+			if err != nil {
+				return fmt.Errorf("failed to read signature %q: %w", sigFile, err)
+			}
+			sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+			if err != nil {
+				return fmt.Errorf("failed to decode signature %q: %w", sigFile, err)
+			}
+			if !ed25519.Verify(key, bundle, sig) {
+				return fmt.Errorf("signature %q doesn't match bundle %q; it was altered or signed with another key", sigFile, args[0])
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "bundle %q verified\n", args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM file with the Ed25519 public key of the --sign-key. REQUIRED")
+	cmd.Flags().StringVar(&sigFile, "signature", "", "File with the signature written by --signature-file. REQUIRED")
+	return cmd
+}
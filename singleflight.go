@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Wraps an APIClient so that concurrent GetTrafficSettings calls for the same group share a single request to TSB,
+// as groups are often shared by many services. The rest of the calls go straight to the wrapped client.
+type sharedSettingsClient struct {
+	APIClient
+
+	mu       sync.Mutex
+	settings map[string]*settingsCall
+}
+
+// A GetTrafficSettings call for a group, in flight until done is closed
+type settingsCall struct {
+	done     chan struct{}
+	settings *trafficv2.TrafficSetting
+	err      error
+}
+
+// compile-time assert we satisfy the interface we intend to
+var _ APIClient = &sharedSettingsClient{}
+
+func newSharedSettingsClient(client APIClient) *sharedSettingsClient {
+	return &sharedSettingsClient{APIClient: client, settings: make(map[string]*settingsCall)}
+}
+
+// Returns the TrafficSetting for the group, from the call in flight for it if there is one. Every caller gets its own copy,
+// since the generators modify the settings they get.
+func (c *sharedSettingsClient) GetTrafficSettings(groupFQN string) (*trafficv2.TrafficSetting, error) {
+	c.mu.Lock()
+	call, ok := c.settings[groupFQN]
+	if !ok {
+		call = &settingsCall{done: make(chan struct{})}
+		c.settings[groupFQN] = call
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-call.done
+		debug("reusing traffic settings of %q", groupFQN)
+	} else {
+		call.settings, call.err = c.APIClient.GetTrafficSettings(groupFQN)
+		// only calls in flight are shared, so later runs of a --schedule daemon get fresh settings
+		c.mu.Lock()
+		delete(c.settings, groupFQN)
+		c.mu.Unlock()
+		close(call.done)
+	}
+
+	if call.err != nil || call.settings == nil {
+		return nil, call.err
+	}
+	return proto.Clone(call.settings).(*trafficv2.TrafficSetting), nil
+}
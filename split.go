@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strings"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	splitSettingsByGroup     = "group"
+	splitSettingsByNamespace = "namespace"
+)
+
+// Splits the BRIDGED traffic groups whose source namespaces need different hosts into a group per namespace,
+// selecting only it, so each gets a TrafficSetting with the hosts of its own namespace instead of the union of all
+// of them. The group of namespace ns in group g is g-ns, in the same workspace, and g no longer selects ns. Groups whose
+// namespaces all need the same hosts are kept, and so are the ones whose selector can't be narrowed. Returns the
+// resources for the new groups and the split ones.
+func splitSettings(client APIClient, graph *Graph) ([]*typesv2.Object, error) {
+	// group FQN => source namespace => destination namespaces
+	profiles := make(map[string]map[string][]string)
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil || call.SourceTrafficGroup.ConfigMode == configModeDirect {
+			continue
+		}
+		groupFQN := call.SourceTrafficGroup.FQN
+		if profiles[groupFQN] == nil {
+			profiles[groupFQN] = make(map[string][]string)
+		}
+		for _, ns := range call.SourceNamespaces {
+			profiles[groupFQN][ns] = mergeSorted(profiles[groupFQN][ns], call.TargetNamespaces)
+		}
+	}
+
+	// group FQN of the split groups => namespace => its new group
+	split := make(map[string]map[string]*TrafficGroup)
+	var results []*typesv2.Object
+	groupFQNs := maps.Keys(profiles)
+	slices.Sort(groupFQNs)
+	for _, groupFQN := range groupFQNs {
+		if !distinctProfiles(profiles[groupFQN]) {
+			continue
+		}
+		meta, err := bridgedModeMeta(groupFQN)
+		if err != nil {
+			// generating the settings reports it
+			continue
+		}
+		namespaces := maps.Keys(profiles[groupFQN])
+		slices.Sort(namespaces)
+		objs, groups, err := splitGroup(client, groupFQN, meta, namespaces)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			split[groupFQN] = groups
+			results = append(results, objs...)
+		}
+	}
+	if len(split) == 0 {
+		return results, nil
+	}
+
+	calls := make([]*Call, 0, len(graph.Calls))
+	for _, call := range graph.Calls {
+		if call.SourceTrafficGroup == nil || split[call.SourceTrafficGroup.FQN] == nil {
+			calls = append(calls, call)
+			continue
+		}
+		groups := split[call.SourceTrafficGroup.FQN]
+		for _, ns := range call.SourceNamespaces {
+			c := *call
+			c.SourceNamespaces = []string{ns}
+			c.SourceTrafficGroup = groups[ns]
+			explainf(explainGraph, "call %s: source namespace %q gets its own traffic group %s by --split-settings-by", call.ID, ns, groups[ns].FQN)
+			calls = append(calls, &c)
+		}
+	}
+	graph.Calls = calls
+	return results, nil
+}
+
+// Splits the traffic group into a group per namespace, returning the resources of the new groups and of the group
+// with its namespace selector no longer selecting them, and the new group of each namespace. The new groups select
+// their namespace in the clusters the group did. A group whose selector can't be narrowed, with a wildcard namespace
+// or only the split namespaces, isn't split, as its namespaces would belong to two groups: it's reported for its
+// selector to be changed by hand, and no groups are returned.
+func splitGroup(client APIClient, groupFQN string, meta *typesv2.ObjectMeta, namespaces []string) ([]*typesv2.Object, map[string]*TrafficGroup, error) {
+	group, err := client.GetTrafficGroup(groupFQN)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := group.GetNamespaceSelector().GetNames()
+	kept, ok := narrowSelector(names, namespaces)
+	if !ok {
+		diagnose(diagSplitSelector, groupFQN, strings.Join(namespaces, ", "))
+		return nil, nil, nil
+	}
+
+	var results []*typesv2.Object
+	groups := make(map[string]*TrafficGroup, len(namespaces))
+	newFQNs := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		groups[ns] = &TrafficGroup{ConfigMode: configModeBridged, FQN: groupFQN + "-" + ns}
+		newFQNs = append(newFQNs, groups[ns].FQN)
+		obj, err := newObject(api.TrafficAPI, trafficGroupKind,
+			&typesv2.ObjectMeta{Organization: meta.Organization, Tenant: meta.Tenant, Workspace: meta.Workspace, Name: meta.Group + "-" + ns},
+			&trafficv2.Group{
+				NamespaceSelector: &typesv2.NamespaceSelector{Names: namespaceNames(names, ns)},
+				ConfigMode:        typesv2.ConfigMode_BRIDGED,
+			})
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, obj)
+	}
+	diagnose(diagSplitGroup, groupFQN, strings.Join(newFQNs, ", "))
+
+	explainf(explainPolicy, "Group %s: no longer selecting %v, they get their own traffic groups", groupFQN, namespaces)
+	group.NamespaceSelector = &typesv2.NamespaceSelector{Names: kept}
+	obj, err := newObject(api.TrafficAPI, trafficGroupKind,
+		&typesv2.ObjectMeta{Organization: meta.Organization, Tenant: meta.Tenant, Workspace: meta.Workspace, Name: meta.Group},
+		group)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(results, obj), groups, nil
+}
+
+// Returns the <cluster>/<namespace> names of a namespace selector selecting the namespace, or the namespace in every
+// cluster if none does, as it was observed in the group anyway
+func namespaceNames(names []string, ns string) []string {
+	var selected []string
+	for _, name := range names {
+		if _, n, _ := strings.Cut(name, "/"); n == ns {
+			selected = append(selected, name)
+		}
+	}
+	if len(selected) == 0 {
+		return []string{"*/" + ns}
+	}
+	return selected
+}
+
+// Returns the <cluster>/<namespace> names of a namespace selector without the ones of the namespaces, or false if
+// that can't be done: a wildcard namespace selects them anyway, and a selector left empty would select nothing
+func narrowSelector(names, namespaces []string) ([]string, bool) {
+	var kept []string
+	for _, name := range names {
+		_, ns, _ := strings.Cut(name, "/")
+		if ns == "*" {
+			return nil, false
+		}
+		if !slices.Contains(namespaces, ns) {
+			kept = append(kept, name)
+		}
+	}
+	return kept, len(kept) > 0
+}
+
+// Returns whether the namespaces don't all need the same destinations
+func distinctProfiles(profiles map[string][]string) bool {
+	var first []string
+	seen := false
+	for _, destinations := range profiles {
+		if !seen {
+			first, seen = destinations, true
+		} else if !slices.Equal(first, destinations) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+
+	trafficv2 "github.com/tetrateio/api/tsb/traffic/v2"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/slices"
+)
+
+func TestDistinctProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles map[string][]string
+		want     bool
+	}{
+		{name: "none", profiles: map[string][]string{}, want: false},
+		{name: "single namespace", profiles: map[string][]string{"a": {"x"}}, want: false},
+		{name: "same destinations", profiles: map[string][]string{"a": {"x", "y"}, "b": {"x", "y"}}, want: false},
+		{name: "different destinations", profiles: map[string][]string{"a": {"x"}, "b": {"x", "y"}}, want: true},
+		{name: "one without destinations", profiles: map[string][]string{"a": {"x"}, "b": nil}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := distinctProfiles(tt.profiles); got != tt.want {
+				t.Errorf("distinctProfiles(%v) = %v, want %v", tt.profiles, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNarrowSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		names      []string
+		namespaces []string
+		want       []string
+		wantOK     bool
+	}{
+		{name: "removes the split namespaces", names: []string{"*/a", "*/b", "*/c"}, namespaces: []string{"a", "b"}, want: []string{"*/c"}, wantOK: true},
+		{name: "in any cluster", names: []string{"c1/a", "c2/a", "c1/c"}, namespaces: []string{"a"}, want: []string{"c1/c"}, wantOK: true},
+		{name: "nothing to remove", names: []string{"*/c"}, namespaces: []string{"a"}, want: []string{"*/c"}, wantOK: true},
+		{name: "wildcard namespace", names: []string{"*/*"}, namespaces: []string{"a"}, wantOK: false},
+		{name: "wildcard among others", names: []string{"*/c", "c1/*"}, namespaces: []string{"a"}, wantOK: false},
+		{name: "only the split namespaces", names: []string{"*/a", "*/b"}, namespaces: []string{"a", "b"}, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := narrowSelector(tt.names, tt.namespaces)
+			if ok != tt.wantOK || !slices.Equal(got, tt.want) {
+				t.Errorf("narrowSelector(%v, %v) = %v, %v, want %v, %v", tt.names, tt.namespaces, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNamespaceNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		ns    string
+		want  []string
+	}{
+		{name: "every cluster", names: []string{"*/a", "*/b"}, ns: "a", want: []string{"*/a"}},
+		{name: "the clusters of the group", names: []string{"c1/a", "c2/a", "c1/b"}, ns: "a", want: []string{"c1/a", "c2/a"}},
+		{name: "not selected by name", names: []string{"c1/b"}, ns: "a", want: []string{"*/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := namespaceNames(tt.names, tt.ns); !slices.Equal(got, tt.want) {
+				t.Errorf("namespaceNames(%v, %q) = %v, want %v", tt.names, tt.ns, got, tt.want)
+			}
+		})
+	}
+}
+
+// An APIClient returning the traffic group with the namespace selector
+type groupClient struct {
+	APIClient
+	names []string
+}
+
+func (c groupClient) GetTrafficGroup(string) (*trafficv2.Group, error) {
+	return &trafficv2.Group{NamespaceSelector: &typesv2.NamespaceSelector{Names: c.names}}, nil
+}
+
+func TestSplitSettingsSkipsWildcardGroups(t *testing.T) {
+	diagnostics = nil
+	defer func() { diagnostics = nil }()
+	group := &TrafficGroup{ConfigMode: configModeBridged, FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/g"}
+	graph := &Graph{Calls: []*Call{
+		{ID: "1", SourceTrafficGroup: group, SourceNamespaces: []string{"a"}, TargetNamespaces: []string{"x"}},
+		{ID: "2", SourceTrafficGroup: group, SourceNamespaces: []string{"b"}, TargetNamespaces: []string{"y"}},
+	}}
+	calls := slices.Clone(graph.Calls)
+
+	results, err := splitSettings(groupClient{names: []string{"*/*"}}, graph)
+	if err != nil {
+		t.Fatalf("splitSettings() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("splitSettings() = %d resources, want none for a group it can't narrow", len(results))
+	}
+	if !slices.Equal(graph.Calls, calls) {
+		t.Errorf("splitSettings() rerouted the calls of a group it didn't split")
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Code != diagSplitSelector {
+		t.Errorf("splitSettings() diagnostics = %v, want one %s", diagnostics, diagSplitSelector)
+	}
+}
+
+func TestSplitSettingsKeepsUniformGroups(t *testing.T) {
+	group := &TrafficGroup{ConfigMode: configModeBridged, FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/g"}
+	direct := &TrafficGroup{ConfigMode: configModeDirect, FQN: "organizations/o/tenants/t/workspaces/w/trafficgroups/d"}
+	graph := &Graph{Calls: []*Call{
+		{ID: "1", SourceTrafficGroup: group, SourceNamespaces: []string{"a", "b"}, TargetNamespaces: []string{"x"}},
+		{ID: "2", SourceTrafficGroup: direct, SourceNamespaces: []string{"c"}, TargetNamespaces: []string{"x"}},
+		{ID: "3", SourceTrafficGroup: direct, SourceNamespaces: []string{"d"}, TargetNamespaces: []string{"y"}},
+		{ID: "4", SourceNamespaces: []string{"e"}, TargetNamespaces: []string{"z"}},
+	}}
+	calls := slices.Clone(graph.Calls)
+
+	// none of the groups is split, so TSB isn't asked for any of them
+	results, err := splitSettings(nil, graph)
+	if err != nil {
+		t.Fatalf("splitSettings() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("splitSettings() = %d resources, want none", len(results))
+	}
+	if !slices.Equal(graph.Calls, calls) {
+		t.Errorf("splitSettings() changed the calls of the graph")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// What we persist about each successful run in the --state-file
+type State struct {
+	// When the run finished
+	Time time.Time `json:"time"`
+	// The topology window the run was generated from
+	Window Window `json:"window"`
+	// Edges of the graph the run was generated from, sorted by Edge.Key
+	Edges []*Edge `json:"edges"`
+	// Resources the run generated, to find the ones a later run should prune
+	Resources []GeneratedResource `json:"resources,omitempty"`
+	// Revision the resources were labeled with, to find the stale ones in the clusters
+	Revision string `json:"revision,omitempty"`
+	// Changes --apply-max-risk queued for approval
+	Pending []*PendingChange `json:"pending,omitempty"`
+}
+
+// Loads the state of the previous run. Returns nil without error if there is no state file configured or
+// it doesn't exist yet, i.e. on the first run.
+func loadState(path string) (*State, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		debug("no state file %q yet", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %q: %w", path, err)
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %q: %w", path, err)
+	}
+	debug("loaded state of the run at %s with %d edges", state.Time, len(state.Edges))
+	return state, nil
+}
+
+// Persists the graph of the run and the resources generated from it in the state file, if one is configured.
+// Edges keep the time they were first seen at in the previous state.
+func saveState(runtime *Runtime, previous *State, graph *Graph, results []*typesv2.Object, revision string) error {
+	if runtime.stateFile == "" {
+		return nil
+	}
+
+	edges := graphEdges(graph)
+	setFirstSeen(runtime, previous, edges)
+	keys := maps.Keys(edges)
+	slices.Sort(keys)
+	state := &State{
+		Time:      time.Now(),
+		Window:    Window{Start: runtime.start, End: runtime.end},
+		Edges:     make([]*Edge, 0, len(keys)),
+		Resources: generatedResources(runtime, results),
+		Revision:  revision,
+	}
+	for _, k := range keys {
+		state.Edges = append(state.Edges, edges[k])
+	}
+	// the changes queued by this run supersede the ones queued before, unless it didn't apply
+	state.Pending = pendingChanges
+	if !runtime.apply && previous != nil {
+		state.Pending = previous.Pending
+	}
+	if err := writeState(runtime.stateFile, state); err != nil {
+		return err
+	}
+	debug("saved state with %d edges to %q", len(state.Edges), runtime.stateFile)
+	return nil
+}
+
+// Records the changes queued by a partial run, of --watch-changes or --owner, in the previous state, so they can be
+// approved: they replace the ones queued before for the same resources, and the rest of the state is kept as it is,
+// for the next full run to compare against. Without a previous state, one with only the queued changes is written.
+func savePending(runtime *Runtime, previous *State) error {
+	if runtime.stateFile == "" || len(pendingChanges) == 0 {
+		return nil
+	}
+	state := &State{}
+	if previous != nil {
+		*state = *previous
+	}
+	state.Pending = mergePending(state.Pending, pendingChanges)
+	if err := writeState(runtime.stateFile, state); err != nil {
+		return err
+	}
+	debug("saved %d changes queued for approval to %q", len(state.Pending), runtime.stateFile)
+	return nil
+}
+
+// Returns the queued changes with the ones queued since, which replace the earlier ones for the same resources
+func mergePending(queued, since []*PendingChange) []*PendingChange {
+	var merged []*PendingChange
+	for _, c := range queued {
+		if !slices.ContainsFunc(since, func(s *PendingChange) bool { return s.Resource == c.Resource }) {
+			merged = append(merged, c)
+		}
+	}
+	return append(merged, since...)
+}
+
+// Writes the state to the file
+func writeState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	// write to a temporary file first, so that a failure never leaves a truncated state behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write state file %q: %w", path, err)
+	}
+	return nil
+}
+
+// Sets when each edge was first seen: in the previous state if it has the edge, or in the window of this run otherwise
+func setFirstSeen(runtime *Runtime, previous *State, edges map[string]*Edge) {
+	seen := make(map[string]time.Time)
+	if previous != nil {
+		for _, e := range previous.Edges {
+			seen[e.Key()] = e.FirstSeen
+		}
+	}
+	for k, e := range edges {
+		if t, ok := seen[k]; ok && !t.IsZero() {
+			e.FirstSeen = t
+		} else {
+			e.FirstSeen = runtime.start
+		}
+	}
+}
+
+// Refuses to generate from a topology that is empty, or much smaller than the one of the previous run, as
+// it is usually caused by the telemetry being down during the window rather than by services going away.
+func checkShrink(runtime *Runtime, previous *State, graph *Graph) error {
+	if runtime.allowShrink {
+		return nil
+	}
+	if len(graph.Calls) == 0 {
+		return fmt.Errorf("the topology between %s and %s has no calls; refusing to generate policies that would cut all "+
+			"connectivity, use --allow-shrink if this is intended", runtime.start.Format(DATE_FORMAT), runtime.end.Format(DATE_FORMAT))
+	}
+	if previous == nil || len(previous.Edges) == 0 {
+		return nil
+	}
+
+	current := len(graphEdges(graph))
+	if float64(current) < float64(len(previous.Edges))*runtime.shrinkThreshold {
+		return fmt.Errorf("the topology has %d edges, far fewer than the %d of the previous run at %s; refusing to generate "+
+			"shrinking policies, use --allow-shrink if this is intended", current, len(previous.Edges), previous.Time.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Drops from the topology the calls whose success rate in the window is at most --min-success-rate, so that
+// traffic that never worked (failed connection attempts, scans) doesn't become allowed reachability. Calls
+// without metrics are kept, and so are the calls left when the run exceeds its --budget with
+// --on-budget-exceeded=degrade.
+func excludeErrorOnlyCalls(runtime *Runtime, top *TopologyResponse, start, end time.Time) error {
+	names := make(map[string]string, len(top.Nodes))
+	for _, node := range top.Nodes {
+		names[node.ID] = node.AggregationKey
+	}
+
+	calls := top.Calls[:0]
+	excluded := 0
+	for i, call := range top.Calls {
+		if err := timing.checkProgress(); err != nil {
+			return err
+		}
+		if timing.skip("the success rates of the calls left") {
+			calls = append(calls, top.Calls[i:]...)
+			break
+		}
+		source, target := names[call.Source], names[call.Target]
+		rate, ok, err := runtime.client.GetCallSuccessRate(source, target, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to get the success rate of call %s: %w", call.ID, err)
+		}
+		if ok && rate <= runtime.minSuccessRate {
+			diagnose(diagErrorOnlyCall, call.ID, source, target, rate*100)
+			explainf(explainGraph, "call %s: skipped, success rate %.2f%% is at most --min-success-rate", call.ID, rate*100)
+			excluded++
+			continue
+		}
+		calls = append(calls, call)
+	}
+	top.Calls = calls
+	debug("excluded %d error-only calls", excluded)
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// Value of --use-tctl-config given without a profile, to use the current one
+const tctlCurrentProfile = "current"
+
+// The parts of the tctl config file the tool reuses
+type tctlConfig struct {
+	CurrentProfile string `json:"current_profile"`
+	Profiles       []struct {
+		Name         string `json:"name"`
+		Cluster      string `json:"cluster"`
+		Username     string `json:"username"`
+		Organization string `json:"organization"`
+	} `json:"profiles"`
+	Clusters []struct {
+		Name string `json:"name"`
+		API  struct {
+			URL      string `json:"url"`
+			Insecure bool   `json:"insecure"`
+			// PEM certificates to trust, for servers with a private CA
+			CABundle string `json:"ca_bundle"`
+		} `json:"api"`
+	} `json:"clusters"`
+	Users []struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	} `json:"users"`
+}
+
+// Returns whether the tool runs as a tctl plugin, i.e. tctl found it in the PATH as tctl-<name>
+func isTctlPlugin() bool {
+	return strings.HasPrefix(filepath.Base(os.Args[0]), "tctl-")
+}
+
+// Returns the path of tctl's config file: $TCTL_CONFIG, or ~/.tctl/config.yaml like tctl on every OS
+func tctlConfigPath() (string, error) {
+	if path := os.Getenv("TCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the tctl config: %w", err)
+	}
+	return filepath.Join(home, ".tctl", "config.yaml"), nil
+}
+
+// Sets the server, TLS, credentials and organization flags not given in the command line from the tctl profile, or
+// its current profile for tctlCurrentProfile, so tctl users don't configure them twice. Like applyConfigFile, this
+// runs before logging is set up, so it can't debug log.
+func applyTctlConfig(profile string, flags *pflag.FlagSet, cfg *Config) error {
+	path, err := tctlConfigPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the tctl config %q: %w", path, err)
+	}
+	tctl := &tctlConfig{}
+	if err := yaml.Unmarshal(data, tctl); err != nil {
+		return fmt.Errorf("failed to parse the tctl config %q: %w", path, err)
+	}
+	if profile == tctlCurrentProfile {
+		profile = tctl.CurrentProfile
+	}
+
+	values := make(map[string]string)
+	found := false
+	for _, p := range tctl.Profiles {
+		if p.Name != profile {
+			continue
+		}
+		found = true
+		values["org"] = p.Organization
+		for _, c := range tctl.Clusters {
+			if c.Name != p.Cluster {
+				continue
+			}
+			values["server"] = c.API.URL
+			values["insecure"] = fmt.Sprint(c.API.Insecure)
+			if c.API.CABundle != "" && !flags.Changed("ca-cert") {
+				cfg.caBundle = []byte(c.API.CABundle)
+			}
+		}
+		for _, u := range tctl.Users {
+			if u.Name == p.Username {
+				values["http-auth-user"] = u.Username
+				values["http-auth-password"] = u.Password
+				values["token"] = u.Token
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("the tctl config %q has no profile %q", path, profile)
+	}
+	for name, value := range values {
+		flag := flags.Lookup(name)
+		if value == "" || flag == nil || flag.Changed {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("failed to set %q from the tctl profile %q: %w", name, profile, err)
+		}
+	}
+	return nil
+}
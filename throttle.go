@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// how long to pause when a rate-limited response has no usable Retry-After
+	defaultRetryAfter = time.Second
+	// how many times a rate-limited request is retried before giving up
+	maxThrottledRetries = 5
+)
+
+// Pauses every request to TSB while it is rate limiting us, rather than each request failing or retrying on its own
+type throttle struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Blocks until the pause, if any, is over
+func (t *throttle) wait() {
+	t.mu.Lock()
+	until := t.until
+	t.mu.Unlock()
+	if d := time.Until(until); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Pauses the requests for the given time, unless they already are for longer
+func (t *throttle) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// Returns how long the Retry-After header of the response asks to wait, in seconds or as an HTTP date
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// How much the run was rate limited, for the --summary-file
+type Throttling struct {
+	// Number of rate-limited responses
+	Responses int `json:"responses"`
+	// Total time requests were paused for
+	Paused string `json:"paused"`
+
+	paused time.Duration
+}
+
+var (
+	throttlingMu sync.Mutex
+	// nil until the run is rate limited
+	throttling *Throttling
+)
+
+// Records a rate-limited response and the pause it caused
+func recordThrottling(pause time.Duration) {
+	throttlingMu.Lock()
+	defer throttlingMu.Unlock()
+	if throttling == nil {
+		throttling = &Throttling{}
+	}
+	throttling.Responses++
+	throttling.paused += pause
+	throttling.Paused = throttling.paused.String()
+}
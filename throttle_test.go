@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// bounds of the expected pause, as dates are relative to now
+		min, max time.Duration
+	}{
+		{name: "seconds", value: "30", min: 30 * time.Second, max: 30 * time.Second},
+		{name: "zero", value: "0", min: 0, max: 0},
+		{name: "future date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{name: "past date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+		{name: "missing", value: "", min: defaultRetryAfter, max: defaultRetryAfter},
+		{name: "negative", value: "-5", min: defaultRetryAfter, max: defaultRetryAfter},
+		{name: "invalid", value: "soon", min: defaultRetryAfter, max: defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(header); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q) = %s, want between %s and %s", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+)
+
+// Classes of calls by the time of day they happen at, with --time-of-day
+const (
+	timeOfDayBusinessHours = "business-hours"
+	timeOfDayNightlyBatch  = "nightly-batch"
+	timeOfDayConstant      = "constant"
+
+	// share of the traffic of a call that has to happen in or out of business hours for it to be classified as such
+	timeOfDayShare = 0.9
+)
+
+const timeOfDayAnnotation = "generate-sidecar-tool/time-of-day"
+
+// Parses --business-hours, as start-end hours of the day, e.g. 9-18 for 09:00 to 18:00
+func parseBusinessHours(value string) ([2]int, error) {
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return [2]int{}, fmt.Errorf("invalid --business-hours %q, must be start-end hours, e.g. 9-18", value)
+	}
+	from, err := strconv.Atoi(start)
+	if err != nil || from < 0 || from > 23 {
+		return [2]int{}, fmt.Errorf("invalid --business-hours %q: start must be an hour between 0 and 23", value)
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil || to <= from || to > 24 {
+		return [2]int{}, fmt.Errorf("invalid --business-hours %q: end must be an hour after the start, up to 24", value)
+	}
+	return [2]int{from, to}, nil
+}
+
+// Returns the class of a call from its calls per minute in each hour of the window, starting at the hour of start:
+// business-hours or nightly-batch when almost all of its traffic happens in or out of the business hours, and
+// constant otherwise. Returns "" for calls without traffic.
+func classifyTimeOfDay(hourly []int64, start time.Time, businessHours [2]int, location *time.Location) string {
+	var in, total int64
+	hour := start.Truncate(time.Hour)
+	for _, cpm := range hourly {
+		h := hour.In(location).Hour()
+		if h >= businessHours[0] && h < businessHours[1] {
+			in += cpm
+		}
+		total += cpm
+		hour = hour.Add(time.Hour)
+	}
+	switch {
+	case total == 0:
+		return ""
+	case float64(in) >= timeOfDayShare*float64(total):
+		return timeOfDayBusinessHours
+	case float64(total-in) >= timeOfDayShare*float64(total):
+		return timeOfDayNightlyBatch
+	}
+	return timeOfDayConstant
+}
+
+// Classifies the calls of the graph by the time of day they happen at, from their hourly metrics in the window.
+// Reversed and east-west calls take the class of the observed call they come from. The calls left when the run
+// exceeds its --budget with --on-budget-exceeded=degrade aren't classified.
+func classifyCalls(runtime *Runtime, graph *Graph, start, end time.Time) error {
+	classes := make(map[string]string)
+	for _, call := range graph.Calls {
+		if _, ok := classes[call.ID]; ok || call.Mirrored || call.EastWest {
+			continue
+		}
+		if err := timing.checkProgress(); err != nil {
+			return err
+		}
+		if timing.skip("the time of day classification of the calls left") {
+			break
+		}
+		hourly, err := runtime.client.GetCallHourlyThroughput(call.SourceNode, call.TargetNode, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to get the hourly throughput of call %s: %w", call.ID, err)
+		}
+		classes[call.ID] = classifyTimeOfDay(hourly, start, runtime.businessHours, runtime.businessLocation)
+		explainf(explainGraph, "call %s: classified as %q by the time of day of its traffic", call.ID, classes[call.ID])
+	}
+	for _, call := range graph.Calls {
+		call.TimeOfDay = classes[call.ID]
+	}
+	return nil
+}
+
+// Annotates the generated Sidecars and TrafficSettings with the calls they allow that only happen in or out of
+// business hours, so the unusual ones stand out when reviewing them
+func annotateTimeOfDay(graph *Graph, results []*typesv2.Object) {
+	annotateCalls(graph, results, timeOfDayAnnotation, func(call *Call) (string, bool) {
+		return call.TimeOfDay, call.TimeOfDay != "" && call.TimeOfDay != timeOfDayConstant
+	})
+}
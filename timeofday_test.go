@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns 24 hours of calls per minute starting at midnight, with cpm in the hours from-to and other elsewhere
+func testHourly(from, to int, cpm, other int64) []int64 {
+	hourly := make([]int64, 24)
+	for h := range hourly {
+		hourly[h] = other
+		if h >= from && h < to {
+			hourly[h] = cpm
+		}
+	}
+	return hourly
+}
+
+func TestClassifyTimeOfDay(t *testing.T) {
+	midnight := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	tests := []struct {
+		name     string
+		hourly   []int64
+		start    time.Time
+		location *time.Location
+		want     string
+	}{
+		{name: "no traffic", hourly: make([]int64, 24), start: midnight, location: time.UTC, want: ""},
+		{name: "business hours", hourly: testHourly(9, 18, 100, 0), start: midnight, location: time.UTC, want: timeOfDayBusinessHours},
+		{name: "nightly batch", hourly: testHourly(1, 4, 1000, 0), start: midnight, location: time.UTC, want: timeOfDayNightlyBatch},
+		{name: "constant", hourly: testHourly(0, 24, 10, 0), start: midnight, location: time.UTC, want: timeOfDayConstant},
+		{name: "mostly business hours", hourly: testHourly(9, 18, 100, 1), start: midnight, location: time.UTC, want: timeOfDayBusinessHours},
+		// 09:00-18:00 UTC are 04:00-13:00 in New York, half of it out of business hours
+		{name: "other time zone", hourly: testHourly(9, 18, 100, 0), start: midnight, location: newYork, want: timeOfDayConstant},
+		// the window starting mid-hour still counts from the hour it's in
+		{name: "start mid-hour", hourly: testHourly(9, 18, 100, 0), start: midnight.Add(30 * time.Minute), location: time.UTC, want: timeOfDayBusinessHours},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyTimeOfDay(tt.hourly, tt.start, [2]int{9, 18}, tt.location); got != tt.want {
+				t.Errorf("classifyTimeOfDay() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBusinessHours(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    [2]int
+		wantErr bool
+	}{
+		{value: "9-18", want: [2]int{9, 18}},
+		{value: "0-24", want: [2]int{0, 24}},
+		{value: "18-9", wantErr: true},
+		{value: "9-25", wantErr: true},
+		{value: "24-24", wantErr: true},
+		{value: "9", wantErr: true},
+		{value: "a-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBusinessHours(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBusinessHours(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"github.com/tetrateio/tetrate/pkg/api"
+	"istio.io/api/networking/v1beta1"
+)
+
+const (
+	destinationRuleKind = "DestinationRule"
+
+	// TLS origination modes of --tls-origination
+	tlsSimple = "simple"
+	tlsMutual = "mutual"
+)
+
+// A --tls-origination entry: the external destinations matching the pattern need their plaintext connections
+// originated as TLS by the sidecar
+type TLSOrigination struct {
+	Pattern string
+	Mode    string
+	// secret with the client certificate, for mutual
+	CredentialName string
+	// port the application sends plaintext to, or every port if zero
+	Port uint32
+}
+
+// Parses a --tls-origination entry, pattern=mode where mode is "simple" or "mutual/<credential>", optionally followed
+// by ":<port>"
+func parseTLSOrigination(pattern, value string) (*TLSOrigination, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid --tls-origination pattern %q: %w", pattern, err)
+	}
+	tls := &TLSOrigination{Pattern: pattern}
+	mode, port, hasPort := strings.Cut(value, ":")
+	if hasPort {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid --tls-origination %s=%s: invalid port %q", pattern, value, port)
+		}
+		tls.Port = uint32(n)
+	}
+	mode, credential, hasCredential := strings.Cut(mode, "/")
+	switch {
+	case mode == tlsSimple && !hasCredential:
+	case mode == tlsMutual && credential != "":
+		tls.CredentialName = credential
+	default:
+		return nil, fmt.Errorf("invalid --tls-origination %s=%s, must be %q or %q/<credential>, optionally followed by :<port>",
+			pattern, value, tlsSimple, tlsMutual)
+	}
+	tls.Mode = mode
+	return tls, nil
+}
+
+// Returns the --tls-origination entry for the host, the one with the longest pattern matching it, or nil if none does
+func matchTLSOrigination(rules []*TLSOrigination, host string) *TLSOrigination {
+	var match *TLSOrigination
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Pattern, host); ok && (match == nil || len(r.Pattern) > len(match.Pattern)) {
+			match = r
+		}
+	}
+	return match
+}
+
+// Returns a DestinationRule originating TLS for each destination of the calls matching a --tls-origination pattern,
+// in each namespace it is in, so the egress the generated Sidecars and TrafficSettings allow to it works for
+// applications sending it plaintext. Destinations are matched by their hostname.
+func tlsOriginationRules(rules []*TLSOrigination, graph *Graph) ([]*typesv2.Object, error) {
+	var results []*typesv2.Object
+	seen := make(map[string]bool)
+	for _, call := range graph.Calls {
+		host := call.TargetService.CanonicalName
+		rule := matchTLSOrigination(rules, host)
+		if host == "" || rule == nil {
+			continue
+		}
+		for _, ns := range call.TargetNamespaces {
+			name := "tls-origination-" + strings.ReplaceAll(strings.ReplaceAll(host, ".", "-"), "*", "wildcard")
+			if seen[ns+"/"+name] {
+				continue
+			}
+			seen[ns+"/"+name] = true
+
+			tls := &v1beta1.ClientTLSSettings{Mode: v1beta1.ClientTLSSettings_SIMPLE, Sni: host}
+			if rule.Mode == tlsMutual {
+				tls.Mode, tls.CredentialName = v1beta1.ClientTLSSettings_MUTUAL, rule.CredentialName
+			}
+			policy := &v1beta1.TrafficPolicy{Tls: tls}
+			if rule.Port != 0 {
+				policy = &v1beta1.TrafficPolicy{PortLevelSettings: []*v1beta1.TrafficPolicy_PortTrafficPolicy{{
+					Port: &v1beta1.PortSelector{Number: rule.Port},
+					Tls:  tls,
+				}}}
+			}
+			obj, err := newObject(api.IstioNetworkingBeta1API, destinationRuleKind,
+				&typesv2.ObjectMeta{Name: name, Namespace: ns},
+				&v1beta1.DestinationRule{Host: host, TrafficPolicy: policy})
+			if err != nil {
+				return nil, err
+			}
+			explainf(explainPolicy, "DestinationRule %s/%s: originating %s TLS to %s, matching --tls-origination %q",
+				ns, name, rule.Mode, host, rule.Pattern)
+			results = append(results, obj)
+		}
+	}
+	return results, nil
+}
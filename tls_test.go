@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseTLSOrigination(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           TLSOrigination
+		wantErr        bool
+	}{
+		{pattern: "*.example.com", value: "simple", want: TLSOrigination{Pattern: "*.example.com", Mode: tlsSimple}},
+		{pattern: "*.example.com", value: "simple:80", want: TLSOrigination{Pattern: "*.example.com", Mode: tlsSimple, Port: 80}},
+		{pattern: "api.example.com", value: "mutual/client-cert", want: TLSOrigination{Pattern: "api.example.com", Mode: tlsMutual, CredentialName: "client-cert"}},
+		{pattern: "api.example.com", value: "mutual/client-cert:8080", want: TLSOrigination{Pattern: "api.example.com", Mode: tlsMutual, CredentialName: "client-cert", Port: 8080}},
+		{pattern: "api.example.com", value: "mutual", wantErr: true},
+		{pattern: "api.example.com", value: "mutual/", wantErr: true},
+		{pattern: "api.example.com", value: "simple/client-cert", wantErr: true},
+		{pattern: "api.example.com", value: "istio", wantErr: true},
+		{pattern: "api.example.com", value: "simple:0", wantErr: true},
+		{pattern: "api.example.com", value: "simple:65536", wantErr: true},
+		{pattern: "api.example.com", value: "simple:https", wantErr: true},
+		{pattern: "[api.example.com", value: "simple", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"="+tt.value, func(t *testing.T) {
+			got, err := parseTLSOrigination(tt.pattern, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTLSOrigination(%q, %q) error = %v, wantErr %v", tt.pattern, tt.value, err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseTLSOrigination(%q, %q) = %+v, want %+v", tt.pattern, tt.value, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTLSOrigination(t *testing.T) {
+	wildcard := &TLSOrigination{Pattern: "*.example.com"}
+	api := &TLSOrigination{Pattern: "api.example.com"}
+	rules := []*TLSOrigination{wildcard, api}
+	tests := []struct {
+		host string
+		want *TLSOrigination
+	}{
+		{host: "api.example.com", want: api},
+		{host: "www.example.com", want: wildcard},
+		{host: "example.com", want: nil},
+		{host: "api.example.org", want: nil},
+	}
+	for _, tt := range tests {
+		if got := matchTLSOrigination(rules, tt.host); got != tt.want {
+			t.Errorf("matchTLSOrigination(%q) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/slices"
+)
+
+// Annotation of the generated objects allowing hosts across trust domains, listing the calls that need them
+const crossTrustDomainAnnotation = "generate-sidecar-tool/cross-trust-domain"
+
+// Returns the trust domains of the SPIFFE IDs of the service, sorted
+func trustDomains(svc *Service) []string {
+	var domains []string
+	for _, id := range svc.SpiffeIds {
+		u, err := url.Parse(id)
+		if err != nil || u.Scheme != "spiffe" || u.Host == "" {
+			debug("service %q: ignoring SPIFFE ID %q, it has no trust domain", svc.FQN, id)
+			continue
+		}
+		domains = mergeSorted(domains, []string{u.Host})
+	}
+	return domains
+}
+
+// Returns the trust domains of the source and target of the call, if they have no trust domain in common: the
+// namespaces they're in are then the same namespaces in different meshes rather than the same mesh. Services without
+// SPIFFE IDs are in the same trust domain as any other.
+func crossTrustDomain(call *Call) (string, string, bool) {
+	source, target := trustDomains(call.SourceService), trustDomains(call.TargetService)
+	if len(source) == 0 || len(target) == 0 {
+		return "", "", false
+	}
+	for _, td := range source {
+		if slices.Contains(target, td) {
+			return "", "", false
+		}
+	}
+	return strings.Join(source, ","), strings.Join(target, ","), true
+}
+
+// Drops the calls across trust domains with --require-cross-trust-domain-opt-in, unless --allow-cross-trust-domain.
+// Each pair of trust domains whose calls are dropped is reported once. They're kept otherwise, and only annotated.
+func checkTrustDomains(runtime *Runtime, graph *Graph) {
+	if !runtime.requireCrossTrustDomainOptIn || runtime.allowCrossTrustDomain {
+		return
+	}
+	reported := make(map[string]bool)
+	calls := graph.Calls[:0]
+	for _, call := range graph.Calls {
+		source, target, cross := crossTrustDomain(call)
+		if !cross {
+			calls = append(calls, call)
+			continue
+		}
+		if pair := source + " => " + target; !reported[pair] {
+			reported[pair] = true
+			diagnose(diagCrossTrustDomain, source, target)
+		}
+		explainf(explainGraph, "call %s: skipped, it goes from trust domain %s to %s", call.ID, source, target)
+	}
+	graph.Calls = calls
+}
+
+// Annotates the generated Sidecars and TrafficSettings allowing calls across trust domains with those calls, so the
+// reachability across meshes stands out in reviews
+func annotateCrossTrustDomain(graph *Graph, results []*typesv2.Object) {
+	annotateCalls(graph, results, crossTrustDomainAnnotation, func(call *Call) (string, bool) {
+		source, target, cross := crossTrustDomain(call)
+		return fmt.Sprintf("trust domain %s => %s", source, target), cross
+	})
+}
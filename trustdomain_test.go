@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns a service with the SPIFFE IDs
+func testSpiffeService(fqn string, ids ...string) *Service {
+	svc := testService(fqn, fqn)
+	svc.SpiffeIds = ids
+	return &svc
+}
+
+func TestTrustDomains(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []string
+		want []string
+	}{
+		{name: "one", ids: []string{"spiffe://cluster.local/ns/a/sa/a"}, want: []string{"cluster.local"}},
+		{name: "sorted and merged", ids: []string{"spiffe://west.example/ns/a/sa/a", "spiffe://east.example/ns/a/sa/a", "spiffe://west.example/ns/a/sa/b"}, want: []string{"east.example", "west.example"}},
+		{name: "no SPIFFE ID", want: nil},
+		{name: "not SPIFFE", ids: []string{"https://cluster.local/ns/a/sa/a"}, want: nil},
+		{name: "no trust domain", ids: []string{"spiffe:///ns/a/sa/a"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trustDomains(testSpiffeService("a", tt.ids...)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trustDomains(%v) = %v, want %v", tt.ids, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrossTrustDomain(t *testing.T) {
+	west := "spiffe://west.example/ns/a/sa/a"
+	east := "spiffe://east.example/ns/b/sa/b"
+	tests := []struct {
+		name                   string
+		source, target         []string
+		wantSource, wantTarget string
+		wantCross              bool
+	}{
+		{name: "same trust domain", source: []string{west}, target: []string{"spiffe://west.example/ns/b/sa/b"}},
+		{name: "different trust domains", source: []string{west}, target: []string{east}, wantSource: "west.example", wantTarget: "east.example", wantCross: true},
+		{name: "one in common", source: []string{west}, target: []string{east, "spiffe://west.example/ns/c/sa/c"}},
+		{name: "source without SPIFFE IDs", target: []string{east}},
+		{name: "target without SPIFFE IDs", source: []string{west}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := &Call{SourceService: testSpiffeService("a", tt.source...), TargetService: testSpiffeService("b", tt.target...)}
+			source, target, cross := crossTrustDomain(call)
+			if source != tt.wantSource || target != tt.wantTarget || cross != tt.wantCross {
+				t.Errorf("crossTrustDomain() = %q, %q, %v, want %q, %q, %v", source, target, cross, tt.wantSource, tt.wantTarget, tt.wantCross)
+			}
+		})
+	}
+}
+
+func TestCheckTrustDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		runtime Runtime
+		wantIDs []string
+	}{
+		{name: "default", wantIDs: []string{"cross", "same"}},
+		{name: "opt-in required", runtime: Runtime{requireCrossTrustDomainOptIn: true}, wantIDs: []string{"same"}},
+		{name: "opted in", runtime: Runtime{requireCrossTrustDomainOptIn: true, allowCrossTrustDomain: true}, wantIDs: []string{"cross", "same"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics = nil
+			defer func() { diagnostics = nil }()
+			west := testSpiffeService("a", "spiffe://west.example/ns/a/sa/a")
+			graph := &Graph{Calls: []*Call{
+				{ID: "cross", SourceService: west, TargetService: testSpiffeService("b", "spiffe://east.example/ns/b/sa/b")},
+				{ID: "same", SourceService: west, TargetService: testSpiffeService("c", "spiffe://west.example/ns/c/sa/c")},
+			}}
+			checkTrustDomains(&tt.runtime, graph)
+			var ids []string
+			for _, call := range graph.Calls {
+				ids = append(ids, call.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("checkTrustDomains() calls = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	typesv2 "github.com/tetrateio/api/tsb/types/v2"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const tuiHelp = `Commands:
+  graph           the namespaces each source namespace calls, with the number of calls
+  ns <namespace>  the generated policies of the namespace
+  warnings        the diagnostics of the generation
+  apply           apply the generated resources to the cluster, after confirming
+  refresh         fetch the topology again and regenerate
+  help            this help
+  quit            exit`
+
+// The state of an interactive session: the graph and what was generated for it
+type tuiSession struct {
+	runtime *Runtime
+	in      *bufio.Scanner
+	out     io.Writer
+
+	graph       *Graph
+	results     []*typesv2.Object
+	diagnostics []Diagnostic
+}
+
+func newTUICmd(runtime *Runtime) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Explore the graph, the generated policies and the warnings interactively, and apply them",
+		Long: `Explore the graph, the generated policies and the warnings interactively, and apply them.
+
+Rather than printing all of the output at once, the session generates the policies and then reads commands from
+stdin to show the graph, the policies of a namespace or the warnings, and to apply the policies once reviewed.
+Type 'help' for the commands.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := &tuiSession{runtime: runtime, in: bufio.NewScanner(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+			if err := s.refresh(); err != nil {
+				return err
+			}
+			return s.run()
+		},
+	}
+}
+
+// Fetches the graph and generates the resources for it
+func (s *tuiSession) refresh() error {
+	diagnostics, skippedHosts = nil, make(map[string][]string)
+	graph, err := fetchGraph(s.runtime)
+	if err != nil {
+		return err
+	}
+	results, err := generateResources(s.runtime, graph)
+	if err != nil {
+		return err
+	}
+	s.graph, s.results, s.diagnostics = graph, results, diagnostics
+	fmt.Fprintf(s.out, "%d calls, %d resources, %d warnings in %s; type 'help' for the commands\n",
+		len(graph.Calls), len(results), len(s.diagnostics), Window{Start: s.runtime.start, End: s.runtime.end})
+	return nil
+}
+
+// Reads and runs commands until quit or the end of the input
+func (s *tuiSession) run() error {
+	for {
+		fmt.Fprint(s.out, "> ")
+		if !s.in.Scan() {
+			fmt.Fprintln(s.out)
+			return s.in.Err()
+		}
+		fields := strings.Fields(s.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "graph", "g":
+			s.showGraph()
+		case "ns", "n":
+			if len(fields) != 2 {
+				fmt.Fprintln(s.out, "usage: ns <namespace>")
+				continue
+			}
+			s.showNamespace(fields[1])
+		case "warnings", "w":
+			s.showWarnings()
+		case "apply", "a":
+			err = s.apply()
+		case "refresh", "r":
+			err = s.refresh()
+		case "help", "h", "?":
+			fmt.Fprintln(s.out, tuiHelp)
+		case "quit", "q", "exit":
+			return nil
+		default:
+			fmt.Fprintf(s.out, "unknown command %q; type 'help' for the commands\n", fields[0])
+		}
+		if err != nil {
+			// keep the session going, the operator may fix the cause and retry
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (s *tuiSession) showGraph() {
+	// source namespace => destination namespace => calls
+	calls := make(map[string]map[string]int)
+	for _, call := range s.graph.Calls {
+		for _, src := range call.SourceNamespaces {
+			if calls[src] == nil {
+				calls[src] = make(map[string]int)
+			}
+			for _, dest := range call.TargetNamespaces {
+				calls[src][dest]++
+			}
+		}
+	}
+	sources := maps.Keys(calls)
+	slices.Sort(sources)
+
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE NAMESPACE\tDESTINATIONS")
+	for _, src := range sources {
+		dests := maps.Keys(calls[src])
+		slices.Sort(dests)
+		for i, dest := range dests {
+			dests[i] = fmt.Sprintf("%s (%d)", dest, calls[src][dest])
+		}
+		fmt.Fprintf(w, "%s\t%s\n", src, strings.Join(dests, ", "))
+	}
+	w.Flush()
+}
+
+// Shows the resources generated in the namespace, and the TrafficSettings of the groups its calls come from
+func (s *tuiSession) showNamespace(ns string) {
+	groups := make(map[string]bool)
+	for _, call := range s.graph.Calls {
+		if call.SourceTrafficGroup != nil && slices.Contains(call.SourceNamespaces, ns) {
+			groups[call.SourceTrafficGroup.FQN] = true
+		}
+	}
+	shown := 0
+	for _, obj := range s.results {
+		meta := obj.GetMetadata()
+		groupFQN := fmt.Sprintf("organizations/%s/tenants/%s/workspaces/%s/trafficgroups/%s",
+			meta.GetOrganization(), meta.GetTenant(), meta.GetWorkspace(), meta.GetGroup())
+		if meta.GetNamespace() != ns && !(meta.GetGroup() != "" && groups[groupFQN]) {
+			continue
+		}
+		manifest, err := kubernetesYAML(obj, s.runtime.gitopsNamespace)
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(s.out, "---\n%s", manifest)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Fprintf(s.out, "nothing is generated for namespace %q\n", ns)
+	}
+}
+
+func (s *tuiSession) showWarnings() {
+	if len(s.diagnostics) == 0 {
+		fmt.Fprintln(s.out, "no warnings")
+		return
+	}
+	for _, d := range s.diagnostics {
+		fmt.Fprintln(s.out, d.key())
+	}
+}
+
+// Applies the resources once the operator confirms it, with the checks, lock, pruning and state of a generation run
+func (s *tuiSession) apply() error {
+	fmt.Fprintf(s.out, "apply %d resources to the cluster? [y/N] ", len(s.results))
+	if !s.in.Scan() || strings.ToLower(strings.TrimSpace(s.in.Text())) != "y" {
+		fmt.Fprintln(s.out, "not applied")
+		return nil
+	}
+	release, err := acquireLock(s.runtime)
+	if err != nil {
+		return err
+	}
+	defer release()
+	state, err := loadState(s.runtime.stateFile)
+	if err != nil {
+		return err
+	}
+	if err := checkShrink(s.runtime, ownedState(s.runtime, state), s.graph); err != nil {
+		return err
+	}
+	revision, err := checkResults(s.runtime, s.graph, s.results)
+	if err != nil {
+		return err
+	}
+	applyFlag := s.runtime.apply
+	s.runtime.apply = true
+	defer func() { s.runtime.apply = applyFlag }()
+	if err := applyResults(s.runtime, state, s.graph, s.results, revision); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "applied %d resources\n", len(s.results))
+	return nil
+}
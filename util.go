@@ -1,8 +1,12 @@
 package main
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 func marshalToString(data interface{}) string {
@@ -13,10 +17,73 @@ func marshalToString(data interface{}) string {
 	return string(js)
 }
 
-func debugLogJSON(r *Runtime, data interface{}) {
-	if !r.verbose {
+// Logs the data as JSON. Payloads like the topology or the service list of big orgs can be tens of MB, so with
+// a dump dir the data is streamed gzipped into a file there instead, and otherwise it's truncated to the
+// configured size.
+func debugLogJSON(r *Runtime, name string, data interface{}) {
+	if !r.verbose || !r.debug {
 		return
 	}
 
-	debug(marshalToString(data))
+	if r.dumpDir != "" {
+		path, err := dumpJSON(r.dumpDir, name, data)
+		if err != nil {
+			debug("failed to dump %s: %v", name, err)
+			return
+		}
+		debug("dumped %s to %q", name, path)
+		return
+	}
+
+	w := &truncatingWriter{max: r.debugJSONMaxBytes}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(data); err != nil {
+		debug("failed to marshal %s into json: %v", name, err)
+		return
+	}
+	if w.total > len(w.buf) {
+		debug("%s: %s... (truncated, %d of %d bytes; use --dump-dir for the full document)", name, w.buf, len(w.buf), w.total)
+		return
+	}
+	debug("%s: %s", name, w.buf)
+}
+
+// Streams the data as gzipped JSON into a new file in the dir, with the credentials redacted, returning its path
+func dumpJSON(dir, name string, data interface{}) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json.gz", name, time.Now().Format("20060102T150405.000")))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	if err := json.NewEncoder(&redactingWriter{w: gz}).Encode(data); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// Keeps the first max bytes written to it, and counts the rest. A max of 0 keeps everything.
+type truncatingWriter struct {
+	max   int
+	buf   []byte
+	total int
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	w.total += len(p)
+	if room := w.max - len(w.buf); w.max == 0 || room >= len(p) {
+		w.buf = append(w.buf, p...)
+	} else if room > 0 {
+		w.buf = append(w.buf, p[:room]...)
+	}
+	return len(p), nil
 }